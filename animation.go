@@ -0,0 +1,168 @@
+package thumbnailer
+
+// #include "thumbnailer.h"
+import "C"
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"time"
+	"unsafe"
+)
+
+// Default animated thumbnail limits
+const (
+	DefaultAnimationFrames    = 50
+	DefaultAnimationDuration  = 5 * time.Second
+	DefaultAnimationFrameRate = 10
+)
+
+// AnimationOptions limit the resources spent on animated thumbnail
+// generation. Zero values are replaced with the defaults.
+type AnimationOptions struct {
+	// Maximum amount of frames in the animation.
+	//
+	// Defaults to DefaultAnimationFrames.
+	MaxFrames int
+
+	// Maximum time span of the source media to take frames from, starting
+	// from the first frame.
+	//
+	// Defaults to DefaultAnimationDuration.
+	MaxDuration time.Duration
+
+	// Maximum frames per second of the animation. Excess frames are dropped.
+	//
+	// Defaults to DefaultAnimationFrameRate.
+	FrameRate float64
+}
+
+func (o *AnimationOptions) setDefaults() {
+	if o.MaxFrames <= 0 {
+		o.MaxFrames = DefaultAnimationFrames
+	}
+	if o.MaxDuration <= 0 {
+		o.MaxDuration = DefaultAnimationDuration
+	}
+	if o.FrameRate <= 0 {
+		o.FrameRate = DefaultAnimationFrameRate
+	}
+}
+
+// Animation is an animated thumbnail.
+// Implements image.Image by delegating to the first frame.
+type Animation struct {
+	// Thumbnail frames in display order. All frames have the same dimensions.
+	Frames []*image.RGBA
+
+	// Display duration of each frame
+	Delays []time.Duration
+}
+
+// ColorModel implements image.Image
+func (a *Animation) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image
+func (a *Animation) Bounds() image.Rectangle {
+	return a.Frames[0].Bounds()
+}
+
+// At implements image.Image
+func (a *Animation) At(x, y int) color.Color {
+	return a.Frames[0].At(x, y)
+}
+
+// GIF converts the animation to a GIF image with a web-safe palette
+func (a *Animation) GIF() *gif.GIF {
+	g := &gif.GIF{
+		Image: make([]*image.Paletted, len(a.Frames)),
+		Delay: make([]int, len(a.Frames)),
+	}
+	for i, f := range a.Frames {
+		p := image.NewPaletted(f.Bounds(), palette.WebSafe)
+		draw.FloydSteinberg.Draw(p, p.Bounds(), f, image.Point{})
+		g.Image[i] = p
+
+		// GIF delays are in 100ths of a second. Browsers replace delays
+		// shorter than 2 with a much longer default delay.
+		d := int((a.Delays[i] + 10*time.Millisecond - 1) /
+			(10 * time.Millisecond))
+		if d < 2 {
+			d = 2
+		}
+		g.Delay[i] = d
+	}
+	return g
+}
+
+// ThumbnailAnimation generates an animated thumbnail from the first frames of
+// the media. Returned frames are scaled the same way as with Thumbnail.
+func (c *FFContext) ThumbnailAnimation(dims Dims, opts AnimationOptions,
+) (anim *Animation, err error) {
+	opts.setDefaults()
+
+	ci, err := c.codecContext(FFVideo)
+	if err != nil {
+		return
+	}
+
+	var cAnim C.struct_Animation
+	defer func() {
+		if cAnim.frames != nil {
+			frames := (*[1 << 30]C.struct_Buffer)(
+				unsafe.Pointer(cAnim.frames),
+			)[:opts.MaxFrames:opts.MaxFrames]
+			for _, f := range frames {
+				if f.data != nil {
+					C.free(unsafe.Pointer(f.data))
+				}
+			}
+			C.free(unsafe.Pointer(cAnim.frames))
+		}
+		if cAnim.delays != nil {
+			C.free(unsafe.Pointer(cAnim.delays))
+		}
+	}()
+	sample, freeSample := c.sampleBuffer()
+	defer freeSample()
+	ret := C.generate_animation(&cAnim, sample, c.avFormatCtx, ci.ctx,
+		ci.stream,
+		C.struct_Dims{
+			width:  C.uint64_t(dims.Width),
+			height: C.uint64_t(dims.Height),
+		},
+		c.scaleOptions(),
+		C.struct_AnimationOptions{
+			max_frames: C.int(opts.MaxFrames),
+			max_duration: C.int64_t(
+				opts.MaxDuration / time.Microsecond,
+			),
+			frame_rate: C.double(opts.FrameRate),
+		})
+	switch {
+	case ret != 0:
+		err = c.castError(ret)
+	case cAnim.size == 0:
+		err = ErrGetFrame
+	default:
+		c.storeSample(sample)
+		n := int(cAnim.size)
+		frames := (*[1 << 30]C.struct_Buffer)(
+			unsafe.Pointer(cAnim.frames),
+		)[:n:n]
+		delays := (*[1 << 30]C.int64_t)(unsafe.Pointer(cAnim.delays))[:n:n]
+		anim = &Animation{
+			Frames: make([]*image.RGBA, n),
+			Delays: make([]time.Duration, n),
+		}
+		for i := range frames {
+			anim.Frames[i] = rgbaImage(frames[i])
+			anim.Delays[i] = time.Duration(delays[i]) * time.Microsecond
+		}
+	}
+	return
+}
@@ -1,11 +1,18 @@
 package thumbnailer
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"sync"
 	"testing"
+
+	"github.com/bodgit/sevenzip"
 )
 
 type customReadSeeker struct {
@@ -62,3 +69,581 @@ func TestArchiveReadSeekerTypes(t *testing.T) {
 		file.Close()
 	}()
 }
+
+func TestComicArchives(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		file, mime, ext string
+	}{
+		{"sample.7z", mime7Zip, "7z"},
+		{"comic.7z", "application/x-cb7", "cb7"},
+		// Contains a directory and an empty file
+		{"nested.7z", mime7Zip, "7z"},
+		{"sample.tar", mimeTar, "tar"},
+		{"comic.tar", "application/x-cbt", "cbt"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			src, thumb, err := Process(f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Mime != c.mime || src.Extension != c.ext {
+				t.Fatalf("expected %s %s, got %s %s", c.mime, c.ext, src.Mime,
+					src.Extension)
+			}
+			if thumb == nil {
+				t.Fatal("no thumbnail")
+			}
+		})
+	}
+}
+
+func TestArchiveCoverSelection(t *testing.T) {
+	t.Parallel()
+
+	page := string(readSample(t, "too small.png"))
+	buf := buildZip(t,
+		[2]string{"credits.png", page},
+		[2]string{"02.png", page},
+		[2]string{"cover.jpg", string(readSample(t, "sample.jpg"))},
+	)
+
+	src, thumb, err := Process(bytes.NewReader(buf), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Entry != "cover.jpg" {
+		t.Fatalf("unexpected entry: %s", src.Entry)
+	}
+	// Only the cover is wider than tall
+	if b := thumb.Bounds(); b.Dx() <= b.Dy() {
+		t.Fatalf("wrong cover thumbnailed: %v", b)
+	}
+}
+
+func TestExtractCandidateBudget(t *testing.T) {
+	t.Parallel()
+
+	page := readSample(t, "too small.png")
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Enough for extracting only the first page. Each later page is preferred
+	// over the extracted one.
+	budget := int64(len(page) * 3 / 2)
+	sel := newCoverSelector()
+	for _, name := range [...]string{"03.png", "02.png", "01.png"} {
+		if isCandidate, _ := sel.add(name); isCandidate {
+			err = extractCandidate(&sel, tmp, bytes.NewReader(page),
+				int64(len(page)), &budget, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if cover, _ := sel.selected(); cover.entry != "03.png" {
+		t.Fatalf("unexpected cover: %s", cover.entry)
+	}
+	if budget != int64(len(page)/2) {
+		t.Fatalf("unexpected remaining budget: %d", budget)
+	}
+	buf, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, page) {
+		t.Fatal("extracted cover overwritten")
+	}
+}
+
+// Build a zip archive of the passed names and contents
+func buildZip(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+
+	var w bytes.Buffer
+	zw := zip.NewWriter(&w)
+	for _, f := range files {
+		fw, err := zw.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fw.Write([]byte(f[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Bytes()
+}
+
+func TestSniffedArchiveEntries(t *testing.T) {
+	t.Parallel()
+
+	page := string(readSample(t, "too small.png"))
+	nested := string(buildZip(t, [2]string{"01.png", page}))
+
+	cases := [...]struct {
+		name, entry string
+		archive     []byte
+	}{
+		{
+			name:  "extensionless",
+			entry: "page",
+			archive: buildZip(t,
+				[2]string{"readme", "Not an image"},
+				[2]string{"page", page},
+			),
+		},
+		{
+			name:  "nested archive",
+			entry: "01.png",
+			archive: buildZip(t,
+				[2]string{"000.png", nested},
+				[2]string{"01.png", page},
+			),
+		},
+		{
+			name:  "video",
+			entry: "clip.webm",
+			archive: buildZip(t,
+				[2]string{"clip.webm", string(readSample(t, "no_sound.webm"))},
+				[2]string{"notes.txt", "Not a video"},
+			),
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			src, thumb, err := Process(bytes.NewReader(c.archive), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Entry != c.entry {
+				t.Fatalf("expected entry %q, got %q", c.entry, src.Entry)
+			}
+			if thumb == nil {
+				t.Fatal("no thumbnail")
+			}
+		})
+	}
+}
+
+func TestCanThumbnailEntry(t *testing.T) {
+	t.Parallel()
+
+	for mime, std := range map[string]bool{
+		"image/png":        true,
+		"video/webm":       true,
+		mimePDF:            true,
+		mimeZip:            false,
+		mimeTar:            false,
+		mimeGzip:           false,
+		"application/json": false,
+	} {
+		if res := canThumbnailEntry(mime); res != std {
+			t.Errorf("%s: expected %v, got %v", mime, std, res)
+		}
+	}
+}
+
+func TestDecompressors(t *testing.T) {
+	t.Parallel()
+
+	std := readSample(t, "too small.png")
+
+	cases := [...]struct {
+		file, mime, ext string
+	}{
+		{"image.png.gz", mimeGzip, "gz"},
+		{"image.png.bz2", mimeBzip2, "bz2"},
+		{"image.png.xz", mimeXz, "xz"},
+		{"image.png.zst", mimeZstd, "zst"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			mime, ext, err := DetectMIME(f, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mime != c.mime || ext != c.ext {
+				t.Fatalf("expected %s %s, got %s %s", c.mime, c.ext, mime, ext)
+			}
+			_, err = f.Seek(0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dec, err := newDecompressor(mime, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			buf, err := ioutil.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, std) {
+				t.Fatal("decompressed content does not match")
+			}
+		})
+	}
+}
+
+func TestCompressedFiles(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		file, mime, ext string
+		dims            Dims
+	}{
+		{"image.png.gz", mimeGzip, "gz", Dims{121, 150}},
+		{"image.png.bz2", mimeBzip2, "bz2", Dims{121, 150}},
+		{"image.png.xz", mimeXz, "xz", Dims{121, 150}},
+		{"image.png.zst", mimeZstd, "zst", Dims{121, 150}},
+
+		// Dimensions of archive entries are not reported
+		{"comic.tar.gz", mimeGzip, "gz", Dims{}},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			src, thumb, err := Process(f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Mime != c.mime || src.Extension != c.ext {
+				t.Fatalf("expected %s %s, got %s %s", c.mime, c.ext, src.Mime,
+					src.Extension)
+			}
+			if src.Dims != c.dims {
+				t.Fatalf("expected dimensions %v, got %v", c.dims, src.Dims)
+			}
+			if thumb == nil {
+				t.Fatal("no thumbnail")
+			}
+		})
+	}
+}
+
+func TestNestedCompression(t *testing.T) {
+	t.Parallel()
+
+	var w bytes.Buffer
+	gw := gzip.NewWriter(&w)
+	_, err := gw.Write(readSample(t, "image.png.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = Process(bytes.NewReader(w.Bytes()), Options{})
+	if err != ErrCantThumbnail {
+		t.Fatalf("expected %v, got %v", ErrCantThumbnail, err)
+	}
+}
+
+func TestDecodeName(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, encoded, std string
+	}{
+		{"UTF-8", "表紙.jpg", "表紙.jpg"},
+		{"Shift-JIS", "\x95\x5c\x8e\x86.jpg", "表紙.jpg"},
+		{"half width katakana", "\xb6\xca\xde\xb0.jpg", "ｶﾊﾞｰ.jpg"},
+		{"CP437", "Caf\x82 01.jpg", "Café 01.jpg"},
+		{"CP437 with valid Shift-JIS", "\x81ber.png", "über.png"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			if res := decodeName(c.encoded); res != c.std {
+				t.Fatalf("expected %q, got %q", c.std, res)
+			}
+		})
+	}
+}
+
+func TestZipCrypto(t *testing.T) {
+	t.Parallel()
+
+	buf := readSample(t, "encrypted.zip")
+	ra := bytes.NewReader(buf)
+	r, err := zip.NewReader(ra, int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := readSample(t, "too small.png")
+
+	for _, f := range r.File {
+		for _, password := range [...]string{"", "wrong"} {
+			_, err := openZipFile(f, ra, password)
+			if err != ErrEncrypted {
+				t.Fatalf("%s: expected %v with password %q, got %v", f.Name,
+					ErrEncrypted, password, err)
+			}
+		}
+
+		rc, err := openZipFile(f, ra, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, std) {
+			t.Fatalf("%s: decrypted content does not match", f.Name)
+		}
+	}
+}
+
+func TestEncryptedArchives(t *testing.T) {
+	t.Parallel()
+
+	page := string(readSample(t, "too small.png"))
+	var (
+		// Encryption record with password check data for an incorrect
+		// password
+		check     = []byte("password")
+		checkSum  = sha256.Sum256(check)
+		encRecord = buildRar5Record(1, bytes.Join(
+			[][]byte{{0, 1, 0}, make([]byte, 32), check, checkSum[:4]},
+			nil,
+		))
+		rarHeader = append(
+			[]byte(rar5Signature),
+			buildRar5Header(rar5Archive, 0, []byte{0}, nil, nil)...,
+		)
+		rarEnd = buildRar5Header(rar5End, 0, []byte{0}, nil, nil)
+	)
+	buildRar := func(files ...[]byte) []byte {
+		return bytes.Join(
+			append(append([][]byte{rarHeader}, files...), rarEnd),
+			nil,
+		)
+	}
+
+	cases := [...]struct {
+		name, password, entry string
+		archive               []byte
+		err                   error
+	}{
+		{
+			name:    "zip without password",
+			archive: readSample(t, "encrypted.zip"),
+			err:     ErrEncrypted,
+		},
+		{
+			name:     "zip with incorrect password",
+			archive:  readSample(t, "encrypted.zip"),
+			password: "wrong",
+			err:      ErrEncrypted,
+		},
+		{
+			name:     "zip with password",
+			archive:  readSample(t, "encrypted.zip"),
+			password: "secret",
+			entry:    "01.png",
+		},
+		{
+			name:    "7z without password",
+			archive: readSample(t, "encrypted.7z"),
+			err:     ErrEncrypted,
+		},
+		{
+			name:     "7z with incorrect password",
+			archive:  readSample(t, "encrypted.7z"),
+			password: "wrong",
+			err:      ErrEncrypted,
+		},
+		{
+			name:     "7z with password",
+			archive:  readSample(t, "encrypted.7z"),
+			password: "secret",
+			entry:    "01.png",
+		},
+		{
+			name:    "7z with encrypted headers",
+			archive: readSample(t, "encrypted_headers.7z"),
+			err:     ErrEncrypted,
+		},
+		{
+			name:     "7z with encrypted headers and password",
+			archive:  readSample(t, "encrypted_headers.7z"),
+			password: "secret",
+			entry:    "01.png",
+		},
+		{
+			name: "rar with encrypted entries",
+			archive: buildRar(
+				buildRar5File("01.png", 0, 0, 0, encRecord, page),
+				buildRar5File("02.png", 0, 0, 0, encRecord, page),
+			),
+			err: ErrEncrypted,
+		},
+		{
+			name: "rar with encrypted and unencrypted entries",
+			archive: buildRar(
+				buildRar5File("01.png", 0, 0, 0, nil, page),
+				buildRar5File("02.png", 0, 0, 0, encRecord, page),
+			),
+			entry: "01.png",
+		},
+		{
+			name: "rar with encrypted headers",
+			archive: append(
+				[]byte(rar5Signature),
+				buildRar5Header(rar5Encryption, 0, make([]byte, 18), nil,
+					nil)...,
+			),
+			err: ErrEncrypted,
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			src, _, err := Process(bytes.NewReader(c.archive), Options{
+				ArchivePassword: c.password,
+			})
+			if err != c.err {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+			if src.Entry != c.entry {
+				t.Fatalf("expected entry %q, got %q", c.entry, src.Entry)
+			}
+		})
+	}
+}
+
+func TestMultiVolumeArchives(t *testing.T) {
+	t.Parallel()
+
+	page := string(readSample(t, "too small.png"))
+	zipVolume := buildZip(t, [2]string{"01.png", page})
+	i := bytes.LastIndex(zipVolume, []byte("PK\x05\x06"))
+	zipVolume[i+4] = 1 // Number of this disk
+
+	cases := [...]struct {
+		name    string
+		archive []byte
+	}{
+		{
+			"rar",
+			bytes.Join(
+				[][]byte{
+					[]byte(rar5Signature),
+					buildRar5Header(rar5Archive, 0, []byte{0x1}, nil, nil),
+					buildRar5File("01.png", 0, 0x0010, 0, nil, page),
+				},
+				nil,
+			),
+		},
+		{
+			"rar 4",
+			bytes.Join(
+				[][]byte{
+					[]byte(rar4Signature),
+					buildRar4Block(rar4Archive, 0x0001, make([]byte, 6)),
+					buildRar4File("01.png", 0x0002, 0, page),
+				},
+				nil,
+			),
+		},
+		{
+			"first zip volume",
+			append(
+				[]byte("PK\x07\x08"),
+				buildZip(t, [2]string{"01.png", page})...,
+			),
+		},
+		{"last zip volume", zipVolume},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := Process(bytes.NewReader(c.archive), Options{})
+			if err != ErrMultiVolume {
+				t.Fatalf("expected %v, got %v", ErrMultiVolume, err)
+			}
+		})
+	}
+}
+
+func TestSolidPreceding(t *testing.T) {
+	t.Parallel()
+
+	file := func(stream int, size uint64) *sevenzip.File {
+		return &sevenzip.File{
+			FileHeader: sevenzip.FileHeader{
+				Stream:           stream,
+				UncompressedSize: size,
+			},
+		}
+	}
+
+	files := []*sevenzip.File{
+		file(0, 10),
+		file(0, 20),
+		file(0, 0), // Empty files and directories report the first block
+		file(1, 5),
+		file(0, 30),
+		file(1, 7),
+		file(2, math.MaxUint64),
+		file(2, 1),
+	}
+	std := []int64{0, 10, 0, 0, 30, 5, 0, math.MaxInt64}
+
+	res := solidPreceding(files)
+	for i := range std {
+		if res[i] != std[i] {
+			t.Fatalf("file %d: expected %d, got %d", i, std[i], res[i])
+		}
+	}
+}
@@ -1,31 +1,287 @@
 package thumbnailer
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"image"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/bodgit/sevenzip"
 	"github.com/nwaples/rardecode"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
 )
 
 const (
 	mimeZip  = "application/zip"
 	mime7Zip = "application/x-7z-compressed"
 	mimeRar  = "application/x-rar-compressed"
+	mimeTar  = "application/x-tar"
+
+	// Maximum number of archive entries considered for the cover
+	maxArchiveEntries = 1 << 12
+
+	// Maximum number of cover candidates of an archive, that are sniffed to
+	// find a processable one
+	maxSniffedEntries = 32
+
+	// Maximum size of an entry of an archive read in sequence, that is
+	// extracted as the cover
+	maxExtractedSize = 100 << 20
+
+	// Maximum total size of the entries of an archive read in sequence, that
+	// are sniffed and extracted. Better cover candidates replace the extracted
+	// cover only, until this is exhausted.
+	maxTotalExtractedSize = 4 * maxExtractedSize
 )
 
-// Thumbnail the first image of a zip file
+// Thumbnail the cover image of a zip file
 func processZip(rs io.ReadSeeker, src *Source, opts Options,
 ) (thumb image.Image, err error) {
-	// Obtain io.ReaderAt and find out the size of the file
+	ra, size, cleanup, err := readerAt(rs, opts)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	if isZipVolume(ra, size) {
+		err = ErrMultiVolume
+		return
+	}
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return
+	}
+	open := func(f *zip.File) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return openZipFile(f, ra, opts.ArchivePassword)
+		}
+	}
+
 	var (
-		size int64
-		ra   io.ReaderAt
+		sel   = newCoverSelector()
+		files []*zip.File // Files added to sel
+		meta  *zip.File
 	)
+	for _, f := range r.File {
+		if sel.files == maxArchiveEntries {
+			break
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, f)
+		if _, isMeta := sel.add(decodeName(f.Name)); isMeta {
+			meta = f
+		}
+	}
+
+	if sel.isComic() {
+		src.Mime = "application/vnd.comicbook+zip"
+		src.Extension = "cbz"
+		var data []byte
+		if meta != nil {
+			data = readComicMeta(open(meta), opts)
+		}
+		sel.fillComic(src, data)
+	}
+
+	cover, ok, err := sniffCover(
+		&sel,
+		func(i int) (io.ReadCloser, error) {
+			return open(files[i])()
+		},
+		opts,
+	)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = ErrCantThumbnail
+		return
+	}
+	src.Entry = cover.entry
+
+	f, err := open(files[cover.index])()
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	thumb, err = thumbnailArchiveImage(f, src, opts, size*4)
+	return
+}
+
+// Thumbnail the cover image of a 7z file
+func process7z(rs io.ReadSeeker, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	ra, size, cleanup, err := readerAt(rs, opts)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	r, err := sevenzip.NewReaderWithPassword(ra, size, opts.ArchivePassword)
+	if err != nil {
+		err = error7z(err)
+		return
+	}
+
+	var (
+		sel   = newCoverSelector()
+		files []int // Indices of the files added to sel
+		meta  = -1
+	)
+	for i, f := range r.File {
+		if sel.files == maxArchiveEntries {
+			break
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, i)
+		if _, isMeta := sel.add(f.Name); isMeta {
+			meta = i
+		}
+	}
+
+	// All data decompressed to read metadata, sniff and extract files is
+	// charged against a single budget. Reading a file also decompresses the
+	// files preceding it in its solid block.
+	var (
+		budget    = size * 4
+		preceding = solidPreceding(r.File)
+	)
+	charge := func(i int, n int64) bool {
+		if preceding[i] > budget-n {
+			return false
+		}
+		budget -= preceding[i] + n
+		return true
+	}
+	readSize := func(i int, max int64) int64 {
+		if n := r.File[i].UncompressedSize; n < uint64(max) {
+			return int64(n)
+		}
+		return max
+	}
+
+	if sel.isComic() {
+		src.Mime = "application/x-cb7"
+		src.Extension = "cb7"
+		var data []byte
+		if meta != -1 && charge(meta, readSize(meta, maxComicMetaSize+1)) {
+			data = readComicMeta(r.File[meta].Open, opts)
+		}
+		sel.fillComic(src, data)
+	}
+
+	cover, ok, err := sniffCover(
+		&sel,
+		func(i int) (io.ReadCloser, error) {
+			if !charge(files[i], readSize(files[i], sniffSize)) {
+				return nil, ErrArchive{ErrCantThumbnail}
+			}
+			return open7z(r.File[files[i]])
+		},
+		opts,
+	)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = ErrCantThumbnail
+		return
+	}
+	src.Entry = cover.entry
+
+	i := files[cover.index]
+	if !charge(i, 0) {
+		err = ErrArchive{ErrCantThumbnail}
+		return
+	}
+	f, err := open7z(r.File[i])
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	thumb, err = thumbnailArchiveImage(f, src, opts, budget)
+	return
+}
+
+// Open a file of a 7z archive. Failures to decrypt the file are returned as
+// ErrEncrypted.
+func open7z(f *sevenzip.File) (io.ReadCloser, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, error7z(err)
+	}
+	return reader7z{rc}, nil
+}
+
+// Returns failures to decrypt a file of a 7z archive as ErrEncrypted
+type reader7z struct {
+	io.ReadCloser
+}
+
+func (r reader7z) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err != nil {
+		err = error7z(err)
+	}
+	return
+}
+
+// Convert errors of reading encrypted headers or files of a 7z archive to
+// ErrEncrypted. Data decrypted with a missing or incorrect password is invalid
+// and fails to decompress.
+func error7z(err error) error {
+	var re *sevenzip.ReadError
+	if errors.As(err, &re) && re.Encrypted {
+		return ErrEncrypted
+	}
+	return err
+}
+
+// Returns the total size of the files preceding each file of a 7z archive in
+// its solid block. Files in solid blocks are decompressed from the start of
+// the block.
+func solidPreceding(files []*sevenzip.File) []int64 {
+	var (
+		preceding = make([]int64, len(files))
+		blocks    = make(map[int]int64)
+	)
+	for i, f := range files {
+		if f.UncompressedSize == 0 {
+			// Empty files and directories are not stored in any block
+			continue
+		}
+		preceding[i] = blocks[f.Stream]
+		n := blocks[f.Stream] + int64(f.UncompressedSize)
+		if f.UncompressedSize > math.MaxInt64 || n < 0 {
+			n = math.MaxInt64
+		}
+		blocks[f.Stream] = n
+	}
+	return preceding
+}
+
+// Obtain io.ReaderAt and find out the size of the file. Exotic io.ReadSeeker
+// implementations are dumped to a temporary file, that is removed by cleanup.
+func readerAt(rs io.ReadSeeker, opts Options) (
+	ra io.ReaderAt, size int64, cleanup func(), err error,
+) {
+	cleanup = func() {}
 
 	useFile := func(f *os.File) (err error) {
 		info, err := f.Stat()
@@ -40,9 +296,6 @@ func processZip(rs io.ReadSeeker, src *Source, opts Options,
 	switch rs.(type) {
 	case *os.File:
 		err = useFile(rs.(*os.File))
-		if err != nil {
-			return
-		}
 	case *bytes.Reader:
 		r := rs.(*bytes.Reader)
 		ra = r
@@ -54,119 +307,371 @@ func processZip(rs io.ReadSeeker, src *Source, opts Options,
 		if err != nil {
 			return
 		}
-		defer os.Remove(tmp.Name())
-		defer tmp.Close()
+		cleanup = func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+		defer func() {
+			if err != nil {
+				cleanup()
+			}
+		}()
 
-		_, err = io.Copy(tmp, rs)
+		_, err = io.Copy(tmp, contextReader{opts.Context(), rs})
 		if err != nil {
 			return
 		}
 		err = useFile(tmp)
-		if err != nil {
-			return
-		}
 	}
+	return
+}
 
-	r, err := zip.NewReader(ra, size)
-	if err != nil {
-		return
+// Returns, if a zip file is a volume of a split archive. The first volume
+// starts with a spanning marker. The end of central directory record of the
+// last volume refers to the other volumes.
+func isZipVolume(ra io.ReaderAt, size int64) bool {
+	var sig [4]byte
+	_, err := ra.ReadAt(sig[:], 0)
+	if err == nil && string(sig[:]) == "PK\x07\x08" {
+		return true
 	}
 
-	var (
-		imageCount = 0
-		firstImage *zip.File
-	)
-	// Only check the first 10 files. We don't need to check them all.
-	for i := 0; i < 10 && i < len(r.File); i++ {
-		f := r.File[i]
-		if couldBeImage(f.Name) {
-			if firstImage == nil {
-				firstImage = f
-			}
-			imageCount++
+	// The record is located at the end of the file followed by a comment of
+	// at most 64 KiB
+	n := int64(22 + 0xFFFF)
+	if n > size {
+		n = size
+	}
+	buf := make([]byte, n)
+	_, err = ra.ReadAt(buf, size-n)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	i := bytes.LastIndex(buf, []byte("PK\x05\x06"))
+	if i == -1 || len(buf)-i < 22 {
+		return false
+	}
+
+	disk := binary.LittleEndian.Uint16(buf[i+4:])
+	dirDisk := binary.LittleEndian.Uint16(buf[i+6:])
+	if disk == 0xFFFF || dirDisk == 0xFFFF {
+		// Zip64 archives store the total amount of disks in the zip64 end of
+		// central directory locator preceding the record
+		if i < 20 || string(buf[i-20:i-16]) != "PK\x06\x07" {
+			return false
 		}
+		return binary.LittleEndian.Uint32(buf[i-4:]) > 1
 	}
+	return disk != 0 || dirDisk != 0
+}
 
-	// If at least 90% of the first 10 files in the archive root are images,
-	// this is a comic archive
-	if float32(imageCount)/float32(len(r.File)) >= 0.9 {
-		src.Mime = "application/vnd.comicbook+zip"
-		src.Extension = "cbz"
+// Extensions of image files
+var imageExtensions = map[string]bool{
+	"jpg": true, "jpeg": true, "jpe": true, "png": true, "apng": true,
+	"gif": true, "webp": true, "bmp": true, "tif": true, "tiff": true,
+	"tga": true, "jp2": true, "j2k": true, "qoi": true, "dds": true,
+	"pcx": true, "sgi": true, "exr": true, "hdr": true, "psd": true,
+	"psb": true,
+}
+
+// Extensions of other possibly processable media files
+var mediaExtensions = map[string]bool{
+	"ico": true, "svg": true, "pdf": true, "mp4": true, "m4v": true,
+	"webm": true, "mkv": true, "avi": true, "mov": true, "wmv": true,
+	"flv": true, "ogg": true, "ogv": true, "oga": true, "opus": true,
+	"mp3": true, "aac": true, "wav": true, "flac": true, "mid": true,
+	"midi": true,
+}
+
+// Returns the lower case extension of a file name without the dot
+func extension(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// Returns, if file could be an image file, based on it's extension
+func couldBeImage(name string) bool {
+	return imageExtensions[extension(name)]
+}
+
+// Returns, if file could be a processable media file, based on it's
+// extension. Files without an extension could be anything.
+func couldBeMedia(name string) bool {
+	ext := extension(name)
+	return ext == "" || imageExtensions[ext] || mediaExtensions[ext]
+}
+
+// Returns, if a file of the MIME type can be thumbnailed as an archive entry.
+// Nested archives are not processed, so archives can not recurse.
+func canThumbnailEntry(mime string) bool {
+	switch mime {
+	case mimeZip, mimeRar, mime7Zip, mimeTar:
+		return false
+	default:
+		return !compressedMIMEs[mime] && processorFor(mime) != nil
 	}
+}
 
-	if firstImage == nil {
-		err = ErrCantThumbnail
-		return
+// Read the first sniffSize bytes of an archive entry
+func readHead(ctx context.Context, r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(contextReader{ctx, r}, head)
+	switch err {
+	case nil, io.EOF, io.ErrUnexpectedEOF:
+		return head[:n], nil
+	default:
+		return nil, err
 	}
+}
+
+// Detect the MIME type of a file from its first sniffSize bytes. Returns an
+// empty string, if the type is not known.
+func sniffMIME(head []byte) string {
+	mime, _, err := DetectMIME(bytes.NewReader(head), nil)
+	if err != nil {
+		return ""
+	}
+	return mime
+}
 
-	f, err := firstImage.Open()
+// Read the first sniffSize bytes of an archive entry and return them and, if
+// the entry can be thumbnailed, based on its detected MIME type
+func sniffEntry(r io.Reader, opts Options,
+) (head []byte, ok bool, err error) {
+	head, err = readHead(opts.Context(), r)
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	thumb, err = thumbnailArchiveImage(f, opts, size*4)
+	ok = canThumbnailEntry(sniffMIME(head))
 	return
 }
 
-// Returns, if file could be an image file, based on it's extension
-func couldBeImage(name string) bool {
-	if len(name) < 4 {
-		return false
+// Select the most preferred cover candidate of an archive with random access,
+// that can be thumbnailed. open opens the file with the passed index among
+// the files added to the coverSelector. Candidates, that fail to open or fail
+// to be read with ErrEncrypted, are skipped. If no candidate could be selected
+// and some failed with ErrEncrypted, ErrEncrypted is returned.
+func sniffCover(sel *coverSelector, open func(int) (io.ReadCloser, error),
+	opts Options,
+) (c coverCandidate, ok bool, err error) {
+	encrypted := false
+	for i, c := range sel.ranked() {
+		// Only sniff the most preferred candidates
+		if i == maxSniffedEntries {
+			break
+		}
+		if err = opts.Context().Err(); err != nil {
+			return c, false, err
+		}
+
+		rc, openErr := open(c.index)
+		if openErr != nil {
+			encrypted = encrypted || openErr == ErrEncrypted
+			continue
+		}
+		_, ok, err = sniffEntry(rc, opts)
+		rc.Close()
+		if err == ErrEncrypted {
+			encrypted = true
+			err = nil
+			continue
+		}
+		if err != nil || ok {
+			return c, ok, err
+		}
 	}
-	name = strings.ToLower(name[len(name)-4:])
-	for _, ext := range [...]string{".png", ".jpg", ".jpeg", ".webp"} {
-		if strings.HasSuffix(name, ext) {
-			return true
+	if encrypted {
+		err = ErrEncrypted
+	}
+	return
+}
+
+// Decode an archive entry name, that is not valid UTF-8. Such names are
+// stored in the OEM code page of the system of the archiver, which is
+// Shift-JIS for most Japanese comics and CP437 for most others.
+func decodeName(name string) string {
+	if utf8.ValidString(name) {
+		return name
+	}
+	s, err := japanese.ShiftJIS.NewDecoder().String(name)
+	if err == nil && isJapanese(s) {
+		return s
+	}
+	s, _ = charmap.CodePage437.NewDecoder().String(name)
+	return s
+}
+
+// Returns, if s contains Japanese characters and all other non-ASCII
+// characters are Japanese punctuation
+func isJapanese(s string) bool {
+	japanese := false
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han):
+			japanese = true
+		case r < utf8.RuneSelf,
+			r >= 0x3000 && r <= 0x303F, // CJK symbols and punctuation
+			r >= 0xFF00 && r <= 0xFFEF: // Half and full width forms
+		default:
+			return false
 		}
 	}
-	return false
+	return japanese
 }
 
 // Thumbnail image in from an arechive
-func thumbnailArchiveImage(r io.Reader, opts Options, sizeLimit int64,
+func thumbnailArchiveImage(r io.Reader, src *Source, opts Options,
+	sizeLimit int64,
 ) (thumb image.Image, err error) {
-	// Accept anything we can process
-	opts.AcceptedMimeTypes = nil
-
 	// Compressed files do not provide seeking.
 	// Temporary file to conserve RAM.
 	tmp, err := ioutil.TempFile("", "")
 	if err != nil {
-		goto end
+		err = ErrArchive{err}
+		return
 	}
 	defer os.Remove(tmp.Name())
 	defer tmp.Close()
 
-	// LimitReader protects against decompression bombs
-	_, err = io.Copy(tmp, io.LimitReader(r, sizeLimit))
+	err = extractArchiveImage(tmp, r, opts, sizeLimit)
 	if err != nil {
-		goto end
+		return
 	}
+	return thumbnailExtractedImage(tmp, src, opts)
+}
 
-	_, thumb, err = Process(tmp, opts)
+// Extract an image from an archive into tmp, replacing any previously
+// extracted image
+func extractArchiveImage(tmp *os.File, r io.Reader, opts Options,
+	sizeLimit int64,
+) (err error) {
+	_, err = tmp.Seek(0, 0)
+	if err == nil {
+		err = tmp.Truncate(0)
+	}
+	if err == nil {
+		// LimitReader protects against decompression bombs
+		_, err = io.Copy(
+			tmp,
+			io.LimitReader(contextReader{opts.Context(), r}, sizeLimit),
+		)
+	}
+	if err != nil {
+		err = ErrArchive{err}
+	}
+	return
+}
 
-end:
+// Thumbnail an image extracted from an archive with extractArchiveImage
+func thumbnailExtractedImage(tmp *os.File, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	_, thumb, err = processEmbedded(opts.Context(), tmp, src, opts)
 	if err != nil {
 		err = ErrArchive{err}
 	}
 	return
 }
 
-// Thumbnail the first image of a rar file
+// Select a cover candidate of an archive read in sequence as the cover and
+// extract it, if it can be thumbnailed. size is the size of the candidate or
+// -1, if not known. Sniffed and extracted data is charged against budget.
+// Once a cover is extracted, candidates, that do not fit into the remaining
+// budget, are skipped and the extracted cover is kept.
+func extractCandidate(sel *coverSelector, tmp *os.File, r io.Reader,
+	size int64, budget *int64, opts Options,
+) error {
+	limit := *budget
+	if limit > maxExtractedSize {
+		limit = maxExtractedSize
+	}
+	if size < 0 || size > limit {
+		// Replacing the extracted cover with a possibly truncated entry could
+		// lose the only thumbnailable candidate
+		if _, ok := sel.selected(); ok {
+			return nil
+		}
+		size = limit
+	}
+	if size == 0 {
+		return nil
+	}
+
+	head, ok, err := sniffEntry(r, opts)
+	if err != nil {
+		return ErrArchive{err}
+	}
+	*budget -= int64(len(head))
+	if !ok {
+		return nil
+	}
+	if n := size - int64(len(head)); n > 0 {
+		*budget -= n
+	}
+	sel.choose()
+	return extractArchiveImage(
+		tmp,
+		io.MultiReader(bytes.NewReader(head), r),
+		opts,
+		size,
+	)
+}
+
+// Thumbnail the cover image of a rar file
 func processRar(rs io.ReadSeeker, src *Source, opts Options,
 ) (thumb image.Image, err error) {
-	dec, err := rardecode.NewReader(rs, "")
+	// Read the headers first to detect encryption and volumes without
+	// decompressing anything
+	a, err := scanRar(opts.Context(), rs, maxArchiveEntries)
+	if err != nil {
+		return
+	}
+	switch {
+	case a.volume:
+		err = ErrMultiVolume
+		return
+	case a.encrypted && opts.ArchivePassword == "":
+		err = ErrEncrypted
+		return
+	}
+	_, err = rs.Seek(0, 0)
 	if err != nil {
 		return
 	}
 
+	dec, err := rardecode.NewReader(
+		contextReader{opts.Context(), rs},
+		opts.ArchivePassword,
+	)
+	if err != nil {
+		return
+	}
+
+	// Entries can only be read in sequence, so the best processable cover
+	// candidate so far is extracted, until a better one is found
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
 	var (
-		imageCount = 0
-		i          = 0
-		h          *rardecode.FileHeader
+		sel    = newCoverSelector()
+		h      *rardecode.FileHeader
+		meta   []byte
+		budget = int64(maxTotalExtractedSize)
+
+		// Encrypted entries were skipped
+		skipped bool
 	)
-	// Only check the first 10 files. We don't need to check them all.
-	for i = 0; i < 10; i++ {
+	readEntry := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(dec), nil
+	}
+	// Returns, if the i-th entry is encrypted. Unknown for all entries of
+	// archives with encrypted headers.
+	isEncrypted := func(i int) bool {
+		return a.encrypted || i < len(a.files) && a.files[i].encrypted
+	}
+	for i := 0; sel.files < maxArchiveEntries; i++ {
 		h, err = dec.Next()
 		switch err {
 		case nil:
@@ -174,30 +679,132 @@ func processRar(rs io.ReadSeeker, src *Source, opts Options,
 			err = nil
 			goto endLoop
 		default:
+			// rardecode fails on encrypted entries, if the password is missing
+			// or incorrect, so only the preceding entries can be read
+			if isEncrypted(i) {
+				err = nil
+				skipped = true
+				goto endLoop
+			}
 			return
 		}
-		if couldBeImage(h.Name) {
-			imageCount++
-			if imageCount == 1 {
-				thumb, err = thumbnailArchiveImage(dec, opts, 100<<20)
-				if err != nil {
-					return
-				}
+		if h.IsDir {
+			continue
+		}
+		isCandidate, isMeta := sel.add(decodeName(h.Name))
+		if isEncrypted(i) && opts.ArchivePassword == "" {
+			skipped = true
+			continue
+		}
+		switch {
+		case isCandidate:
+			size := h.UnPackedSize
+			if h.UnKnownSize {
+				size = -1
 			}
+			err = extractCandidate(&sel, tmp, dec, size, &budget, opts)
+			if err != nil {
+				return
+			}
+		case isMeta:
+			meta = readComicMeta(readEntry, opts)
 		}
 	}
 endLoop:
-	if thumb == nil {
+	cover, ok := sel.selected()
+	switch {
+	case ok:
+	case skipped:
+		err = ErrEncrypted
+		return
+	default:
 		err = ErrCantThumbnail
 		return
 	}
+	src.Entry = cover.entry
 
-	// If at least 90% of first 10 files in the archive are images, this is a
-	// comic archive
-	if float32(imageCount)/float32(i) >= 0.9 {
+	if sel.isComic() {
 		src.Mime = "application/vnd.comicbook-rar"
 		src.Extension = "cbr"
+		sel.fillComic(src, meta)
 	}
 
-	return
+	return thumbnailExtractedImage(tmp, src, opts)
+}
+
+// Thumbnail the cover image of a tar file
+func processTar(rs io.ReadSeeker, src *Source, opts Options,
+) (image.Image, error) {
+	return thumbnailTar(contextReader{opts.Context(), rs}, src, opts)
+}
+
+// Thumbnail the cover image of a tar stream
+func thumbnailTar(r io.Reader, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	tr := tar.NewReader(r)
+
+	// Entries can only be read in sequence, so the best processable cover
+	// candidate so far is extracted, until a better one is found
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var (
+		sel    = newCoverSelector()
+		h      *tar.Header
+		meta   []byte
+		budget = int64(maxTotalExtractedSize)
+	)
+	readEntry := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(tr), nil
+	}
+	for sel.files < maxArchiveEntries {
+		h, err = tr.Next()
+		switch err {
+		case nil:
+		case io.EOF:
+			err = nil
+			goto endLoop
+		default:
+			// Streams truncated by a size limit still yield the thumbnail of
+			// an already found cover
+			if _, ok := sel.selected(); ok {
+				err = nil
+				goto endLoop
+			}
+			return
+		}
+
+		// Directories, links and other special entries are not counted
+		if !h.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		switch isCandidate, isMeta := sel.add(decodeName(h.Name)); {
+		case isCandidate:
+			err = extractCandidate(&sel, tmp, tr, h.Size, &budget, opts)
+			if err != nil {
+				return
+			}
+		case isMeta:
+			meta = readComicMeta(readEntry, opts)
+		}
+	}
+endLoop:
+	cover, ok := sel.selected()
+	if !ok {
+		err = ErrCantThumbnail
+		return
+	}
+	src.Entry = cover.entry
+
+	if sel.isComic() {
+		src.Mime = "application/x-cbt"
+		src.Extension = "cbt"
+		sel.fillComic(src, meta)
+	}
+
+	return thumbnailExtractedImage(tmp, src, opts)
 }
@@ -0,0 +1,209 @@
+#include "audio.h"
+#include <libavutil/samplefmt.h>
+#include <math.h>
+
+#if LIBAVUTIL_VERSION_INT >= AV_VERSION_INT(57, 24, 100)
+#define frame_channels(f) ((f)->ch_layout.nb_channels)
+#else
+#define frame_channels(f) ((f)->channels)
+#endif
+
+// Statistics of the window being filled
+struct Accumulator {
+    float min, max;
+    double sum_sq;
+    int64_t samples; // Per channel
+    int64_t values; // Across all channels
+};
+
+// Sample i of channel ch normalized to the range [-1, 1]
+static float sample_value(
+    const AVFrame* frame, const int channels, const int ch, const int i)
+{
+    const int planar = av_sample_fmt_is_planar(frame->format);
+    const uint8_t* data = frame->extended_data[planar ? ch : 0];
+    const int j = planar ? i : i * channels + ch;
+
+    switch (av_get_packed_sample_fmt(frame->format)) {
+    case AV_SAMPLE_FMT_U8:
+        return ((float)data[j] - 128) / 128;
+    case AV_SAMPLE_FMT_S16:
+        return (float)((const int16_t*)data)[j] / 32768;
+    case AV_SAMPLE_FMT_S32:
+        return (float)((const int32_t*)data)[j] / 2147483648.0f;
+    case AV_SAMPLE_FMT_S64:
+        return (float)((double)((const int64_t*)data)[j]
+            / 9223372036854775808.0);
+    case AV_SAMPLE_FMT_FLT:
+        return ((const float*)data)[j];
+    case AV_SAMPLE_FMT_DBL:
+        return (float)((const double*)data)[j];
+    default:
+        return 0;
+    }
+}
+
+// Ensure env has capacity for the window at env->size and clear its spectrum
+// samples
+static int reserve_window(struct AudioEnvelope* env, const int spectrum_size)
+{
+    if (env->size == env->capacity) {
+        const int cap = env->capacity ? env->capacity * 2 : 256;
+        struct AudioWindow* windows
+            = realloc(env->windows, cap * sizeof(struct AudioWindow));
+        if (!windows) {
+            return AVERROR(ENOMEM);
+        }
+        env->windows = windows;
+        if (spectrum_size) {
+            float* spectrum = realloc(
+                env->spectrum, (size_t)cap * spectrum_size * sizeof(float));
+            if (!spectrum) {
+                return AVERROR(ENOMEM);
+            }
+            env->spectrum = spectrum;
+        }
+        env->capacity = cap;
+    }
+    if (spectrum_size) {
+        memset(env->spectrum + (size_t)env->size * spectrum_size, 0,
+            spectrum_size * sizeof(float));
+    }
+    return 0;
+}
+
+// Append the accumulated window to env and reset acc
+static void push_window(struct AudioEnvelope* env, struct Accumulator* acc)
+{
+    env->windows[env->size++] = (struct AudioWindow) {
+        .min = acc->min,
+        .max = acc->max,
+        .rms = (float)sqrt(acc->sum_sq / (double)acc->values),
+    };
+    *acc = (struct Accumulator) { 0 };
+}
+
+// Accumulate the first n samples of frame into env
+static int add_frame(struct AudioEnvelope* env, struct Accumulator* acc,
+    const AVFrame* frame, const int n, const int64_t window_samples,
+    const int spectrum_size)
+{
+    const int channels = frame_channels(frame);
+    if (channels <= 0) {
+        return 0;
+    }
+
+    for (int i = 0; i < n; i++) {
+        if (!acc->samples) {
+            const int err = reserve_window(env, spectrum_size);
+            if (err) {
+                return err;
+            }
+            acc->min = 1;
+            acc->max = -1;
+        }
+
+        float mono = 0;
+        for (int ch = 0; ch < channels; ch++) {
+            const float v = sample_value(frame, channels, ch, i);
+            if (v < acc->min) {
+                acc->min = v;
+            }
+            if (v > acc->max) {
+                acc->max = v;
+            }
+            acc->sum_sq += (double)v * v;
+            mono += v;
+        }
+        acc->values += channels;
+        if (acc->samples < spectrum_size) {
+            env->spectrum[(size_t)env->size * spectrum_size + acc->samples]
+                = mono / channels;
+        }
+
+        if (++acc->samples == window_samples) {
+            push_window(env, acc);
+        }
+    }
+    return 0;
+}
+
+int audio_envelope(struct AudioEnvelope* env, AVFormatContext* avfc,
+    AVCodecContext* avcc, const int stream, const struct AudioOptions opts)
+{
+    int err = 0;
+    int64_t window_samples = 0;
+    int64_t max_samples = INT64_MAX;
+    int64_t total = 0;
+    int draining = 0;
+    struct Accumulator acc = { 0 };
+
+    env->windows = NULL;
+    env->spectrum = NULL;
+    env->size = 0;
+    env->capacity = 0;
+
+    AVFrame* frame = av_frame_alloc();
+    if (!frame) {
+        return AVERROR(ENOMEM);
+    }
+
+    while (total < max_samples) {
+        err = read_frame(avfc, avcc, frame, stream);
+        if (err == AVERROR_EOF && !draining) {
+            // Flush the frames still buffered in the decoder
+            draining = 1;
+            err = avcodec_send_packet(avcc, NULL);
+            if (!err) {
+                err = avcodec_receive_frame(avcc, frame);
+            }
+        }
+        if (err) {
+            break;
+        }
+
+        // A packet can contain multiple frames
+        do {
+            if (!window_samples) {
+                const int rate = frame->sample_rate > 0 ? frame->sample_rate
+                                                        : avcc->sample_rate;
+                if (rate <= 0) {
+                    err = AVERROR_INVALIDDATA;
+                    goto end;
+                }
+                window_samples = av_rescale(opts.window, rate, AV_TIME_BASE);
+                if (window_samples < 1) {
+                    window_samples = 1;
+                }
+                if (opts.max_duration > 0) {
+                    max_samples
+                        = av_rescale(opts.max_duration, rate, AV_TIME_BASE);
+                }
+            }
+
+            int n = frame->nb_samples;
+            if (n > max_samples - total) {
+                n = (int)(max_samples - total);
+            }
+            err = add_frame(
+                env, &acc, frame, n, window_samples, opts.spectrum_size);
+            if (err) {
+                goto end;
+            }
+            total += n;
+            av_frame_unref(frame);
+        } while (total < max_samples && !avcodec_receive_frame(avcc, frame));
+    }
+
+    // Ignore all read errors, if at least one sample read, unless aborted
+    if (err == AVERROR_EOF || (total && err != AVERROR_EXIT)) {
+        err = 0;
+    }
+    if (!err && acc.samples) {
+        push_window(env, &acc);
+    }
+
+end:
+    av_frame_free(&frame);
+    return err;
+}
@@ -0,0 +1,406 @@
+package thumbnailer
+
+// #include "audio.h"
+import "C"
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/cmplx"
+	"time"
+	"unsafe"
+)
+
+// AudioVisualization is the type of thumbnail generated for audio files
+// without cover art
+type AudioVisualization uint8
+
+// Available audio visualisations
+const (
+	// Return ErrCantThumbnail for audio files without cover art
+	AudioVisualizationNone AudioVisualization = iota
+
+	// Peak and RMS amplitude over time
+	AudioWaveform
+
+	// Frequency spectrum over time. Falls back to AudioWaveform for audio of
+	// unknown length.
+	AudioSpectrogram
+)
+
+// MaxAudioDuration is the maximum length of audio decoded for visualisations
+// and peak extraction. Longer audio is truncated.
+const MaxAudioDuration = time.Hour
+
+const (
+	// Length of envelope windows for audio of unknown length
+	defaultAudioWindow = 10 * time.Millisecond
+
+	// Samples per spectrogram column. Must be a power of 2.
+	spectrumSize = 1024
+
+	// Spectrogram intensity range in decibels
+	spectrumRange = 90
+
+	// Substituted for unbounded visualisation dimensions
+	maxVisualizationDims = 1024
+)
+
+var (
+	waveformPeakColor = color.RGBA{0x99, 0x99, 0x99, 0xff}
+	waveformRMSColor  = color.RGBA{0x55, 0x55, 0x55, 0xff}
+
+	// Spectrogram colour map from silent to loud
+	spectrumColors = [...]color.RGBA{
+		{0x00, 0x00, 0x04, 0xff},
+		{0x42, 0x0a, 0x68, 0xff},
+		{0x93, 0x26, 0x67, 0xff},
+		{0xdd, 0x51, 0x3a, 0xff},
+		{0xfc, 0xa5, 0x0a, 0xff},
+		{0xfc, 0xff, 0xa4, 0xff},
+	}
+)
+
+// Amplitude statistics of a window of audio samples across all channels
+type audioWindow struct {
+	min, max, rms float32
+}
+
+// Decode the best audio stream up to maxDuration into envelope windows of the
+// passed length. If spectrumSize is not 0, also returns spectrumSize mono
+// samples from the start of each window.
+func (c *FFContext) audioEnvelope(
+	window, maxDuration time.Duration,
+	spectrumSize int,
+) (
+	windows []audioWindow, spectra [][]float32, err error,
+) {
+	ci, err := c.codecContext(FFAudio)
+	if err != nil {
+		return
+	}
+
+	var env C.struct_AudioEnvelope
+	defer func() {
+		if env.windows != nil {
+			C.free(unsafe.Pointer(env.windows))
+		}
+		if env.spectrum != nil {
+			C.free(unsafe.Pointer(env.spectrum))
+		}
+	}()
+	ret := C.audio_envelope(&env, c.avFormatCtx, ci.ctx, ci.stream,
+		C.struct_AudioOptions{
+			window:        C.int64_t(window / time.Microsecond),
+			spectrum_size: C.int(spectrumSize),
+			max_duration:  C.int64_t(maxDuration / time.Microsecond),
+		})
+	switch {
+	case ret != 0:
+		err = c.castError(ret)
+		return
+	case env.size == 0:
+		err = ErrGetFrame
+		return
+	}
+
+	n := int(env.size)
+	cWindows := (*[1 << 28]C.struct_AudioWindow)(
+		unsafe.Pointer(env.windows),
+	)[:n:n]
+	windows = make([]audioWindow, n)
+	for i, w := range cWindows {
+		windows[i] = audioWindow{
+			min: float32(w.min),
+			max: float32(w.max),
+			rms: float32(w.rms),
+		}
+	}
+
+	if spectrumSize != 0 {
+		l := n * spectrumSize
+		samples := (*[1 << 30]C.float)(unsafe.Pointer(env.spectrum))[:l:l]
+		spectra = make([][]float32, n)
+		for i := range spectra {
+			s := make([]float32, spectrumSize)
+			for j := range s {
+				s[j] = float32(samples[i*spectrumSize+j])
+			}
+			spectra[i] = s
+		}
+	}
+	return
+}
+
+// Peak is the minimum and maximum sample value across all channels of a span
+// of audio in the range [-1, 1]
+type Peak struct {
+	Min float32 `json:"min"`
+	Max float32 `json:"max"`
+}
+
+// Peaks decodes the best audio stream up to MaxAudioDuration and returns the
+// peaks of buckets evenly sized time spans for drawing waveforms.
+// Returns no peaks, if buckets is not positive.
+func (c *FFContext) Peaks(buckets int) (peaks []Peak, err error) {
+	if buckets <= 0 {
+		return
+	}
+	windows, _, err := c.audioEnvelope(
+		audioWindowLength(c.Length(), buckets),
+		MaxAudioDuration,
+		0,
+	)
+	if err != nil {
+		return
+	}
+	peaks = make([]Peak, buckets)
+	for i, w := range mergeWindows(windows, buckets) {
+		peaks[i] = Peak{
+			Min: w.min,
+			Max: w.max,
+		}
+	}
+	return
+}
+
+// Length of envelope windows to split audio of the passed length into the
+// passed amount of buckets with
+func audioWindowLength(length time.Duration, buckets int) time.Duration {
+	if length <= 0 {
+		// Windows are merged into buckets after decoding
+		return defaultAudioWindow
+	}
+	if length > MaxAudioDuration {
+		length = MaxAudioDuration
+	}
+	window := length / time.Duration(buckets)
+	if window <= 0 {
+		window = time.Microsecond
+	}
+	return window
+}
+
+// Merge consecutive windows into n windows
+func mergeWindows(windows []audioWindow, n int) []audioWindow {
+	merged := make([]audioWindow, n)
+	for i := range merged {
+		start, end := coveredRange(i, len(windows), n)
+		m := audioWindow{
+			min: 1,
+			max: -1,
+		}
+		var sumSq float64
+		for _, w := range windows[start:end] {
+			if w.min < m.min {
+				m.min = w.min
+			}
+			if w.max > m.max {
+				m.max = w.max
+			}
+			sumSq += float64(w.rms) * float64(w.rms)
+		}
+		m.rms = float32(math.Sqrt(sumSq / float64(end-start)))
+		merged[i] = m
+	}
+	return merged
+}
+
+// Generate a visualisation of the audio stream for each of opts.ThumbSizes or
+// opts.ThumbDims, if not set
+func visualizeAudio(c *FFContext, length time.Duration, opts Options,
+) (thumb image.Image, err error) {
+	sizes := opts.ThumbSizes
+	if len(sizes) == 0 {
+		sizes = []Dims{opts.ThumbDims}
+	}
+	rects := make([]image.Rectangle, len(sizes))
+	maxWidth := 0
+	for i, d := range sizes {
+		w, h := visualizationDims(d)
+		rects[i] = image.Rect(0, 0, w, h)
+		if w > maxWidth {
+			maxWidth = w
+		}
+	}
+
+	// Decode once with one window per column of the widest thumbnail
+	typ := opts.AudioVisualization
+	if length <= 0 && typ == AudioSpectrogram {
+		typ = AudioWaveform
+	}
+	window := audioWindowLength(length, maxWidth)
+	var samples int
+	if typ == AudioSpectrogram {
+		samples = spectrumSize
+	}
+	windows, spectra, err := c.audioEnvelope(window, MaxAudioDuration,
+		samples)
+	if err != nil {
+		return
+	}
+
+	thumbs := make(thumbnailSet, len(rects))
+	for i, r := range rects {
+		img := image.NewRGBA(r)
+		if opts.Background != nil {
+			draw.Draw(img, r, image.NewUniform(opts.Background), image.Point{},
+				draw.Src)
+		}
+		if typ == AudioSpectrogram {
+			drawSpectrogram(img, spectra)
+		} else {
+			drawWaveform(img, windows)
+		}
+		thumbs[i] = img
+	}
+	if len(thumbs) == 1 {
+		thumb = thumbs[0]
+	} else {
+		thumb = thumbs
+	}
+	return
+}
+
+// Resolve the dimensions of a visualisation fitting the bounding box
+func visualizationDims(d Dims) (w, h int) {
+	if d.Width > maxVisualizationDims {
+		d.Width = maxVisualizationDims
+	}
+	if d.Height > maxVisualizationDims {
+		d.Height = maxVisualizationDims
+	}
+	return int(d.Width), int(d.Height)
+}
+
+// Draw the peak and RMS amplitude of each column mirrored around the
+// horizontal centre. Amplitudes are normalized to the loudest peak.
+func drawWaveform(img *image.RGBA, windows []audioWindow) {
+	b := img.Bounds()
+	cols := mergeWindows(windows, b.Dx())
+
+	var peak float32
+	for _, c := range cols {
+		if -c.min > peak {
+			peak = -c.min
+		}
+		if c.max > peak {
+			peak = c.max
+		}
+	}
+	if peak == 0 {
+		peak = 1
+	}
+
+	mid := float64(b.Dy()) / 2
+	y := func(v float32) float64 {
+		return mid - float64(v/peak)*mid
+	}
+	for x, c := range cols {
+		rms := c.rms
+		if rms > peak {
+			rms = peak
+		}
+		drawColumn(img, x, y(c.max), y(c.min), waveformPeakColor)
+		drawColumn(img, x, y(rms), y(-rms), waveformRMSColor)
+	}
+}
+
+// Fill column x from top to bottom. Always fills at least one pixel.
+func drawColumn(img *image.RGBA, x int, top, bottom float64, c color.RGBA) {
+	y0 := int(math.Floor(top))
+	y1 := int(math.Ceil(bottom))
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	for y := y0; y < y1; y++ {
+		img.SetRGBA(x, y, c)
+	}
+}
+
+// Draw the frequency spectrum of each column with low frequencies at the
+// bottom
+func drawSpectrogram(img *image.RGBA, spectra [][]float32) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	buf := make([]complex128, spectrumSize)
+	mags := make([]float64, spectrumSize/2)
+	for x := 0; x < w; x++ {
+		i, _ := coveredRange(x, len(spectra), w)
+
+		// Hann window reduces spectral leakage
+		for j, s := range spectra[i] {
+			win := 0.5 - 0.5*math.Cos(2*math.Pi*float64(j)/spectrumSize)
+			buf[j] = complex(float64(s)*win, 0)
+		}
+		fft(buf)
+		for j := range mags {
+			// Normalize to a full scale sine wave
+			mags[j] = cmplx.Abs(buf[j]) / (spectrumSize / 4)
+		}
+
+		for y := 0; y < h; y++ {
+			start, end := coveredRange(h-1-y, len(mags), h)
+			var mag float64
+			for _, m := range mags[start:end] {
+				if m > mag {
+					mag = m
+				}
+			}
+			db := 20 * math.Log10(mag+1e-12)
+			img.SetRGBA(x, y, spectrumColor((db+spectrumRange)/spectrumRange))
+		}
+	}
+}
+
+// Map intensity in the range [0, 1] to the spectrogram colour map
+func spectrumColor(v float64) color.RGBA {
+	switch {
+	case v <= 0:
+		return spectrumColors[0]
+	case v >= 1:
+		return spectrumColors[len(spectrumColors)-1]
+	}
+	pos := v * float64(len(spectrumColors)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := spectrumColors[i], spectrumColors[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*f + 0.5)
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+// In-place iterative radix-2 fast Fourier transform. len(x) must be a power
+// of 2.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * w
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+#pragma once
+#include "ffmpeg.h"
+
+// Amplitude statistics of a window of samples across all channels
+struct AudioWindow {
+    float min, max; // Sample extremes in the range [-1, 1]
+    float rms; // Root mean square
+};
+
+struct AudioEnvelope {
+    struct AudioWindow* windows;
+
+    // spectrum_size mono samples from the start of each window, if requested.
+    // Zero padded, if the window is shorter.
+    float* spectrum;
+
+    int size;
+    int capacity;
+};
+
+struct AudioOptions {
+    int64_t window; // Window length in AV_TIME_BASE units
+    int spectrum_size; // Mono samples to capture per window. None, if 0.
+    int64_t max_duration; // In AV_TIME_BASE units. Unlimited, if 0.
+};
+
+// Decodes the audio stream and writes the amplitude envelope in consecutive
+// windows of opts.window length to env. It is the responsibility of the
+// caller to free env->windows and env->spectrum, even on error.
+int audio_envelope(struct AudioEnvelope* env, AVFormatContext* avfc,
+    AVCodecContext* avcc, const int stream, const struct AudioOptions opts);
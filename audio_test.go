@@ -0,0 +1,172 @@
+package thumbnailer
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestAudioVisualization(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, file string
+		typ        AudioVisualization
+	}{
+		{"mp3 waveform", "no_cover.mp3", AudioWaveform},
+		{"ogg waveform", "no_cover.ogg", AudioWaveform},
+		{"mp3 spectrogram", "no_cover.mp3", AudioSpectrogram},
+		{"ogg spectrogram", "no_cover.ogg", AudioSpectrogram},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			src, thumb, err := Process(f, Options{
+				ThumbDims:          Dims{200, 60},
+				AudioVisualization: c.typ,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !src.HasAudio || src.HasVideo {
+				t.Fatal("invalid stream detection")
+			}
+			if b := thumb.Bounds(); b.Dx() != 200 || b.Dy() != 60 {
+				t.Fatalf("unexpected dimensions: %v", b)
+			}
+			writeSample(t, c.name+".png", thumb)
+		})
+	}
+}
+
+func TestMergeWindows(t *testing.T) {
+	t.Parallel()
+
+	windows := []audioWindow{
+		{-0.5, 0.25, 0.1},
+		{-0.25, 0.75, 0.3},
+		{-0.1, 0.1, 0.1},
+	}
+
+	m := mergeWindows(windows, 1)[0]
+	if m.min != -0.5 || m.max != 0.75 ||
+		math.Abs(float64(m.rms)-math.Sqrt(0.11/3)) > 1e-6 {
+		t.Fatalf("unexpected merged window: %v", m)
+	}
+
+	// Short audio repeats windows
+	merged := mergeWindows(windows, 6)
+	for i, w := range merged {
+		if w != windows[i/2] {
+			t.Fatalf("window %d: expected %v, got %v", i, windows[i/2], w)
+		}
+	}
+}
+
+func TestDrawWaveform(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 10))
+	drawWaveform(img, []audioWindow{
+		{-1, 1, 0.5},
+		{0, 0, 0},
+	})
+
+	// Full scale peak with half scale RMS
+	for y := 0; y < 10; y++ {
+		c := waveformPeakColor
+		if y >= 2 && y < 8 {
+			c = waveformRMSColor
+		}
+		if got := img.RGBAAt(0, y); got != c {
+			t.Fatalf("pixel 0,%d: expected %v, got %v", y, c, got)
+		}
+	}
+
+	// Silence is a single line
+	for y := 0; y < 10; y++ {
+		set := img.RGBAAt(1, y).A != 0
+		if set != (y == 5) {
+			t.Fatalf("pixel 1,%d: unexpected value %v", y, img.RGBAAt(1, y))
+		}
+	}
+}
+
+func TestFFT(t *testing.T) {
+	t.Parallel()
+
+	// Sine wave with 4 periods over the input is concentrated in bin 4
+	const n = 64
+	x := make([]complex128, n)
+	for i := range x {
+		x[i] = complex(math.Sin(2*math.Pi*4*float64(i)/n), 0)
+	}
+	fft(x)
+	for i := 0; i < n/2; i++ {
+		mag := cmplx.Abs(x[i])
+		switch i {
+		case 4:
+			if math.Abs(mag-n/2) > 1e-9 {
+				t.Fatalf("bin %d: expected %d, got %f", i, n/2, mag)
+			}
+		default:
+			if mag > 1e-9 {
+				t.Fatalf("bin %d: expected 0, got %f", i, mag)
+			}
+		}
+	}
+}
+
+func TestPeaks(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "no_cover.mp3")
+	defer f.Close()
+
+	c, err := NewFFContext(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	peaks, err := c.Peaks(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peaks) != 100 {
+		t.Fatalf("expected 100 peaks, got %d", len(peaks))
+	}
+	var loud bool
+	for i, p := range peaks {
+		if p.Min < -1 || p.Max > 1 || p.Min > p.Max {
+			t.Fatalf("invalid peak %d: %v", i, p)
+		}
+		if p.Max > 0 {
+			loud = true
+		}
+	}
+	if !loud {
+		t.Fatal("all peaks silent")
+	}
+}
+
+func TestPeakJSON(t *testing.T) {
+	t.Parallel()
+
+	buf, err := json.Marshal([]Peak{{-0.5, 0.25}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const std = `[{"min":-0.5,"max":0.25}]`
+	if string(buf) != std {
+		t.Fatalf("expected %s, got %s", std, buf)
+	}
+}
@@ -0,0 +1,489 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Maximum size of a comic metadata file
+const maxComicMetaSize = 1 << 20
+
+// Comic metadata file formats in ascending order of preference
+const (
+	comicMetaNone = iota
+	comicMetaText
+	comicMetaACBF
+	comicMetaInfo
+)
+
+// Selects the cover and metadata file of an archive and detects comic
+// archives from the names of the files of the archive in archive order
+type coverSelector struct {
+	// Number of files added
+	files int
+
+	// Number of images among the added files
+	pages int
+
+	// Files, that could be processable media, in archive order
+	candidates []coverCandidate
+
+	// Index of the selected cover among the candidates or -1
+	cover int
+
+	// Name and format of the selected metadata file
+	metaName   string
+	metaFormat int
+
+	// Files and images among the first 10 files considered for comic
+	// detection
+	inspected, images int
+}
+
+// File of an archive, that could be used as the cover
+type coverCandidate struct {
+	// Index among the files added to the coverSelector
+	index int
+
+	// Name as stored in the archive and normalized name
+	entry, name string
+
+	// Directory depth of the file
+	depth int
+
+	// File has an image extension and a name denoting a cover respectively
+	image, named bool
+}
+
+func newCoverSelector() coverSelector {
+	return coverSelector{
+		cover: -1,
+	}
+}
+
+// Add the next file of the archive. Returns, if the file is a cover candidate
+// preferred over the selected cover, if any, or the new selected metadata
+// file.
+func (s *coverSelector) add(name string) (candidate, meta bool) {
+	c := coverCandidate{
+		index: s.files,
+		entry: name,
+	}
+	s.files++
+
+	name = strings.Replace(name, "\\", "/", -1)
+	name = strings.TrimLeft(strings.TrimPrefix(name, "./"), "/")
+	if isHiddenPath(name) {
+		return
+	}
+
+	// Metadata files do not count against the ratio of images
+	if format := comicMetaFormat(name); format != comicMetaNone {
+		if format > s.metaFormat {
+			s.metaName = name
+			s.metaFormat = format
+			meta = true
+		}
+		return
+	}
+
+	c.image = couldBeImage(name)
+	if s.inspected < 10 {
+		s.inspected++
+		if c.image {
+			s.images++
+		}
+	}
+	if c.image {
+		s.pages++
+	} else if !couldBeMedia(name) {
+		return
+	}
+
+	c.name = name
+	c.depth = strings.Count(name, "/")
+	c.named = isCoverName(name)
+	s.candidates = append(s.candidates, c)
+	candidate = s.cover == -1 || c.preferredTo(s.candidates[s.cover])
+	return
+}
+
+// Select the last added candidate as the cover
+func (s *coverSelector) choose() {
+	s.cover = len(s.candidates) - 1
+}
+
+// Returns the selected cover, if any
+func (s *coverSelector) selected() (c coverCandidate, ok bool) {
+	if s.cover == -1 {
+		return
+	}
+	return s.candidates[s.cover], true
+}
+
+// Returns the cover candidates in order of preference
+func (s *coverSelector) ranked() []coverCandidate {
+	ranked := append([]coverCandidate(nil), s.candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].preferredTo(ranked[j])
+	})
+	return ranked
+}
+
+// Images are preferred over other media and files in the top-most directory
+// over files in subdirectories. Within the same directory depth files named
+// like a cover take precedence over pages in natural filename order.
+func (c coverCandidate) preferredTo(other coverCandidate) bool {
+	switch {
+	case c.image != other.image:
+		return c.image
+	case c.depth != other.depth:
+		return c.depth < other.depth
+	case c.named != other.named:
+		return c.named
+	default:
+		return naturalLess(c.name, other.name)
+	}
+}
+
+// Returns, if the archive contains images and a ComicInfo.xml or ACBF metadata
+// file or at least 90% of the first 10 files of the archive are images
+func (s *coverSelector) isComic() bool {
+	if s.metaFormat >= comicMetaACBF && s.pages != 0 {
+		return true
+	}
+	return s.inspected != 0 &&
+		float32(s.images)/float32(s.inspected) >= 0.9
+}
+
+// Fill the page count and metadata of a comic book archive. data is the
+// content of the selected metadata file, if any. The page count of the
+// metadata takes precedence over the number of images in the archive.
+func (s *coverSelector) fillComic(src *Source, data []byte) {
+	src.Pages = s.pages
+	if data == nil {
+		return
+	}
+
+	var (
+		m     ComicMeta
+		pages int
+	)
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	switch s.metaFormat {
+	case comicMetaInfo:
+		m, pages = parseComicInfo(data)
+	case comicMetaACBF:
+		m = parseACBF(data)
+	case comicMetaText:
+		m = parseComicText(data)
+	}
+	if pages > 0 {
+		src.Pages = pages
+	}
+	for _, p := range [...]*string{
+		&m.Series, &m.Title, &m.Writer, &m.Number, &m.AgeRating,
+	} {
+		*p = strings.TrimSpace(*p)
+		sanitize(p)
+	}
+
+	src.Comic = m
+	src.Title = m.Title
+	if src.Title == "" && m.Series != "" {
+		src.Title = m.Series
+		if m.Number != "" {
+			src.Title += " #" + m.Number
+		}
+	}
+	src.Artist = m.Writer
+}
+
+// Read a comic metadata file. Read errors and files exceeding
+// maxComicMetaSize are ignored.
+func readComicMeta(open func() (io.ReadCloser, error), opts Options,
+) []byte {
+	rc, err := open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(
+		contextReader{opts.Context(), rc},
+		maxComicMetaSize+1,
+	))
+	if err != nil || len(buf) > maxComicMetaSize {
+		return nil
+	}
+	return buf
+}
+
+// Returns the format of a comic metadata file from its name or
+// comicMetaNone, if the file is not a metadata file
+func comicMetaFormat(name string) int {
+	name = strings.ToLower(path.Base(name))
+	switch {
+	case name == "comicinfo.xml":
+		return comicMetaInfo
+	case path.Ext(name) == ".acbf":
+		return comicMetaACBF
+	case name == "comic.txt":
+		return comicMetaText
+	default:
+		return comicMetaNone
+	}
+}
+
+// Decode an XML metadata file. Invalid XML is ignored.
+func decodeComicXML(data []byte, v interface{}) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	dec.Decode(v)
+}
+
+// Parse a ComicInfo.xml file, as written by ComicRack and compatible software.
+// Also returns the page count of the comic or 0, if not specified.
+func parseComicInfo(data []byte) (m ComicMeta, pages int) {
+	var v struct {
+		Series, Number, Title, Writer, Manga, AgeRating, PageCount string
+	}
+	decodeComicXML(data, &v)
+
+	m.Series = v.Series
+	m.Number = v.Number
+	m.Title = v.Title
+	m.Writer = v.Writer
+	m.AgeRating = v.AgeRating
+	switch strings.TrimSpace(v.Manga) {
+	case "Yes":
+		m.Manga = true
+	case "YesAndRightToLeft":
+		m.Manga = true
+		m.RightToLeft = true
+	}
+	if m.AgeRating == "Unknown" {
+		m.AgeRating = ""
+	}
+	pages, _ = strconv.Atoi(strings.TrimSpace(v.PageCount))
+	return
+}
+
+// Parse the book information of an Advanced Comic Book Format file
+func parseACBF(data []byte) (m ComicMeta) {
+	type author struct {
+		Activity   string `xml:"activity,attr"`
+		FirstName  string `xml:"first-name"`
+		MiddleName string `xml:"middle-name"`
+		LastName   string `xml:"last-name"`
+		Nickname   string `xml:"nickname"`
+	}
+	var v struct {
+		Authors   []author `xml:"meta-data>book-info>author"`
+		Titles    []string `xml:"meta-data>book-info>book-title"`
+		Sequences []struct {
+			Title  string `xml:"title,attr"`
+			Number string `xml:",chardata"`
+		} `xml:"meta-data>book-info>sequence"`
+		Genres  []string `xml:"meta-data>book-info>genre"`
+		Ratings []string `xml:"meta-data>book-info>content-rating"`
+	}
+	decodeComicXML(data, &v)
+
+	if len(v.Titles) != 0 {
+		m.Title = v.Titles[0]
+	}
+	if len(v.Sequences) != 0 {
+		m.Series = v.Sequences[0].Title
+		m.Number = v.Sequences[0].Number
+	}
+	if len(v.Ratings) != 0 {
+		m.AgeRating = v.Ratings[0]
+	}
+	for _, g := range v.Genres {
+		if strings.EqualFold(strings.TrimSpace(g), "manga") {
+			m.Manga = true
+		}
+	}
+
+	// Prefer the writer over other contributors
+	var writer *author
+	for i := range v.Authors {
+		a := &v.Authors[i]
+		if writer == nil || strings.EqualFold(a.Activity, "Writer") &&
+			!strings.EqualFold(writer.Activity, "Writer") {
+			writer = a
+		}
+	}
+	if writer != nil {
+		var parts []string
+		for _, p := range [...]string{
+			writer.FirstName, writer.MiddleName, writer.LastName,
+		} {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		m.Writer = strings.Join(parts, " ")
+		if m.Writer == "" {
+			m.Writer = writer.Nickname
+		}
+	}
+	return
+}
+
+// Parse a comic.txt file of "Key: value" lines
+func parseComicText(data []byte) (m ComicMeta) {
+	for _, line := range strings.Split(string(data), "\n") {
+		i := strings.IndexByte(line, ':')
+		if i == -1 {
+			continue
+		}
+		val := strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "title":
+			m.Title = val
+		case "series":
+			m.Series = val
+		case "number", "issue", "volume":
+			m.Number = val
+		case "writer", "author":
+			m.Writer = val
+		case "age rating", "agerating", "rating":
+			m.AgeRating = val
+		case "manga":
+			switch strings.ToLower(val) {
+			case "yes", "true":
+				m.Manga = true
+			case "yesandrighttoleft":
+				m.Manga = true
+				m.RightToLeft = true
+			}
+		}
+	}
+	return
+}
+
+// Returns, if the file or any of its parent directories is hidden or contains
+// resource forks of macOS archivers
+func isHiddenPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns, if the file name denotes a cover image, like "cover.jpg",
+// "folder.png" or "000.jpg"
+func isCoverName(name string) bool {
+	name = strings.ToLower(path.Base(name))
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	named := false
+	for _, word := range splitWords(name) {
+		switch {
+		case word == "back":
+			return false
+		case word == "cover", word == "folder",
+			isASCIIDigit(word[0]) && strings.Trim(word, "0") == "":
+			named = true
+		}
+	}
+	return named
+}
+
+// Split a string into words of letters and numbers
+func splitWords(s string) (words []string) {
+	start := -1
+	var digits bool
+	for i, r := range s {
+		isDigit := unicode.IsDigit(r)
+		if !isDigit && !unicode.IsLetter(r) {
+			if start != -1 {
+				words = append(words, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start != -1 && isDigit != digits {
+			words = append(words, s[start:i])
+			start = -1
+		}
+		if start == -1 {
+			start = i
+			digits = isDigit
+		}
+	}
+	if start != -1 {
+		words = append(words, s[start:])
+	}
+	return
+}
+
+// Compare file names in natural order, where embedded numbers are compared
+// by their value, like "page2.jpg" < "page10.jpg". Letters are compared case
+// insensitively.
+func naturalLess(a, b string) bool {
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if isASCIIDigit(a[i]) && isASCIIDigit(b[j]) {
+			// Compare numbers of any length without parsing them by
+			// comparing their significant digits
+			endA, endB := digitsEnd(a, i), digitsEnd(b, j)
+			numA := strings.TrimLeft(a[i:endA], "0")
+			numB := strings.TrimLeft(b[j:endB], "0")
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			i, j = endA, endB
+			continue
+		}
+
+		ca, cb := lowerASCII(a[i]), lowerASCII(b[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+
+	// Equal up to the end of the shorter name or differing only in case and
+	// leading zeros
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func lowerASCII(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// Returns the index after the digit sequence starting at i
+func digitsEnd(s string, i int) int {
+	for i < len(s) && isASCIIDigit(s[i]) {
+		i++
+	}
+	return i
+}
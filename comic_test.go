@@ -0,0 +1,447 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	t.Parallel()
+
+	names := []string{
+		"page10.jpg",
+		"Page2.jpg",
+		"page1.jpg",
+		"page01b.jpg",
+		"page",
+		"page001.jpg",
+		"extra 3.png",
+		"12345678901234567890.png",
+		"99.png",
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+
+	std := [...]string{
+		"99.png",
+		"12345678901234567890.png",
+		"extra 3.png",
+		"page",
+		"page1.jpg",
+		"page001.jpg",
+		"page01b.jpg",
+		"Page2.jpg",
+		"page10.jpg",
+	}
+	for i := range std {
+		if names[i] != std[i] {
+			t.Fatalf("expected %v, got %v", std, names)
+		}
+	}
+}
+
+func TestIsCoverName(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name  string
+		cover bool
+	}{
+		{"cover.jpg", true},
+		{"Comic/Cover.PNG", true},
+		{"00_cover.jpg", true},
+		{"front cover.jpg", true},
+		{"folder.jpg", true},
+		{"000.jpg", true},
+		{"vol01_000.png", true},
+		{"back_cover.jpg", false},
+		{"backcover.jpg", false},
+		{"001.jpg", false},
+		{"covers_010.jpg", false},
+		{"000/001.jpg", false},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			if res := isCoverName(c.name); res != c.cover {
+				t.Fatalf("expected %v, got %v", c.cover, res)
+			}
+		})
+	}
+}
+
+func TestCoverSelector(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, cover string
+		files       []string
+		comic       bool
+	}{
+		{
+			name:  "no candidates",
+			files: []string{"readme.txt", "info.nfo"},
+		},
+		{
+			name:  "natural order",
+			files: []string{"10.jpg", "9.jpg", "11.jpg"},
+			cover: "9.jpg",
+			comic: true,
+		},
+		{
+			name:  "cover name",
+			files: []string{"credits.jpg", "01.jpg", "cover.jpg", "02.jpg"},
+			cover: "cover.jpg",
+			comic: true,
+		},
+		{
+			name: "top-level directory",
+			files: []string{
+				"comic/extras/000.jpg",
+				"comic/2.png",
+				"comic/1.png",
+			},
+			cover: "comic/1.png",
+			comic: true,
+		},
+		{
+			name: "hidden files",
+			files: []string{
+				"__MACOSX/._01.jpg",
+				".thumbnail.jpg",
+				"02.jpg",
+			},
+			cover: "02.jpg",
+			comic: true,
+		},
+		{
+			name:  "Windows separators",
+			files: []string{`comic\02.jpg`, `comic\sub\01.jpg`},
+			cover: `comic\02.jpg`,
+			comic: true,
+		},
+		{
+			name: "metadata files",
+			files: []string{
+				"ComicInfo.xml", "01.jpg", "02.jpg", "03.jpg", "comic.txt",
+			},
+			cover: "01.jpg",
+			comic: true,
+		},
+		{
+			name: "ComicInfo without image ratio",
+			files: []string{
+				"ComicInfo.xml", "01.jpg", "notes.txt", "credits.txt",
+			},
+			cover: "01.jpg",
+			comic: true,
+		},
+		{
+			name:  "ACBF without image ratio",
+			files: []string{"comic/book.acbf", "comic/01.png", "comic/a.txt"},
+			cover: "comic/01.png",
+			comic: true,
+		},
+		{
+			name:  "comic.txt without image ratio",
+			files: []string{"comic.txt", "01.jpg", "notes.txt"},
+			cover: "01.jpg",
+		},
+		{
+			name:  "ComicInfo without images",
+			files: []string{"ComicInfo.xml", "readme.txt"},
+		},
+		{
+			name:  "unsupported image formats",
+			files: []string{"cover.avif", "02.png", "01.png"},
+			cover: "01.png",
+		},
+		{
+			name:  "images before other media",
+			files: []string{"intro.webm", "extras/01.gif", "theme.mp3"},
+			cover: "extras/01.gif",
+		},
+		{
+			name:  "media without images",
+			files: []string{"notes.txt", "theme.mp3", "clip"},
+			cover: "clip",
+		},
+		{
+			name: "ratio of inspected files",
+			files: []string{
+				"01.jpg", "02.jpg", "03.jpg", "04.jpg", "05.jpg", "06.jpg",
+				"07.jpg", "08.jpg", "09.jpeg", "readme.txt", "a.txt", "b.txt",
+			},
+			cover: "01.jpg",
+			comic: true,
+		},
+		{
+			name: "not a comic",
+			files: []string{
+				"01.jpg", "02.jpg", "03.jpg", "04.jpg", "05.jpg", "06.jpg",
+				"07.jpg", "08.jpg", "readme.txt", "a.txt",
+				"09.jpg", "10.jpg", // Not inspected
+			},
+			cover: "01.jpg",
+			comic: false,
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Select candidates in archive order as with sequentially read
+			// archives
+			sel := newCoverSelector()
+			for _, name := range c.files {
+				if isCandidate, _ := sel.add(name); isCandidate {
+					sel.choose()
+				}
+			}
+			cover, _ := sel.selected()
+			if cover.entry != c.cover {
+				t.Fatalf("expected cover %q, got %q", c.cover, cover.entry)
+			}
+
+			// Rank candidates as with random access archives
+			var first string
+			if ranked := sel.ranked(); len(ranked) != 0 {
+				first = ranked[0].entry
+			}
+			if first != c.cover {
+				t.Fatalf("expected first ranked %q, got %q", c.cover, first)
+			}
+
+			if comic := sel.isComic(); comic != c.comic {
+				t.Fatalf("expected comic %v, got %v", c.comic, comic)
+			}
+		})
+	}
+}
+
+func TestComicMetaSelection(t *testing.T) {
+	t.Parallel()
+
+	sel := newCoverSelector()
+	var selected []string
+	for _, name := range [...]string{
+		"comic.txt",
+		"01.jpg",
+		"book.acbf",
+		"comic.txt",
+		"COMICINFO.XML",
+		".hidden/ComicInfo.xml",
+		"comic/ComicInfo.xml",
+	} {
+		if _, isMeta := sel.add(name); isMeta {
+			selected = append(selected, name)
+		}
+	}
+
+	std := [...]string{"comic.txt", "book.acbf", "COMICINFO.XML"}
+	if len(selected) != len(std) {
+		t.Fatalf("expected %v, got %v", std, selected)
+	}
+	for i := range std {
+		if selected[i] != std[i] {
+			t.Fatalf("expected %v, got %v", std, selected)
+		}
+	}
+	if sel.pages != 1 {
+		t.Fatalf("unexpected page count: %d", sel.pages)
+	}
+}
+
+func TestComicMeta(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, file, data string
+		meta             ComicMeta
+		title            string
+		pages            int
+	}{
+		{
+			name: "ComicInfo",
+			file: "ComicInfo.xml",
+			data: "\xEF\xBB\xBF<?xml version=\"1.0\" encoding=\"utf-8\"?>" +
+				`<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+	<Title>The Beginning</Title>
+	<Series>Saga</Series>
+	<Number>1</Number>
+	<Writer>Brian K. Vaughan</Writer>
+	<PageCount>44</PageCount>
+	<AgeRating>Adults Only 18+</AgeRating>
+	<Manga>No</Manga>
+</ComicInfo>`,
+			meta: ComicMeta{
+				Series:    "Saga",
+				Title:     "The Beginning",
+				Writer:    "Brian K. Vaughan",
+				Number:    "1",
+				AgeRating: "Adults Only 18+",
+			},
+			title: "The Beginning",
+			pages: 44,
+		},
+		{
+			name: "ComicInfo manga",
+			file: "ComicInfo.xml",
+			data: `<?xml version="1.0" encoding="ISO-8859-1"?>
+<ComicInfo>
+	<Series>Akira</Series>
+	<Number>3</Number>
+	<Writer>` + "Katsuhiro \xd4tomo" + `</Writer>
+	<Manga>YesAndRightToLeft</Manga>
+	<AgeRating>Unknown</AgeRating>
+</ComicInfo>`,
+			meta: ComicMeta{
+				Series:      "Akira",
+				Writer:      "Katsuhiro Ôtomo",
+				Number:      "3",
+				Manga:       true,
+				RightToLeft: true,
+			},
+			title: "Akira #3",
+		},
+		{
+			name: "ACBF",
+			file: "comic/book.acbf",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<ACBF xmlns="http://www.acbf.info/xml/acbf/1.1">
+	<meta-data>
+		<book-info>
+			<author activity="Artist">
+				<first-name>Jane</first-name>
+				<last-name>Doe</last-name>
+			</author>
+			<author activity="Writer">
+				<nickname>jsmith</nickname>
+			</author>
+			<book-title lang="en">Moon Rise</book-title>
+			<book-title lang="sk">Východ mesiaca</book-title>
+			<genre>manga</genre>
+			<sequence title="Moon">2</sequence>
+			<content-rating type="Age">12+</content-rating>
+		</book-info>
+	</meta-data>
+	<body></body>
+</ACBF>`,
+			meta: ComicMeta{
+				Series:    "Moon",
+				Title:     "Moon Rise",
+				Writer:    "jsmith",
+				Number:    "2",
+				AgeRating: "12+",
+				Manga:     true,
+			},
+			title: "Moon Rise",
+		},
+		{
+			name: "comic.txt",
+			file: "comic.txt",
+			data: "Series: Bone\r\nIssue: 7\r\nAuthor: Jeff Smith\r\n" +
+				"Notes: none\r\n",
+			meta: ComicMeta{
+				Series: "Bone",
+				Writer: "Jeff Smith",
+				Number: "7",
+			},
+			title: "Bone #7",
+		},
+		{
+			name:  "invalid XML",
+			file:  "ComicInfo.xml",
+			data:  "<ComicInfo><Series>",
+			meta:  ComicMeta{},
+			title: "",
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			sel := newCoverSelector()
+			sel.add(c.file)
+			var src Source
+			sel.fillComic(&src, []byte(c.data))
+			if src.Comic != c.meta {
+				t.Fatalf("expected %+v, got %+v", c.meta, src.Comic)
+			}
+			if src.Title != c.title {
+				t.Fatalf("expected title %q, got %q", c.title, src.Title)
+			}
+			if src.Artist != c.meta.Writer {
+				t.Fatalf("expected artist %q, got %q", c.meta.Writer, src.Artist)
+			}
+			if src.Pages != c.pages {
+				t.Fatalf("expected %d pages, got %d", c.pages, src.Pages)
+			}
+		})
+	}
+}
+
+func TestProcessComicMeta(t *testing.T) {
+	t.Parallel()
+
+	page := string(readSample(t, "too small.png"))
+	buf := buildZip(t,
+		[2]string{"01.png", page},
+		[2]string{"02.png", page},
+		[2]string{
+			"ComicInfo.xml",
+			"<ComicInfo><Series>Saga</Series><Number>1</Number></ComicInfo>",
+		},
+		[2]string{"03.png", page},
+	)
+
+	src, _, err := Process(bytes.NewReader(buf), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Extension != "cbz" {
+		t.Fatalf("not detected as comic: %s", src.Mime)
+	}
+	if src.Pages != 3 {
+		t.Fatalf("unexpected page count: %d", src.Pages)
+	}
+	if src.Title != "Saga #1" {
+		t.Fatalf("unexpected title: %s", src.Title)
+	}
+}
+
+func TestProcessComicInfoPageCount(t *testing.T) {
+	t.Parallel()
+
+	// Too few images for the comic to be detected from the files alone
+	buf := buildZip(t,
+		[2]string{"01.png", string(readSample(t, "too small.png"))},
+		[2]string{"credits.txt", "Art by Jane Doe"},
+		[2]string{"notes.txt", "Second print"},
+		[2]string{
+			"ComicInfo.xml",
+			"<ComicInfo><Series>Saga</Series><PageCount>20</PageCount>" +
+				"</ComicInfo>",
+		},
+	)
+
+	src, _, err := Process(bytes.NewReader(buf), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Extension != "cbz" {
+		t.Fatalf("not detected as comic: %s", src.Mime)
+	}
+	if src.Pages != 20 {
+		t.Fatalf("unexpected page count: %d", src.Pages)
+	}
+}
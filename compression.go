@@ -0,0 +1,151 @@
+package thumbnailer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	mimeGzip  = "application/gzip"
+	mimeBzip2 = "application/x-bzip2"
+	mimeXz    = "application/x-xz"
+	mimeZstd  = "application/zstd"
+
+	// Maximum size of the decompressed content of a compressed file
+	maxDecompressedSize = 100 << 20
+)
+
+// MIME types of single-file compression formats, that are thumbnailed by
+// their decompressed content
+var compressedMIMEs = map[string]bool{
+	mimeGzip:  true,
+	mimeBzip2: true,
+	mimeXz:    true,
+	mimeZstd:  true,
+}
+
+// Match the supported single-file compression formats
+func matchCompressed(data []byte) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x1F\x8B\x08")):
+		return mimeGzip, "gz"
+	case bytes.HasPrefix(data, []byte("\xFD7zXZ\x00")):
+		return mimeXz, "xz"
+	case bytes.HasPrefix(data, []byte("\x28\xB5\x2F\xFD")):
+		return mimeZstd, "zst"
+	case len(data) >= 10 && bytes.HasPrefix(data, []byte("BZh")) &&
+		data[3] >= '1' && data[3] <= '9':
+		// The block size is followed by the magic number of either the first
+		// block or the end of the stream
+		switch string(data[4:10]) {
+		case "1AY&SY", "\x17\x72\x45\x38\x50\x90":
+			return mimeBzip2, "bz2"
+		}
+	}
+	return "", ""
+}
+
+// Thumbnail the decompressed content of a compressed file, such as a gzipped
+// image or tarball. Source is filled with the information of the content,
+// except for the MIME type and extension of the compressed file.
+func processCompressed(rs io.ReadSeeker, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	dec, err := newDecompressor(src.Mime, contextReader{opts.Context(), rs})
+	if err != nil {
+		return
+	}
+	defer dec.Close()
+
+	br := bufio.NewReaderSize(dec, sniffSize)
+	head, err := br.Peek(sniffSize)
+	switch err {
+	case nil:
+	case io.EOF:
+		if len(head) == 0 {
+			err = ErrCantThumbnail
+			return
+		}
+		err = nil
+	default:
+		return
+	}
+
+	var inner Source
+	defer func() {
+		mime, ext := src.Mime, src.Extension
+		*src = inner
+		src.Mime, src.Extension = mime, ext
+	}()
+
+	if mime, _ := matchCompressed(head); mime != "" {
+		// Only a single layer of compression is unpacked, so nested
+		// compressed files can not recurse indefinitely
+		err = ErrCantThumbnail
+		return
+	}
+	if mime, _ := matchTar(head); mime != "" {
+		// Tarballs are read directly from the stream without buffering the
+		// decompressed content
+		inner.Mime = mimeTar
+		return thumbnailTar(io.LimitReader(br, maxDecompressedSize), &inner,
+			opts)
+	}
+
+	// Temporary file to conserve RAM
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// LimitReader protects against decompression bombs
+	n, err := io.Copy(tmp, io.LimitReader(br, maxDecompressedSize+1))
+	if err != nil {
+		return
+	}
+	if n > maxDecompressedSize {
+		err = ErrArchive{ErrCantThumbnail}
+		return
+	}
+
+	inner, thumb, err = processEmbedded(opts.Context(), tmp, src, opts)
+	return
+}
+
+// Create a decompressing reader for the compressed file MIME type
+func newDecompressor(mime string, r io.Reader) (io.ReadCloser, error) {
+	switch mime {
+	case mimeGzip:
+		return gzip.NewReader(r)
+	case mimeBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case mimeXz:
+		dec, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(dec), nil
+	case mimeZstd:
+		dec, err := zstd.NewReader(
+			r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(maxDecompressedSize),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, ErrUnsupportedMIME(mime)
+	}
+}
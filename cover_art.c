@@ -23,3 +23,10 @@ int find_cover_art(AVFormatContext* ctx)
     }
     return -1;
 }
+
+// Return, if the stream is an attached picture
+int is_cover_art(AVFormatContext* ctx, const int stream)
+{
+    return (ctx->streams[stream]->disposition & AV_DISPOSITION_ATTACHED_PIC)
+        != 0;
+}
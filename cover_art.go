@@ -13,6 +13,16 @@ func (c *FFContext) HasCoverArt() bool {
 	return C.find_cover_art(c.avFormatCtx) != -1
 }
 
+// Returns, if the best video stream is an attached picture, like the cover art
+// of audio files, rather than a video
+func (c *FFContext) videoIsCoverArt() (bool, error) {
+	ci, err := c.codecContext(FFVideo)
+	if err != nil {
+		return false, err
+	}
+	return C.is_cover_art(c.avFormatCtx, ci.stream) != 0, nil
+}
+
 // CoverArt extracts any attached image
 func (c *FFContext) CoverArt() []byte {
 	img := C.retrieve_cover_art(c.avFormatCtx)
@@ -26,11 +36,10 @@ func (c *FFContext) CoverArt() []byte {
 	})
 }
 
-func processCoverArt(buf []byte, opts Options) (thumb image.Image, err error) {
-	// Accept anything processable for cover art
-	opts.AcceptedMimeTypes = nil
-
-	_, thumb, err = Process(bytes.NewReader(buf), opts)
+func processCoverArt(buf []byte, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	_, thumb, err = processEmbedded(opts.Context(), bytes.NewReader(buf), src,
+		opts)
 
 	// Propagate allowed failure errors for retry on the container itself
 	// and wrap all other errors.
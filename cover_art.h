@@ -3,3 +3,4 @@
 
 AVPacket retrieve_cover_art(AVFormatContext* ctx);
 int find_cover_art(AVFormatContext* ctx);
+int is_cover_art(AVFormatContext* ctx, const int stream);
@@ -0,0 +1,225 @@
+#include "encode.h"
+#include <libavutil/opt.h>
+#include <libswscale/swscale.h>
+
+// Returns, if fmt is in the AV_PIX_FMT_NONE-terminated list fmts
+static int supports_pix_fmt(
+    const enum AVPixelFormat* fmts, const enum AVPixelFormat fmt)
+{
+    if (!fmts) {
+        return 0;
+    }
+    for (; *fmts != AV_PIX_FMT_NONE; fmts++) {
+        if (*fmts == fmt) {
+            return 1;
+        }
+    }
+    return 0;
+}
+
+// Returns, if any pixel of the RGBA image is not fully opaque
+static int has_alpha(const struct Buffer* img)
+{
+    for (size_t i = 3; i < img->size; i += 4) {
+        if (img->data[i] != 255) {
+            return 1;
+        }
+    }
+    return 0;
+}
+
+// Select the pixel format to encode the image in
+static enum AVPixelFormat select_pix_fmt(
+    const AVCodec* codec, const struct Buffer* img)
+{
+    if (has_alpha(img)) {
+        if (supports_pix_fmt(codec->pix_fmts, AV_PIX_FMT_YUVA420P)) {
+            return AV_PIX_FMT_YUVA420P;
+        }
+        if (supports_pix_fmt(codec->pix_fmts, AV_PIX_FMT_RGBA)) {
+            return AV_PIX_FMT_RGBA;
+        }
+    }
+    if (supports_pix_fmt(codec->pix_fmts, AV_PIX_FMT_YUV420P)) {
+        return AV_PIX_FMT_YUV420P;
+    }
+    if (codec->pix_fmts) {
+        return codec->pix_fmts[0];
+    }
+    return AV_PIX_FMT_YUV420P;
+}
+
+// Convert RGBA image to frame's pixel format
+static int convert_image(AVFrame* frame, const struct Buffer* img)
+{
+    struct SwsContext* ctx = sws_getContext(img->width, img->height,
+        AV_PIX_FMT_RGBA, frame->width, frame->height, frame->format,
+        SWS_BICUBIC, NULL, NULL, NULL);
+    if (!ctx) {
+        return AVERROR(ENOMEM);
+    }
+
+    const uint8_t* src_data[1] = { img->data }; // RGB have one plane
+    const int src_linesize[1] = { 4 * img->width }; // RGBA stride
+
+    sws_scale(ctx, src_data, src_linesize, 0, img->height, frame->data,
+        frame->linesize);
+
+    sws_freeContext(ctx);
+    return 0;
+}
+
+// Encode a single frame into pkt
+static int encode_frame_packet(
+    AVCodecContext* avcc, const AVFrame* frame, AVPacket* pkt)
+{
+    int err = avcodec_send_frame(avcc, frame);
+    if (err < 0) {
+        return err;
+    }
+
+    // Flush encoder. Some encoders only output packets on flush.
+    err = avcodec_send_frame(avcc, NULL);
+    if (err < 0) {
+        return err;
+    }
+    return avcodec_receive_packet(avcc, pkt);
+}
+
+// Wrap the encoded packet into a container and write it to out
+static int mux_packet(struct Buffer* out, AVFormatContext* ofc,
+    const AVCodecContext* avcc, AVPacket* pkt)
+{
+    uint8_t* buf = NULL;
+    int size = 0;
+    AVStream* st = avformat_new_stream(ofc, NULL);
+    if (!st) {
+        return AVERROR(ENOMEM);
+    }
+    st->time_base = avcc->time_base;
+    int err = avcodec_parameters_from_context(st->codecpar, avcc);
+    if (err < 0) {
+        return err;
+    }
+
+    err = avio_open_dyn_buf(&ofc->pb);
+    if (err < 0) {
+        return err;
+    }
+    err = avformat_write_header(ofc, NULL);
+    if (err < 0) {
+        goto end;
+    }
+    pkt->stream_index = st->index;
+    pkt->pts = pkt->dts = 0;
+    err = av_write_frame(ofc, pkt);
+    if (err < 0) {
+        goto end;
+    }
+    err = av_write_trailer(ofc);
+
+end:
+    size = avio_close_dyn_buf(ofc->pb, &buf);
+    ofc->pb = NULL;
+    if (!err) {
+        out->size = size;
+        out->data = malloc(size);
+        memcpy(out->data, buf, size);
+    }
+    av_free(buf);
+    return err;
+}
+
+int encode_image(struct Buffer* out, const struct Buffer* img,
+    const char* encoder, const char* muxer, const int quality)
+{
+    int err = 0;
+    AVCodecContext* avcc = NULL;
+    AVFormatContext* ofc = NULL;
+    AVFrame* frame = NULL;
+    AVPacket* pkt = NULL;
+
+    const AVCodec* codec = avcodec_find_encoder_by_name(encoder);
+    if (!codec) {
+        return AVERROR_ENCODER_NOT_FOUND;
+    }
+    if (muxer) {
+        if (!av_guess_format(muxer, NULL, NULL)) {
+            return AVERROR_MUXER_NOT_FOUND;
+        }
+        err = avformat_alloc_output_context2(&ofc, NULL, muxer, NULL);
+        if (err < 0) {
+            return err;
+        }
+    }
+
+    avcc = avcodec_alloc_context3(codec);
+    frame = av_frame_alloc();
+    pkt = av_packet_alloc();
+    if (!avcc || !frame || !pkt) {
+        err = AVERROR(ENOMEM);
+        goto end;
+    }
+
+    avcc->width = img->width;
+    avcc->height = img->height;
+    avcc->pix_fmt = select_pix_fmt(codec, img);
+    avcc->time_base = (AVRational) { 1, 25 };
+    avcc->flags |= AV_CODEC_FLAG_QSCALE;
+    avcc->global_quality = quality * FF_QP2LAMBDA;
+    if (ofc && ofc->oformat->flags & AVFMT_GLOBALHEADER) {
+        avcc->flags |= AV_CODEC_FLAG_GLOBAL_HEADER;
+    }
+
+    // Encoders without QSCALE support, like the AV1 ones, are controlled with
+    // a constant rate factor from 0 (best) to 63 (worst) instead. Ignore
+    // failure for encoders without the option.
+    av_opt_set_int(avcc, "crf", 63 - quality * 63 / 100,
+        AV_OPT_SEARCH_CHILDREN);
+
+    err = open_codec(avcc, codec);
+    if (err < 0) {
+        goto end;
+    }
+
+    frame->width = img->width;
+    frame->height = img->height;
+    frame->format = avcc->pix_fmt;
+    frame->pts = 0;
+    err = av_frame_get_buffer(frame, 0);
+    if (err < 0) {
+        goto end;
+    }
+    err = convert_image(frame, img);
+    if (err < 0) {
+        goto end;
+    }
+
+    err = encode_frame_packet(avcc, frame, pkt);
+    if (err < 0) {
+        goto end;
+    }
+
+    if (ofc) {
+        err = mux_packet(out, ofc, avcc, pkt);
+    } else {
+        out->size = pkt->size;
+        out->data = malloc(pkt->size);
+        memcpy(out->data, pkt->data, pkt->size);
+    }
+
+end:
+    if (pkt) {
+        av_packet_free(&pkt);
+    }
+    if (frame) {
+        av_frame_free(&frame);
+    }
+    if (avcc) {
+        avcodec_free_context(&avcc);
+    }
+    if (ofc) {
+        avformat_free_context(ofc);
+    }
+    return err;
+}
@@ -0,0 +1,156 @@
+package thumbnailer
+
+// #include "encode.h"
+import "C"
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"unsafe"
+)
+
+// OutputFormat is an image format thumbnails can be encoded to
+type OutputFormat uint8
+
+// Supported thumbnail output formats
+const (
+	// JPEG for opaque and PNG for transparent thumbnails. Animations are
+	// encoded as GIF.
+	FormatAuto OutputFormat = iota
+	FormatJPEG
+	FormatPNG
+
+	// WebP and AVIF depend on FFmpeg being built with the respective encoders
+	// and muxers. If not available, Encode returns ErrFormatUnavailable.
+	FormatWebP
+	FormatAVIF
+)
+
+// DefaultQuality is the lossy encoding quality used, if none is specified
+const DefaultQuality = 85
+
+// ErrFormatUnavailable denotes the FFmpeg build lacks the encoder or muxer for
+// the requested output format
+var ErrFormatUnavailable = errors.New("output format not available")
+
+// Libavcodec encoders to try in order of preference
+var avifEncoders = [...]string{"libaom-av1", "libsvtav1", "librav1e"}
+
+// Encode encodes a thumbnail returned by Process to w according to
+// opts.OutputFormat, opts.Quality and opts.PNGCompression.
+//
+// Transparent thumbnails are encoded as PNG, if the requested format does not
+// support transparency. Returns the MIME type of the chosen format.
+func Encode(w io.Writer, thumb image.Image, opts Options,
+) (mime string, err error) {
+	if opts.Quality <= 0 || opts.Quality > 100 {
+		opts.Quality = DefaultQuality
+	}
+
+	format := opts.OutputFormat
+	if anim, ok := thumb.(*Animation); ok {
+		if format == FormatAuto {
+			return "image/gif", gif.EncodeAll(w, anim.GIF())
+		}
+		thumb = anim.Frames[0]
+	}
+	switch format {
+	case FormatAuto, FormatJPEG, FormatAVIF:
+		if !isOpaque(thumb) {
+			format = FormatPNG
+		} else if format == FormatAuto {
+			format = FormatJPEG
+		}
+	}
+
+	switch format {
+	case FormatJPEG:
+		return "image/jpeg", jpeg.Encode(w, thumb, &jpeg.Options{
+			Quality: opts.Quality,
+		})
+	case FormatPNG:
+		enc := png.Encoder{
+			CompressionLevel: opts.PNGCompression,
+		}
+		return "image/png", enc.Encode(w, thumb)
+	case FormatWebP:
+		return "image/webp", encodeFFmpeg(w, thumb, "libwebp", "", opts)
+	case FormatAVIF:
+		for _, enc := range avifEncoders {
+			err = encodeFFmpeg(w, thumb, enc, "avif", opts)
+			if err != ErrFormatUnavailable {
+				return "image/avif", err
+			}
+		}
+		return
+	default:
+		return "", ErrFormatUnavailable
+	}
+}
+
+// Returns, if img has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Encode img with the named libavcodec encoder and optionally wrap it with
+// the named libavformat muxer
+func encodeFFmpeg(
+	w io.Writer,
+	img image.Image,
+	encoder, muxer string,
+	opts Options,
+) (err error) {
+	// FFmpeg expects RGBA without premultiplied alpha
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+
+	in := C.struct_Buffer{
+		data:   (*C.uint8_t)(C.CBytes(nrgba.Pix)),
+		size:   C.size_t(len(nrgba.Pix)),
+		width:  C.uint64_t(b.Dx()),
+		height: C.uint64_t(b.Dy()),
+	}
+	defer C.free(unsafe.Pointer(in.data))
+
+	cEncoder := C.CString(encoder)
+	defer C.free(unsafe.Pointer(cEncoder))
+	var cMuxer *C.char
+	if muxer != "" {
+		cMuxer = C.CString(muxer)
+		defer C.free(unsafe.Pointer(cMuxer))
+	}
+
+	var out C.struct_Buffer
+	defer func() {
+		if out.data != nil {
+			C.free(unsafe.Pointer(out.data))
+		}
+	}()
+	ret := C.encode_image(&out, &in, cEncoder, cMuxer, C.int(opts.Quality))
+	switch ret {
+	case 0:
+	case C.AVERROR_ENCODER_NOT_FOUND, C.AVERROR_MUXER_NOT_FOUND:
+		return ErrFormatUnavailable
+	default:
+		return castError(ret)
+	}
+	_, err = w.Write(copyCBuffer(out))
+	return
+}
@@ -0,0 +1,10 @@
+#pragma once
+#include "thumbnailer.h"
+
+// Encodes an RGBA image with the named libavcodec encoder and writes the
+// result to out. If muxer is not NULL, the encoded image is wrapped into a
+// container with the named libavformat muxer.
+//
+// quality is from 1 to 100.
+int encode_image(struct Buffer* out, const struct Buffer* img,
+    const char* encoder, const char* muxer, const int quality);
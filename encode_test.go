@@ -0,0 +1,80 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+	}
+	transparent := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	transparent.Set(1, 1, color.RGBA{0xff, 0, 0, 0xff})
+
+	anim := &Animation{
+		Frames: []*image.RGBA{opaque, transparent},
+		Delays: []time.Duration{100 * time.Millisecond, time.Second},
+	}
+
+	cases := [...]struct {
+		name   string
+		img    image.Image
+		format OutputFormat
+		mime   string
+	}{
+		{"auto opaque", opaque, FormatAuto, "image/jpeg"},
+		{"auto transparent", transparent, FormatAuto, "image/png"},
+		{"auto animation", anim, FormatAuto, "image/gif"},
+		{"jpeg opaque", opaque, FormatJPEG, "image/jpeg"},
+		{"jpeg transparent", transparent, FormatJPEG, "image/png"},
+		{"jpeg animation", anim, FormatJPEG, "image/jpeg"},
+		{"png opaque", opaque, FormatPNG, "image/png"},
+		{"webp opaque", opaque, FormatWebP, "image/webp"},
+		{"webp transparent", transparent, FormatWebP, "image/webp"},
+		{"avif opaque", opaque, FormatAVIF, "image/avif"},
+		{"avif transparent", transparent, FormatAVIF, "image/png"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var w bytes.Buffer
+			mime, err := Encode(&w, c.img, Options{
+				OutputFormat: c.format,
+			})
+			if err == ErrFormatUnavailable {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mime != c.mime {
+				t.Fatalf("unexpected MIME type: %s : %s", c.mime, mime)
+			}
+
+			if mime == "image/avif" {
+				// No AVIF MIME type detection
+				return
+			}
+			detected, _, err := DetectMIME(
+				bytes.NewReader(w.Bytes()),
+				map[string]bool{c.mime: true},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected != c.mime {
+				t.Fatalf("unexpected MIME type: %s : %s", c.mime, detected)
+			}
+		})
+	}
+}
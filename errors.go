@@ -23,6 +23,23 @@ var (
 
 	// ErrStreamNotFound denotes no steam of this media type was found
 	ErrStreamNotFound = errors.New("no stream of this type found")
+
+	// ErrExternalReference denotes an SVG image references a file or URL.
+	// Such images are never rendered.
+	ErrExternalReference = ErrInvalidImage("external reference")
+
+	// ErrEncrypted denotes an archive could not be read, because its headers
+	// or all of its thumbnailable entries are encrypted and
+	// Options.ArchivePassword is not set or incorrect
+	ErrEncrypted = errors.New("archive is encrypted")
+
+	// ErrMultiVolume denotes the file is a volume of an archive split into
+	// multiple files. Such archives can not be read from a single file.
+	ErrMultiVolume = errors.New("archive is split into multiple volumes")
+
+	// ErrArchiveTruncated denotes ListArchive reached a limit set in
+	// ListOptions. The entries listed until then are still returned.
+	ErrArchiveTruncated = errors.New("archive listing truncated")
 )
 
 // Indicates the MIME type of the file could not be detected as a supported type
@@ -40,6 +57,13 @@ func (e ErrInvalidImage) Error() string {
 	return fmt.Sprintf("invalid image: %s", string(e))
 }
 
+// Indicates an invalid or unsupported PDF file has been passed for processing
+type ErrInvalidPDF string
+
+func (e ErrInvalidPDF) Error() string {
+	return fmt.Sprintf("invalid PDF: %s", string(e))
+}
+
 // ErrorCovert wraps an error that happened during cover art thumbnailing
 type ErrCoverArt struct {
 	Err error
@@ -49,6 +73,11 @@ func (e ErrCoverArt) Error() string {
 	return "cover art: " + e.Err.Error()
 }
 
+// Unwrap returns the underlying error
+func (e ErrCoverArt) Unwrap() error {
+	return e.Err
+}
+
 // ErrArchive wraps an error that happened during thumbnailing a file in zip
 // archive
 type ErrArchive struct {
@@ -59,6 +88,11 @@ func (e ErrArchive) Error() string {
 	return "archive: " + e.Err.Error()
 }
 
+// Unwrap returns the underlying error
+func (e ErrArchive) Unwrap() error {
+	return e.Err
+}
+
 // Cast FFmpeg error to Go error
 func castError(err C.int) error {
 	switch err {
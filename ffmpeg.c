@@ -1,4 +1,5 @@
 #include "ffmpeg.h"
+#include "tonemap.h"
 
 static const int bufSize = 1 << 12;
 
@@ -15,7 +16,8 @@ void init(void)
     av_log_set_level(16);
 }
 
-int create_context(AVFormatContext** ctx, const char* input_format)
+int create_context(AVFormatContext** ctx, const char* input_format,
+    const char* video_codec)
 {
     unsigned char* buf = malloc(bufSize);
     AVFormatContext* c = *ctx;
@@ -23,11 +25,20 @@ int create_context(AVFormatContext** ctx, const char* input_format)
     c->pb = avio_alloc_context(
         buf, bufSize, 0, c, readCallBack, NULL, seekCallBack);
     c->flags |= AVFMT_FLAG_CUSTOM_IO | AVFMT_FLAG_DISCARD_CORRUPT;
+    c->interrupt_callback.callback = interruptCallBack;
+    c->interrupt_callback.opaque = c;
 
     AVInputFormat* avif = NULL;
     if (input_format) {
         avif = av_find_input_format(input_format);
     }
+    if (video_codec) {
+        // Formats without a dedicated demuxer are read with a forced decoder
+        const AVCodec* codec = avcodec_find_decoder_by_name(video_codec);
+        if (codec) {
+            c->video_codec_id = codec->id;
+        }
+    }
     int err = avformat_open_input(ctx, NULL, avif, NULL);
     if (err < 0) {
         return err;
@@ -40,6 +51,24 @@ int create_context(AVFormatContext** ctx, const char* input_format)
     return err;
 }
 
+int open_codec(AVCodecContext* avcc, const AVCodec* codec)
+{
+    // Not thread safe. Needs lock.
+    pthread_mutex_lock(&codecMu);
+    const int err = avcodec_open2(avcc, codec, NULL);
+    pthread_mutex_unlock(&codecMu);
+    return err;
+}
+
+int check_interrupt(AVFormatContext* ctx)
+{
+    const AVIOInterruptCB cb = ctx->interrupt_callback;
+    if (cb.callback && cb.callback(cb.opaque)) {
+        return AVERROR_EXIT;
+    }
+    return 0;
+}
+
 int codec_context(AVCodecContext** avcc, int* stream, AVFormatContext* avfc,
     const enum AVMediaType type)
 {
@@ -78,10 +107,7 @@ int codec_context(AVCodecContext** avcc, int* stream, AVFormatContext* avfc,
         goto end;
     }
 
-    // Not thread safe. Needs lock.
-    pthread_mutex_lock(&codecMu);
-    err = avcodec_open2(*avcc, codec, NULL);
-    pthread_mutex_unlock(&codecMu);
+    err = open_codec(*avcc, codec);
 
 end:
     if (err < 0 && *avcc) {
@@ -90,3 +116,80 @@ end:
     }
     return err;
 }
+
+// Convert a timestamp in AV_TIME_BASE units to the time base of st
+static int64_t stream_timestamp(const AVStream* st, const int64_t ts)
+{
+    int64_t out = av_rescale_q(ts, AV_TIME_BASE_Q, st->time_base);
+    if (st->start_time != AV_NOPTS_VALUE) {
+        out += st->start_time;
+    }
+    return out;
+}
+
+int seek_stream(AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const int64_t ts)
+{
+    if (!avfc->pb || !(avfc->pb->seekable & AVIO_SEEKABLE_NORMAL)) {
+        return AVERROR(ESPIPE);
+    }
+
+    const AVStream* st = avfc->streams[stream];
+    int err = av_seek_frame(
+        avfc, stream, stream_timestamp(st, ts), AVSEEK_FLAG_BACKWARD);
+    if (err < 0) {
+        av_seek_frame(avfc, stream, stream_timestamp(st, 0),
+            AVSEEK_FLAG_BACKWARD | AVSEEK_FLAG_ANY);
+    }
+    avcodec_flush_buffers(avcc);
+    return err;
+}
+
+// Read from stream until a full frame is read
+int read_frame(AVFormatContext* avfc, AVCodecContext* avcc,
+    AVFrame* frame, const int stream)
+{
+    int err = 0;
+    AVPacket pkt = { 0 };
+
+    // Continue until frame read
+    while (1) {
+        // Decoding does not poll the interrupt callback on its own
+        err = check_interrupt(avfc);
+        if (err) {
+            goto end;
+        }
+
+        err = av_read_frame(avfc, &pkt);
+        if (err) {
+            goto end;
+        }
+
+        if (pkt.stream_index == stream) {
+            err = avcodec_send_packet(avcc, &pkt);
+            if (err < 0) {
+                goto end;
+            }
+
+            err = avcodec_receive_frame(avcc, frame);
+            switch (err) {
+            case 0:
+                if (avcc->codec_type == AVMEDIA_TYPE_VIDEO) {
+                    err = tone_map(frame);
+                }
+                goto end;
+            case AVERROR(EAGAIN):
+                av_packet_unref(&pkt);
+                break;
+            default:
+                goto end;
+            }
+        } else {
+            av_packet_unref(&pkt);
+        }
+    }
+
+end:
+    av_packet_unref(&pkt);
+    return err;
+}
@@ -2,11 +2,15 @@ package thumbnailer
 
 // #cgo pkg-config: libavcodec libavutil libavformat libswscale
 // #cgo CFLAGS: -std=c11 -g
+// #cgo LDFLAGS: -lm
 // #include "ffmpeg.h"
 import "C"
 import (
+	"context"
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
 	"sync"
 	"time"
@@ -27,43 +31,67 @@ var (
 	// Global map of AVIOHandlers. One handlers struct per format context.
 	// Using AVFormatContext pointer address as a key.
 	handlersMap = handlerMap{
-		m: make(map[uintptr]io.ReadSeeker),
+		m: make(map[uintptr]handlers),
 	}
 
 	// Input format specifiers for FFmpeg. These save FFmpeg some overhead on
 	// format detection and also prevent failure to open input on format
 	// detection failure.
 	inputFormats = map[string]*C.char{
-		"image/jpeg":       C.CString("mjpeg"),
-		"image/png":        C.CString("image2"),
-		"image/gif":        C.CString("gif"),
-		"image/webp":       C.CString("webp"),
-		"application/ogg":  C.CString("ogg"),
-		"video/webm":       C.CString("webm"),
-		"video/x-matroska": C.CString("matroska"),
-		"video/mp4":        C.CString("mp4"),
-		"video/avi":        C.CString("avi"),
-		"video/quicktime":  C.CString("mp4"),
-		"video/x-flv":      C.CString("flv"),
-		"audio/mpeg":       C.CString("mp3"),
-		"audio/aac":        C.CString("aac"),
-		"audio/wave":       C.CString("wav"),
-		"audio/x-flac":     C.CString("flac"),
+		"image/jpeg":         C.CString("mjpeg"),
+		"image/png":          C.CString("image2"),
+		"image/gif":          C.CString("gif"),
+		"image/webp":         C.CString("webp"),
+		"image/bmp":          C.CString("bmp_pipe"),
+		"image/tiff":         C.CString("tiff_pipe"),
+		"image/x-icon":       C.CString("ico"),
+		"image/x-tga":        C.CString("image2pipe"),
+		"image/jp2":          C.CString("j2k_pipe"),
+		"image/qoi":          C.CString("qoi_pipe"),
+		"image/vnd-ms.dds":   C.CString("dds_pipe"),
+		"image/x-pcx":        C.CString("image2pipe"),
+		"image/sgi":          C.CString("sgi_pipe"),
+		"image/x-exr":        C.CString("exr_pipe"),
+		"image/vnd.radiance": C.CString("hdr_pipe"),
+		"application/ogg":    C.CString("ogg"),
+		"video/webm":         C.CString("webm"),
+		"video/x-matroska":   C.CString("matroska"),
+		"video/mp4":          C.CString("mp4"),
+		"video/avi":          C.CString("avi"),
+		"video/quicktime":    C.CString("mp4"),
+		"video/x-flv":        C.CString("flv"),
+		"audio/mpeg":         C.CString("mp3"),
+		"audio/aac":          C.CString("aac"),
+		"audio/wave":         C.CString("wav"),
+		"audio/x-flac":       C.CString("flac"),
+	}
+
+	// Decoders for formats without magic numbers FFmpeg can probe for. These
+	// are read with the generic image2pipe demuxer.
+	inputCodecs = map[string]*C.char{
+		"image/x-tga": C.CString("targa"),
+		"image/x-pcx": C.CString("pcx"),
 	}
 )
 
+// I/O and cancellation handlers of a single AVFormatContext
+type handlers struct {
+	rs  io.ReadSeeker
+	ctx context.Context
+}
+
 // C can not retain any pointers to Go memory after the cgo call returns. We
 // still need a way to bind AVFormatContext instances to Go I/O functions. To do
 // that we convert the AVFormatContext pointer to a uintptr and use it as a key
 // to look up the respective handlers on each call.
 type handlerMap struct {
 	sync.RWMutex
-	m map[uintptr]io.ReadSeeker
+	m map[uintptr]handlers
 }
 
-func (h *handlerMap) Set(k uintptr, rs io.ReadSeeker) {
+func (h *handlerMap) Set(k uintptr, hs handlers) {
 	h.Lock()
-	h.m[k] = rs
+	h.m[k] = hs
 	h.Unlock()
 }
 
@@ -73,7 +101,7 @@ func (h *handlerMap) Delete(k uintptr) {
 	h.Unlock()
 }
 
-func (h *handlerMap) Get(k unsafe.Pointer) io.ReadSeeker {
+func (h *handlerMap) Get(k unsafe.Pointer) handlers {
 	h.RLock()
 	handlers, ok := h.m[uintptr(k)]
 	h.RUnlock()
@@ -91,35 +119,72 @@ type codecInfo struct {
 
 // FFContext is a wrapper for passing Go I/O interfaces to C
 type FFContext struct {
+	// Algorithm used to scale thumbnails generated from this context
+	ScaleFilter ScaleFilter
+
+	// Fitting of thumbnails generated from this context into their bounding
+	// box and the colour to pad them with for FitPad. Transparent, if nil.
+	Fit        Fit
+	Background color.Color
+
+	// If set, Thumbnails and ThumbnailAnimation store a fixed size sample of
+	// the thumbnailed frame for image analysis in sample
+	sampleFrames bool
+	sample       *image.RGBA
+
 	avFormatCtx *C.struct_AVFormatContext
 	handlerKey  uintptr
+	ctx         context.Context
 	codecs      map[FFMediaType]codecInfo
 }
 
 // NewFFContext constructs a new AVIOContext and AVFormatContext.
 // It is the responsibility of the caller to call Close() after finishing
 // using the context.
+//
+// The input format is probed from the content. Formats FFmpeg can not probe,
+// like TGA and PCX images, can not be opened and are only supported by
+// Process.
 func NewFFContext(rs io.ReadSeeker) (*FFContext, error) {
-	return newFFContextWithFormat(rs, nil)
+	return newFFContextWithFormat(context.Background(), rs, "")
 }
 
-// Like NewFFContext, but optionally specifies the passed input format explicitly.
-// inputFormat can be NULL.
-func newFFContextWithFormat(rs io.ReadSeeker, inputFormat *C.char,
+// NewFFContextWithContext is like NewFFContext, but aborts any I/O, demuxing
+// and decoding performed through the returned FFContext, once ctx is done.
+func NewFFContextWithContext(ctx context.Context, rs io.ReadSeeker,
 ) (*FFContext, error) {
-	ctx := C.avformat_alloc_context()
+	return newFFContextWithFormat(ctx, rs, "")
+}
+
+// Like NewFFContextWithContext, but optionally specifies the input format and
+// decoder of the passed MIME type explicitly. mime can be empty.
+func newFFContextWithFormat(
+	ctx context.Context,
+	rs io.ReadSeeker,
+	mime string,
+) (*FFContext, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	avfc := C.avformat_alloc_context()
 	this := &FFContext{
-		avFormatCtx: ctx,
+		avFormatCtx: avfc,
+		ctx:         ctx,
 		codecs:      make(map[FFMediaType]codecInfo),
 	}
 
-	this.handlerKey = uintptr(unsafe.Pointer(ctx))
-	handlersMap.Set(this.handlerKey, rs)
+	this.handlerKey = uintptr(unsafe.Pointer(avfc))
+	handlersMap.Set(this.handlerKey, handlers{
+		rs:  rs,
+		ctx: ctx,
+	})
 
-	err := C.create_context(&this.avFormatCtx, inputFormat)
+	err := C.create_context(&this.avFormatCtx, inputFormats[mime],
+		inputCodecs[mime])
 	if err < 0 {
 		this.Close()
-		return nil, castError(err)
+		return nil, this.castError(err)
 	}
 	if this.avFormatCtx == nil {
 		this.Close()
@@ -144,6 +209,15 @@ func (c *FFContext) Close() {
 	handlersMap.Delete(c.handlerKey)
 }
 
+// Cast FFmpeg error to Go error. Returns the context error instead, if the
+// operation was aborted due to context cancellation or deadline expiry.
+func (c *FFContext) castError(err C.int) error {
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return castError(err)
+}
+
 // Allocate a codec context for the best stream of the passed FFMediaType, if
 // not allocated already
 func (c *FFContext) codecContext(typ FFMediaType) (codecInfo, error) {
@@ -160,7 +234,7 @@ func (c *FFContext) codecContext(typ FFMediaType) (codecInfo, error) {
 	case err == C.AVERROR_STREAM_NOT_FOUND:
 		return codecInfo{}, ErrStreamNotFound
 	case err < 0:
-		return codecInfo{}, castError(err)
+		return codecInfo{}, c.castError(err)
 	}
 
 	ci := codecInfo{
@@ -198,6 +272,22 @@ func (c *FFContext) Length() time.Duration {
 	return time.Duration(c.avFormatCtx.duration * 1000)
 }
 
+// Seek positions the best video stream on the nearest keyframe preceding ts,
+// so that the next call to Thumbnail or ThumbnailAnimation starts from there.
+// If seeking fails, the input is rewound to its start.
+func (c *FFContext) Seek(ts time.Duration) error {
+	ci, err := c.codecContext(FFVideo)
+	if err != nil {
+		return err
+	}
+	ret := C.seek_stream(c.avFormatCtx, ci.ctx, ci.stream,
+		C.int64_t(ts/time.Microsecond))
+	if ret < 0 {
+		return c.castError(ret)
+	}
+	return nil
+}
+
 // Dims returns dimensions of the best video (or image) stream in the media
 func (c *FFContext) Dims() (dims Dims, err error) {
 	ci, err := c.codecContext(FFVideo)
@@ -221,8 +311,12 @@ func castIOError(err error) C.int {
 
 //export readCallBack
 func readCallBack(opaque unsafe.Pointer, buf *C.uint8_t, bufSize C.int) C.int {
+	h := handlersMap.Get(opaque)
+	if h.ctx.Err() != nil {
+		return C.AVERROR_EXIT
+	}
 	s := (*[1 << 30]byte)(unsafe.Pointer(buf))[:bufSize:bufSize]
-	n, err := handlersMap.Get(opaque).Read(s)
+	n, err := h.rs.Read(s)
 	if err != nil && !(err == io.EOF && n != 0) {
 		return castIOError(err)
 	}
@@ -235,9 +329,20 @@ func seekCallBack(
 	offset C.int64_t,
 	whence C.int,
 ) C.int64_t {
-	n, err := handlersMap.Get(opaque).Seek(int64(offset), int(whence))
+	n, err := handlersMap.Get(opaque).rs.Seek(int64(offset), int(whence))
 	if err != nil {
 		return C.int64_t(castIOError(err))
 	}
 	return C.int64_t(n)
 }
+
+// Polled by FFmpeg during blocking operations. Returning non-zero aborts the
+// operation with AVERROR_EXIT.
+//
+//export interruptCallBack
+func interruptCallBack(opaque unsafe.Pointer) C.int {
+	if handlersMap.Get(opaque).ctx.Err() != nil {
+		return 1
+	}
+	return 0
+}
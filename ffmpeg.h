@@ -4,14 +4,33 @@
 
 extern int readCallBack(void*, uint8_t*, int);
 extern int64_t seekCallBack(void*, int64_t, int);
+extern int interruptCallBack(void*);
 
 // Initialize FFmpeg
 void init(void);
 
 // Initialize am AVFormatContext with the buffered file/
-// input_format can be NULL.
-int create_context(AVFormatContext** ctx, const char* input_format);
+// input_format and video_codec can be NULL.
+int create_context(AVFormatContext** ctx, const char* input_format,
+    const char* video_codec);
+
+// Thread-safe wrapper around avcodec_open2()
+int open_codec(AVCodecContext* avcc, const AVCodec* codec);
+
+// Returns AVERROR_EXIT, if processing of ctx should be aborted, and 0
+// otherwise
+int check_interrupt(AVFormatContext* ctx);
 
 // Create a AVCodecContext of the desired media type
 int codec_context(AVCodecContext** avcc, int* stream, AVFormatContext* avfc,
     const enum AVMediaType type);
+
+// Seek to the nearest keyframe preceding ts, specified in AV_TIME_BASE units,
+// in the passed stream and flush the decoder.
+// Rewinds to the start of the input on failure.
+int seek_stream(AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const int64_t ts);
+
+// Read from stream until a full frame is decoded
+int read_frame(AVFormatContext* avfc, AVCodecContext* avcc, AVFrame* frame,
+    const int stream);
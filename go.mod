@@ -1,5 +1,26 @@
 module github.com/bakape/thumbnailer/v2
 
-go 1.13
+go 1.20
 
-require github.com/nwaples/rardecode v1.1.0
+require (
+	github.com/bodgit/sevenzip v1.6.0
+	github.com/klauspost/compress v1.17.9
+	github.com/nwaples/rardecode v1.1.0
+	github.com/srwiley/oksvg v0.0.0-20200311192757-870daf9aa564
+	github.com/srwiley/rasterx v0.0.0-20200120212402-85cb7272f5e9
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/net v0.25.0
+	golang.org/x/text v0.20.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/bodgit/plumbing v1.3.0 // indirect
+	github.com/bodgit/windows v1.0.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
+	golang.org/x/image v0.0.0-20200119044424-58c23975cae1 // indirect
+)
@@ -0,0 +1,163 @@
+package thumbnailer
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HammingDistance returns the number of differing bits between two perceptual
+// hashes. Images with a distance of up to about 10 are likely to be
+// variations of the same image.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Compute the average, difference and DCT perceptual hashes of img.
+// Transparent regions are treated as black.
+func perceptualHashes(img image.Image) (aHash, dHash, pHash uint64) {
+	gray, w, h := grayscale(img)
+
+	// Average hash: 8x8 pixels compared to their mean
+	small := resizeGray(gray, w, h, 8, 8)
+	var mean float64
+	for _, v := range small {
+		mean += v
+	}
+	mean /= float64(len(small))
+	aHash = hashBits(small, func(i int) bool {
+		return small[i] > mean
+	})
+
+	// Difference hash: horizontal gradients of 9x8 pixels
+	small = resizeGray(gray, w, h, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			dHash <<= 1
+			if small[y*9+x+1] > small[y*9+x] {
+				dHash |= 1
+			}
+		}
+	}
+
+	// DCT hash: lowest 8x8 frequencies of 32x32 pixels compared to their
+	// median
+	freq := dct2D(resizeGray(gray, w, h, 32, 32), 32)
+	low := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		low = append(low, freq[y*32:y*32+8]...)
+	}
+	sorted := append([]float64(nil), low...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	pHash = hashBits(low, func(i int) bool {
+		return low[i] > median
+	})
+
+	return
+}
+
+// Pack 64 boolean values into a hash with the first value in the most
+// significant bit
+func hashBits(vals []float64, set func(i int) bool) (h uint64) {
+	for i := range vals {
+		h <<= 1
+		if set(i) {
+			h |= 1
+		}
+	}
+	return
+}
+
+// Convert img to a row-major slice of luma values
+func grayscale(img image.Image) (gray []float64, w, h int) {
+	b := img.Bounds()
+	w, h = b.Dx(), b.Dy()
+	gray = make([]float64, 0, w*h)
+	rgba, ok := img.(*image.RGBA)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var r, g, b uint32
+			if ok {
+				p := rgba.Pix[rgba.PixOffset(x, y):]
+				r, g, b = uint32(p[0]), uint32(p[1]), uint32(p[2])
+			} else {
+				r, g, b, _ = img.At(x, y).RGBA()
+				r, g, b = r>>8, g>>8, b>>8
+			}
+			gray = append(gray,
+				0.299*float64(r)+0.587*float64(g)+0.114*float64(b))
+		}
+	}
+	return
+}
+
+// Resize a row-major luma image to dw*dh by averaging the source pixels
+// covered by each destination pixel
+func resizeGray(src []float64, sw, sh, dw, dh int) []float64 {
+	dst := make([]float64, dw*dh)
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := coveredRange(dy, sh, dh)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := coveredRange(dx, sw, dw)
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += src[y*sw+x]
+				}
+			}
+			dst[dy*dw+dx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return dst
+}
+
+// Range of source pixels along one axis covered by destination pixel i.
+// Always covers at least one pixel.
+func coveredRange(i, srcLen, dstLen int) (start, end int) {
+	start = i * srcLen / dstLen
+	end = (i + 1) * srcLen / dstLen
+	if end <= start {
+		end = start + 1
+	}
+	if end > srcLen {
+		start, end = srcLen-1, srcLen
+	}
+	return
+}
+
+// Two-dimensional type II discrete cosine transform of a n*n row-major matrix
+func dct2D(src []float64, n int) []float64 {
+	// Precompute the cosine basis
+	basis := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			basis[k*n+i] = math.Cos(math.Pi / float64(n) *
+				(float64(i) + 0.5) * float64(k))
+		}
+	}
+
+	// Transform rows and then columns
+	tmp := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += src[y*n+i] * basis[k*n+i]
+			}
+			tmp[y*n+k] = sum
+		}
+	}
+	dst := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += tmp[i*n+x] * basis[k*n+i]
+			}
+			dst[k*n+x] = sum
+		}
+	}
+	return dst
+}
@@ -0,0 +1,176 @@
+package thumbnailer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestHammingDistance(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		a, b uint64
+		dist int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xf0, 0x0f, 8},
+		{0, ^uint64(0), 64},
+	}
+	for _, c := range cases {
+		if d := HammingDistance(c.a, c.b); d != c.dist {
+			t.Errorf("%x ^ %x: expected %d, got %d", c.a, c.b, c.dist, d)
+		}
+	}
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	t.Parallel()
+
+	// Diagonal gradient with a bright square
+	img := image.NewRGBA(image.Rect(0, 0, 256, 192))
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			v := uint8((x + y) / 2)
+			if x > 150 && x < 200 && y > 20 && y < 70 {
+				v = 0xff
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	resized := boxDownsample(img, 100)
+
+	// Horizontally mirrored
+	mirrored := image.NewRGBA(img.Rect)
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			mirrored.Set(255-x, y, img.At(x, y))
+		}
+	}
+
+	a1, d1, p1 := perceptualHashes(img)
+	a2, d2, p2 := perceptualHashes(resized)
+	a3, d3, p3 := perceptualHashes(mirrored)
+
+	for _, c := range [...]struct {
+		name                  string
+		orig, resized, mirror uint64
+	}{
+		{"aHash", a1, a2, a3},
+		{"dHash", d1, d2, d3},
+		{"pHash", p1, p2, p3},
+	} {
+		if d := HammingDistance(c.orig, c.resized); d > 4 {
+			t.Errorf("%s: resized image distance too big: %d", c.name, d)
+		}
+		if d := HammingDistance(c.orig, c.mirror); d < 16 {
+			t.Errorf("%s: mirrored image distance too small: %d", c.name, d)
+		}
+	}
+}
+
+func TestProcessPerceptualHashes(t *testing.T) {
+	t.Parallel()
+
+	hashes := func(name string) Source {
+		f := openSample(t, name)
+		defer f.Close()
+
+		src, _, err := Process(f, Options{
+			ThumbDims: Dims{50, 50},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+
+	// Exif orientation is applied before hashing
+	base := hashes("jannu_baseline.jpg")
+	rotated := hashes("jannu_90.jpg")
+	other := hashes("sample.png")
+
+	if d := HammingDistance(base.PHash, rotated.PHash); d > 10 {
+		t.Fatalf("rotated image distance too big: %d", d)
+	}
+	if d := HammingDistance(base.PHash, other.PHash); d <= 10 {
+		t.Fatalf("different image distance too small: %d", d)
+	}
+}
+
+func TestEmbeddedPerceptualHashes(t *testing.T) {
+	t.Parallel()
+
+	img := readSample(t, "non_square.png")
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, err := zw.Create("01.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err = gw.Write(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fit := range [...]Fit{FitContain, FitCover, FitPad} {
+		fit := fit
+		t.Run(fmt.Sprint(fit), func(t *testing.T) {
+			t.Parallel()
+
+			process := func(buf []byte) Source {
+				t.Helper()
+
+				src, _, err := Process(bytes.NewReader(buf), Options{
+					ThumbDims: Dims{50, 50},
+					Fit:       fit,
+					Palette:   4,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return src
+			}
+
+			// Hashes and palette of the image are the same, whether it is
+			// processed on its own or embedded in another file
+			std := process(img)
+			for name, buf := range map[string][]byte{
+				"zip":  zipped.Bytes(),
+				"gzip": gzipped.Bytes(),
+			} {
+				src := process(buf)
+				if src.AHash != std.AHash ||
+					src.DHash != std.DHash ||
+					src.PHash != std.PHash {
+					t.Fatalf("%s: hashes differ", name)
+				}
+				if !reflect.DeepEqual(src.Palette, std.Palette) {
+					t.Fatalf("%s: palettes differ", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package thumbnailer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+)
+
+// ListOptions are supplied to ListArchive
+type ListOptions struct {
+	// Maximum amount of entries to list including directories.
+	//
+	// Defaults to 4096.
+	MaxEntries int
+
+	// Maximum total uncompressed size of the listed entries. Also limits the
+	// amount of data decompressed to detect the MIME types of entries.
+	//
+	// Defaults to 1 GiB.
+	MaxTotalSize int64
+}
+
+// ArchiveEntry describes a file or directory inside an archive
+type ArchiveEntry struct {
+	// Path inside the archive separated by "/". Names not encoded in UTF-8
+	// are decoded from legacy encodings. Empty for compressed files, that do
+	// not store the name of their content.
+	Name string
+
+	// Size of the entry inside the archive. Zero, if not known, as for
+	// entries of 7z archives and compressed tarballs.
+	CompressedSize int64
+
+	// Size of the content of the entry
+	Size int64
+
+	// Zero, if not known
+	Modified time.Time
+
+	IsDir bool
+
+	// Entry can not be read without a password. Not reported for 7z
+	// archives.
+	Encrypted bool
+
+	// MIME type detected from the start of the content. Empty for
+	// directories, encrypted entries and entries of unknown type.
+	Mime string
+}
+
+func (o *ListOptions) setDefaults() {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = maxArchiveEntries
+	}
+	if o.MaxTotalSize <= 0 {
+		o.MaxTotalSize = 1 << 30
+	}
+}
+
+// ListArchive lists the entries of a zip, rar, 7z or tar archive or
+// compressed file without extracting them.
+//
+// If a limit set in opts is reached, the entries listed until then are
+// returned with ErrArchiveTruncated.
+func ListArchive(rs io.ReadSeeker, opts ListOptions) ([]ArchiveEntry, error) {
+	return ListArchiveContext(context.Background(), rs, opts)
+}
+
+// ListArchiveContext is like ListArchive, but aborts listing, once ctx is
+// done. The returned error then is ctx.Err().
+func ListArchiveContext(
+	ctx context.Context,
+	rs io.ReadSeeker,
+	opts ListOptions,
+) (
+	entries []ArchiveEntry, err error,
+) {
+	opts.setDefaults()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	mime, _, err := DetectMIME(rs, nil)
+	if err != nil {
+		return
+	}
+	_, err = rs.Seek(0, 0)
+	if err != nil {
+		return
+	}
+
+	l := archiveLister{
+		ctx:  ctx,
+		opts: opts,
+	}
+	switch {
+	case mime == mimeZip:
+		err = l.listZip(rs)
+	case mime == mimeRar:
+		err = l.listRar(rs)
+	case mime == mime7Zip:
+		err = l.list7z(rs)
+	case mime == mimeTar:
+		err = l.listTar(contextReader{ctx, rs}, true)
+	case compressedMIMEs[mime]:
+		err = l.listCompressed(rs, mime)
+	default:
+		err = ErrUnsupportedMIME(mime)
+	}
+	if err == nil && l.truncated {
+		err = ErrArchiveTruncated
+	}
+	return l.entries, err
+}
+
+// Lists the entries of an archive within the limits of ListOptions
+type archiveLister struct {
+	ctx     context.Context
+	opts    ListOptions
+	entries []ArchiveEntry
+
+	// Total size of the listed entries
+	size int64
+
+	// A limit was reached and not all entries were listed
+	truncated bool
+}
+
+// Append an entry to the listing. Returns false, if a limit was reached and
+// the entry was not listed.
+func (l *archiveLister) add(e ArchiveEntry) bool {
+	if len(l.entries) == l.opts.MaxEntries ||
+		e.Size < 0 ||
+		e.Size > l.opts.MaxTotalSize-l.size {
+		l.truncated = true
+		return false
+	}
+	e.Name = strings.TrimSuffix(e.Name, "/")
+	if !e.Modified.IsZero() {
+		e.Modified = e.Modified.UTC()
+	}
+	l.size += e.Size
+	l.entries = append(l.entries, e)
+	return true
+}
+
+// Returns, if the content of the i-th listed entry can be read to detect its
+// MIME type
+func (l *archiveLister) canSniff(i int) bool {
+	e := l.entries[i]
+	return !e.IsDir && !e.Encrypted && e.Size != 0
+}
+
+// Detect the MIME type of the i-th listed entry from the start of its content.
+// Entries, that can not be read, are skipped, so only the error of the context
+// is returned.
+func (l *archiveLister) sniff(i int, r io.Reader) error {
+	head, err := readHead(l.ctx, r)
+	if err == nil {
+		l.entries[i].Mime = sniffMIME(head)
+	}
+	return l.ctx.Err()
+}
+
+func (l *archiveLister) listZip(rs io.ReadSeeker) (err error) {
+	ra, size, cleanup, err := readerAt(rs, Options{ctx: l.ctx})
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	if isZipVolume(ra, size) {
+		return ErrMultiVolume
+	}
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return
+	}
+
+	for _, f := range r.File {
+		ok := l.add(ArchiveEntry{
+			Name:           decodeName(f.Name),
+			CompressedSize: int64(f.CompressedSize64),
+			Size:           int64(f.UncompressedSize64),
+			Modified:       f.Modified,
+			IsDir:          f.FileInfo().IsDir(),
+			Encrypted:      f.Flags&0x1 != 0,
+		})
+		if !ok {
+			return
+		}
+
+		i := len(l.entries) - 1
+		if !l.canSniff(i) {
+			continue
+		}
+		rc, openErr := f.Open()
+		if openErr != nil {
+			continue
+		}
+		err = l.sniff(i, rc)
+		rc.Close()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *archiveLister) listRar(rs io.ReadSeeker) (err error) {
+	// Read the headers directly, so entries can be listed even if some are
+	// encrypted
+	a, err := scanRar(l.ctx, rs, l.opts.MaxEntries+1)
+	if err != nil {
+		return
+	}
+	if a.encrypted {
+		return ErrEncrypted
+	}
+	sniff := false
+	for _, f := range a.files {
+		ok := l.add(ArchiveEntry{
+			Name:           f.name,
+			CompressedSize: f.packedSize,
+			Size:           f.size,
+			Modified:       f.modified,
+			IsDir:          f.isDir,
+			Encrypted:      f.encrypted,
+		})
+		if !ok {
+			break
+		}
+		sniff = sniff || l.canSniff(len(l.entries)-1)
+	}
+	if !sniff {
+		return
+	}
+
+	// Detect MIME types in a second pass over the archive. The pass ends at
+	// the first entry rardecode can not read.
+	_, err = rs.Seek(0, 0)
+	if err != nil {
+		return
+	}
+	dec, err := rardecode.NewReader(contextReader{l.ctx, rs}, "")
+	if err != nil {
+		return l.ctx.Err()
+	}
+	for i := range l.entries {
+		h, nextErr := dec.Next()
+		if nextErr != nil ||
+			h.IsDir != l.entries[i].IsDir ||
+			h.UnPackedSize != l.entries[i].Size {
+			break
+		}
+		if l.canSniff(i) {
+			err = l.sniff(i, dec)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return l.ctx.Err()
+}
+
+func (l *archiveLister) list7z(rs io.ReadSeeker) (err error) {
+	ra, size, cleanup, err := readerAt(rs, Options{ctx: l.ctx})
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	r, err := sevenzip.NewReader(ra, size)
+	if err != nil {
+		return error7z(err)
+	}
+
+	// Files in solid blocks are decompressed from the start of the block, so
+	// the files preceding a file in its block count against the limit of data
+	// decompressed for sniffing as well
+	var (
+		preceding    = solidPreceding(r.File)
+		decompressed int64
+	)
+	for j, f := range r.File {
+		ok := l.add(ArchiveEntry{
+			Name:     f.Name,
+			Size:     int64(f.UncompressedSize),
+			Modified: f.Modified,
+			IsDir:    f.FileInfo().IsDir(),
+		})
+		if !ok {
+			return
+		}
+
+		i := len(l.entries) - 1
+		if l.canSniff(i) &&
+			preceding[j] <= l.opts.MaxTotalSize-decompressed-sniffSize {
+			decompressed += preceding[j] + sniffSize
+			rc, openErr := f.Open()
+			if openErr == nil {
+				err = l.sniff(i, rc)
+				rc.Close()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// List the regular files and directories of a tar stream. stored specifies,
+// if the stream is not compressed.
+func (l *archiveLister) listTar(r io.Reader, stored bool) (err error) {
+	tr := tar.NewReader(r)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		switch err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return
+		}
+
+		info := h.FileInfo()
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		e := ArchiveEntry{
+			Name:     decodeName(h.Name),
+			Size:     h.Size,
+			Modified: h.ModTime,
+			IsDir:    info.IsDir(),
+		}
+		if stored {
+			e.CompressedSize = h.Size
+		}
+		if !l.add(e) {
+			return
+		}
+
+		i := len(l.entries) - 1
+		if l.canSniff(i) {
+			err = l.sniff(i, tr)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// List a compressed file as a single entry or the entries of a compressed
+// tarball
+func (l *archiveLister) listCompressed(rs io.ReadSeeker, mime string,
+) (err error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	_, err = rs.Seek(0, 0)
+	if err != nil {
+		return
+	}
+
+	dec, err := newDecompressor(mime, contextReader{l.ctx, rs})
+	if err != nil {
+		return
+	}
+	defer dec.Close()
+
+	br := bufio.NewReaderSize(dec, sniffSize)
+	head, err := br.Peek(sniffSize)
+	switch err {
+	case nil, io.EOF:
+		err = nil
+	default:
+		return
+	}
+	if mime, _ := matchTar(head); mime != "" {
+		return l.listTar(br, false)
+	}
+
+	e := ArchiveEntry{
+		CompressedSize: size,
+		Mime:           sniffMIME(head),
+	}
+	if gz, ok := dec.(*gzip.Reader); ok {
+		e.Name = gz.Name
+		e.Modified = gz.ModTime
+	}
+
+	// The size of the content is only known after decompressing it
+	e.Size, err = io.Copy(ioutil.Discard,
+		io.LimitReader(br, l.opts.MaxTotalSize+1))
+	if err != nil {
+		return
+	}
+	l.add(e)
+	return
+}
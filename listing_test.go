@@ -0,0 +1,330 @@
+package thumbnailer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListArchive(t *testing.T) {
+	t.Parallel()
+
+	type entry struct {
+		name  string
+		size  int64
+		isDir bool
+		mime  string
+	}
+
+	var (
+		sample = entry{"sample.png", 634670, false, "image/png"}
+		readme = entry{"readme.txt", 13, false, ""}
+		pages  = [...]entry{
+			{"pages", 0, true, ""},
+			{"pages/01.png", 23730, false, "image/png"},
+			{"pages/02.png", 23730, false, "image/png"},
+			{"pages/03.png", 23730, false, "image/png"},
+		}
+	)
+
+	cases := [...]struct {
+		file string
+		// Compressed sizes and modification times are reported
+		compressedSizes, mtimes bool
+		entries                 []entry
+	}{
+		{"sample.zip", true, true, []entry{sample}},
+		{"sample.rar", true, true, []entry{sample}},
+		{"sample.7z", false, true, []entry{readme, sample}},
+		{
+			"sample.tar",
+			true,
+			true,
+			[]entry{readme, {"image.png", 23730, false, "image/png"}},
+		},
+		{"comic.tar", true, true, pages[:]},
+		{"comic.tar.gz", false, true, pages[:]},
+		{
+			"comic.7z",
+			false,
+			true,
+			[]entry{
+				{"01.png", 23730, false, "image/png"},
+				{"02.png", 23730, false, "image/png"},
+				{"03.png", 23730, false, "image/png"},
+			},
+		},
+		{
+			"nested.7z",
+			false,
+			true,
+			[]entry{
+				{"Title/01.png", 23730, false, "image/png"},
+				{"Title/02.png", 23730, false, "image/png"},
+				{"Title/empty.txt", 0, false, ""},
+				{"Title", 0, true, ""},
+			},
+		},
+		{
+			// Compressed without storing the name and modification time
+			"image.png.gz",
+			true,
+			false,
+			[]entry{{"", 23730, false, "image/png"}},
+		},
+		{
+			"image.png.xz",
+			true,
+			false,
+			[]entry{{"", 23730, false, "image/png"}},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			entries, err := ListArchive(f, ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(c.entries) {
+				t.Fatalf("expected %d entries, got %d: %+v", len(c.entries),
+					len(entries), entries)
+			}
+			for i, e := range entries {
+				std := c.entries[i]
+				res := entry{e.Name, e.Size, e.IsDir, e.Mime}
+				if res != std {
+					t.Fatalf("entry %d: expected %+v, got %+v", i, std, res)
+				}
+				if c.mtimes && e.Modified.IsZero() {
+					t.Fatalf("entry %d: no modification time", i)
+				}
+				if c.compressedSizes && !e.IsDir && e.CompressedSize == 0 {
+					t.Fatalf("entry %d: no compressed size", i)
+				}
+			}
+		})
+	}
+}
+
+func TestListGzipHeader(t *testing.T) {
+	t.Parallel()
+
+	mtime := time.Date(2019, 2, 1, 12, 30, 0, 0, time.UTC)
+
+	var w bytes.Buffer
+	gw := gzip.NewWriter(&w)
+	gw.Name = "image.png"
+	gw.ModTime = mtime
+	_, err := gw.Write(readSample(t, "too small.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListArchive(bytes.NewReader(w.Bytes()), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	e := entries[0]
+	if e.Name != "image.png" {
+		t.Fatalf("unexpected name: %s", e.Name)
+	}
+	if !e.Modified.Equal(mtime) {
+		t.Fatalf("unexpected modification time: %v", e.Modified)
+	}
+	if e.CompressedSize != int64(w.Len()) {
+		t.Fatalf("unexpected compressed size: %d", e.CompressedSize)
+	}
+}
+
+func TestListArchiveLimits(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name  string
+		opts  ListOptions
+		count int
+	}{
+		{"entry count", ListOptions{MaxEntries: 3}, 3},
+		{"total size", ListOptions{MaxTotalSize: 50000}, 3},
+		{"single entry too large", ListOptions{MaxTotalSize: 1}, 1},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, "comic.tar")
+			defer f.Close()
+
+			entries, err := ListArchive(f, c.opts)
+			if err != ErrArchiveTruncated {
+				t.Fatalf("expected %v, got %v", ErrArchiveTruncated, err)
+			}
+			if len(entries) != c.count {
+				t.Fatalf("expected %d entries, got %d", c.count, len(entries))
+			}
+		})
+	}
+
+	t.Run("compressed file", func(t *testing.T) {
+		t.Parallel()
+
+		f := openSample(t, "image.png.gz")
+		defer f.Close()
+
+		entries, err := ListArchive(f, ListOptions{MaxTotalSize: 1000})
+		if err != ErrArchiveTruncated {
+			t.Fatalf("expected %v, got %v", ErrArchiveTruncated, err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+	})
+}
+
+func TestListEncryptedEntries(t *testing.T) {
+	t.Parallel()
+
+	page := readSample(t, "too small.png")
+
+	var w bytes.Buffer
+	zw := zip.NewWriter(&w)
+	for _, h := range [...]zip.FileHeader{
+		{Name: "01.png"},
+		{Name: "02.png", Flags: 0x1},
+	} {
+		h := h
+		fw, err := zw.CreateHeader(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fw.Write(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [...]struct {
+		name    string
+		archive []byte
+	}{
+		{"zip", w.Bytes()},
+		{
+			"rar",
+			bytes.Join(
+				[][]byte{
+					[]byte(rar5Signature),
+					buildRar5Header(rar5Archive, 0, []byte{0}, nil, nil),
+					buildRar5File("01.png", 0, 0, 0, nil, string(page)),
+					buildRar5File("02.png", 0, 0, 0,
+						buildRar5Record(1, make([]byte, 8)), string(page)),
+					buildRar5Header(rar5End, 0, []byte{0}, nil, nil),
+				},
+				nil,
+			),
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries, err := ListArchive(bytes.NewReader(c.archive),
+				ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				t.Fatalf("unexpected entries: %+v", entries)
+			}
+			for i, e := range entries {
+				if e.Encrypted != (i == 1) {
+					t.Fatalf("entry %d: unexpected encryption: %v", i,
+						e.Encrypted)
+				}
+			}
+			if entries[1].Mime != "" {
+				t.Fatalf("encrypted entry sniffed: %s", entries[1].Mime)
+			}
+		})
+	}
+}
+
+func TestListArchiveErrors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not an archive", func(t *testing.T) {
+		t.Parallel()
+
+		f := openSample(t, "sample.png")
+		defer f.Close()
+
+		_, err := ListArchive(f, ListOptions{})
+		if err != ErrUnsupportedMIME("image/png") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("encrypted headers", func(t *testing.T) {
+		t.Parallel()
+
+		archive := append(
+			[]byte(rar5Signature),
+			buildRar5Header(rar5Encryption, 0, make([]byte, 18), nil, nil)...,
+		)
+		_, err := ListArchive(bytes.NewReader(archive), ListOptions{})
+		if err != ErrEncrypted {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("7z encrypted headers", func(t *testing.T) {
+		t.Parallel()
+
+		f := openSample(t, "encrypted_headers.7z")
+		defer f.Close()
+
+		_, err := ListArchive(f, ListOptions{})
+		if err != ErrEncrypted {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		f := openSample(t, "sample.tar")
+		defer f.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ListArchiveContext(ctx, f, ListOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -3,11 +3,46 @@
 package thumbnailer
 
 import (
+	"context"
 	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"time"
 )
 
+// MIME types processed directly with FFmpeg
+var mediaMIMEs = map[string]bool{
+	"image/jpeg":         true,
+	"image/png":          true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"image/bmp":          true,
+	"image/tiff":         true,
+	"image/x-icon":       true,
+	"image/x-tga":        true,
+	"image/jp2":          true,
+	"image/qoi":          true,
+	"image/vnd-ms.dds":   true,
+	"image/x-pcx":        true,
+	"image/sgi":          true,
+	"image/x-exr":        true,
+	"image/vnd.radiance": true,
+	"application/ogg":    true,
+	"video/webm":         true,
+	"video/x-matroska":   true,
+	"video/mp4":          true,
+	"video/avi":          true,
+	"video/quicktime":    true,
+	"video/x-ms-wmv":     true,
+	"video/x-flv":        true,
+	"audio/mpeg":         true,
+	"audio/aac":          true,
+	"audio/wave":         true,
+	"audio/x-flac":       true,
+	"audio/midi":         true,
+}
+
 // Source stores information about the source file
 type Source struct {
 	// Some containers may or may not have either
@@ -16,9 +51,13 @@ type Source struct {
 	// Length of the stream. Applies to audio and video files.
 	Length time.Duration
 
-	// Source dimensions, if file is image or video
+	// Source dimensions, if file is image or video. For documents, the
+	// dimensions of the thumbnailed embedded image.
 	Dims
 
+	// Number of pages, if file is a document or comic book archive
+	Pages int
+
 	// Mime type of the source file
 	Mime string
 
@@ -28,8 +67,44 @@ type Source struct {
 	// Codec of the source file when applicable
 	Codec string
 
+	// Name of the entry the thumbnail was generated from, if file is an
+	// archive
+	Entry string
+
 	// Optional metadata
 	Meta
+
+	// Metadata of document files, such as PDF. Also filled, if no thumbnail
+	// could be generated.
+	Document DocumentMeta
+
+	// Metadata of comic book archives
+	Comic ComicMeta
+
+	// Low-quality image placeholders generated from the thumbnail, if
+	// requested with Options.Placeholder
+	BlurHash  string
+	ThumbHash []byte
+
+	// Tiny PNG thumbnail as a base64 data URI
+	LQIP string
+
+	// Perceptual hashes of the thumbnailed frame for detecting resized or
+	// re-encoded duplicates. Compare with HammingDistance.
+	AHash, DHash, PHash uint64
+
+	// Dominant colours of the thumbnailed frame, if requested with
+	// Options.Palette, and its average colour
+	Palette      []PaletteColor
+	AverageColor color.RGBA
+
+	// Fixed size sample of the thumbnailed frame for image analysis, if
+	// provided by the processor
+	sample *image.RGBA
+
+	// Hashes and palette are already computed, as they are for embedded files
+	// from their full frame
+	analysed bool
 }
 
 // File metadata
@@ -37,11 +112,79 @@ type Meta struct {
 	Title, Artist string
 }
 
+// DocumentMeta stores document metadata. Title and Author are also stored in
+// Source.Meta and the page count in Source.Pages.
+type DocumentMeta struct {
+	Title, Author, Subject, Producer string
+
+	// Zero, if unknown
+	Created time.Time
+
+	// Document is encrypted. Text metadata is only available, if the document
+	// can be opened without a password.
+	Encrypted bool
+}
+
+// ComicMeta stores metadata of comic book archives from ComicInfo.xml, ACBF
+// or comic.txt files. Title and Writer are also stored in Source.Meta.
+type ComicMeta struct {
+	Series, Title, Writer string
+
+	// Issue or volume number within the series. Not necessarily an integer.
+	Number string
+
+	// Age rating, like "Teen" or "Mature 17+"
+	AgeRating string
+
+	// Comic is a manga. Pages of manga with RightToLeft set are read from
+	// right to left.
+	Manga, RightToLeft bool
+}
+
 // Dims store the dimensions of an image
 type Dims struct {
 	Width, Height uint
 }
 
+// Replace unset dimensions with the default thumbnail dimensions
+func (d Dims) orDefault() Dims {
+	if d.Width == 0 {
+		d.Width = 150
+	}
+	if d.Height == 0 {
+		d.Height = 150
+	}
+	return d
+}
+
+// Multiple thumbnails generated from the same file. Implements image.Image by
+// delegating to the first thumbnail, so it can be returned from a Processor.
+type thumbnailSet []image.Image
+
+func (t thumbnailSet) ColorModel() color.Model {
+	return t[0].ColorModel()
+}
+
+func (t thumbnailSet) Bounds() image.Rectangle {
+	return t[0].Bounds()
+}
+
+func (t thumbnailSet) At(x, y int) color.Color {
+	return t[0].At(x, y)
+}
+
+// Returns the first frame of the first thumbnail of a thumbnailSet or
+// Animation or img itself otherwise
+func firstFrame(img image.Image) image.Image {
+	if set, ok := img.(thumbnailSet); ok {
+		img = set[0]
+	}
+	if anim, ok := img.(*Animation); ok {
+		img = anim.Frames[0]
+	}
+	return img
+}
+
 // Options suplied to the Thumbnail function
 type Options struct {
 	// Maximum source image dimensions. Any image exceeding either will be
@@ -59,6 +202,29 @@ type Options struct {
 	// Defaults to 150x150, if unset.
 	ThumbDims Dims
 
+	// Algorithm used to scale thumbnails.
+	// Defaults to ScalePointBox.
+	ScaleFilter ScaleFilter
+
+	// How to fit thumbnails into ThumbDims.
+	// Defaults to FitContain.
+	Fit Fit
+
+	// Colour to pad thumbnails with, when using FitPad.
+	// Defaults to transparent.
+	Background color.Color
+
+	// Bounding boxes of multiple thumbnails to generate from the same frame.
+	// Retrieve the thumbnails with ProcessMulti. Entries follow the same rules
+	// as ThumbDims.
+	//
+	// If set, overrides ThumbDims with the first entry. Process only returns
+	// the thumbnail for the first entry.
+	//
+	// Animated thumbnails and thumbnails produced by processors registered
+	// with RegisterProcessor are only generated for the first entry.
+	ThumbSizes []Dims
+
 	// MIME types to accept for thumbnailing.
 	// If nil, all MIME types will be processed.
 	//
@@ -66,7 +232,77 @@ type Options struct {
 	// "application/x-cbz", "application/x-cbr", "application/x-cb7" and
 	// "application/x-cbt", you must accept the corresponding archive type
 	// such as "application/zip" or leave this nil.
+	//
+	// Compressed files, like "application/gzip", are thumbnailed by their
+	// decompressed content, which is not restricted by this list.
 	AcceptedMimeTypes map[string]bool
+
+	// Password to decrypt encrypted rar, 7z and zip archives with. Zip
+	// entries are only decrypted, if they use the traditional PKWARE
+	// encryption.
+	//
+	// Encrypted entries, that can not be decrypted, are skipped. If no other
+	// entry can be thumbnailed, ErrEncrypted is returned.
+	ArchivePassword string
+
+	// Position in video files to generate the thumbnail from. Useful to skip
+	// intros, title cards and fades. Thumbnailing starts from the nearest
+	// keyframe preceding the position.
+	//
+	// Ignored for media with unknown length. If seeking fails, the thumbnail
+	// is generated from the start of the stream.
+	SeekTo time.Duration
+
+	// Like SeekTo, but specified as a percentage from 0 to 100 of the media
+	// length. Only used, if SeekTo is not set.
+	SeekPercent float64
+
+	// Generate an animated thumbnail for media with multiple frames, such as
+	// GIF and video files. The returned thumbnail is then an *Animation, if
+	// more than one frame could be decoded.
+	Animated bool
+
+	// Limits for animated thumbnail generation. Only used, if Animated is set.
+	Animation AnimationOptions
+
+	// Visualisation to generate for audio files without cover art.
+	// Visualisations fill ThumbDims exactly and use Background as the
+	// background colour.
+	//
+	// Defaults to AudioVisualizationNone.
+	AudioVisualization AudioVisualization
+
+	// Format to encode thumbnails to with Encode.
+	// Defaults to FormatAuto.
+	OutputFormat OutputFormat
+
+	// Lossy encoding quality from 1 to 100 used by Encode.
+	// Defaults to DefaultQuality.
+	Quality int
+
+	// Compression level for PNG thumbnails encoded with Encode
+	PNGCompression png.CompressionLevel
+
+	// Maximum amount of dominant colours to extract from the thumbnailed
+	// frame into Source.Palette. Disabled, if 0.
+	Palette int
+
+	// Placeholders to generate from the thumbnail and store in Source.
+	// For multiple thumbnails and animations the first frame of the first
+	// thumbnail is used.
+	Placeholder Placeholder
+
+	// Set by ProcessContext
+	ctx context.Context
+}
+
+// Context returns the context processing is bound to. Processors should abort
+// and return the context's error, once it is done.
+func (o Options) Context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
 }
 
 // Process generates a thumbnail from a file of unknown type and performs some
@@ -74,11 +310,68 @@ type Options struct {
 func Process(rs io.ReadSeeker, opts Options) (
 	src Source, thumb image.Image, err error,
 ) {
-	if opts.ThumbDims.Width == 0 {
-		opts.ThumbDims.Width = 150
+	return ProcessContext(context.Background(), rs, opts)
+}
+
+// ProcessContext is like Process, but aborts processing, including any FFmpeg
+// demuxing and decoding in progress, once ctx is done.
+// The returned error then is or wraps ctx.Err().
+func ProcessContext(ctx context.Context, rs io.ReadSeeker, opts Options) (
+	src Source, thumb image.Image, err error,
+) {
+	src, thumb, err = process(ctx, rs, opts)
+	if set, ok := thumb.(thumbnailSet); ok {
+		thumb = set[0]
+	}
+	return
+}
+
+// ProcessMulti is like Process, but returns one thumbnail for each of
+// opts.ThumbSizes in the same order. The file is only decoded once.
+func ProcessMulti(rs io.ReadSeeker, opts Options) (
+	src Source, thumbs []image.Image, err error,
+) {
+	return ProcessMultiContext(context.Background(), rs, opts)
+}
+
+// ProcessMultiContext is like ProcessMulti, but aborts processing, once ctx is
+// done. The returned error then is or wraps ctx.Err().
+func ProcessMultiContext(ctx context.Context, rs io.ReadSeeker, opts Options,
+) (
+	src Source, thumbs []image.Image, err error,
+) {
+	src, thumb, err := process(ctx, rs, opts)
+	switch t := thumb.(type) {
+	case nil:
+	case thumbnailSet:
+		thumbs = t
+	default:
+		thumbs = []image.Image{t}
+	}
+	return
+}
+
+// Shared implementation of ProcessContext and ProcessMultiContext.
+// The returned thumbnail is a thumbnailSet, if opts.ThumbSizes contains
+// multiple entries.
+func process(ctx context.Context, rs io.ReadSeeker, opts Options) (
+	src Source, thumb image.Image, err error,
+) {
+	err = ctx.Err()
+	if err != nil {
+		return
 	}
-	if opts.ThumbDims.Height == 0 {
-		opts.ThumbDims.Height = 150
+	opts.ctx = ctx
+
+	if len(opts.ThumbSizes) != 0 {
+		sizes := make([]Dims, len(opts.ThumbSizes))
+		for i, d := range opts.ThumbSizes {
+			sizes[i] = d.orDefault()
+		}
+		opts.ThumbSizes = sizes
+		opts.ThumbDims = sizes[0]
+	} else {
+		opts.ThumbDims = opts.ThumbDims.orDefault()
 	}
 
 	src.Mime, src.Extension, err = DetectMIME(rs, opts.AcceptedMimeTypes)
@@ -90,53 +383,107 @@ func Process(rs io.ReadSeeker, opts Options) (
 		return
 	}
 
-	// TODO: PDF Processing
-	// TODO: SVG processing
+	fn := processorFor(src.Mime)
+	if fn == nil {
+		err = ErrUnsupportedMIME(src.Mime)
+		return
+	}
 
-	var fn Processor
+	thumb, err = fn(rs, &src, opts)
+	if err == nil && thumb != nil {
+		if !src.analysed {
+			// Analyse the full frame, if available, and not the fitted
+			// thumbnail
+			var sample image.Image = src.sample
+			if src.sample == nil {
+				sample = firstFrame(thumb)
+			}
+			src.AHash, src.DHash, src.PHash = perceptualHashes(sample)
+			if opts.Palette > 0 {
+				src.Palette, src.AverageColor = extractPalette(sample,
+					opts.Palette)
+			}
+			src.analysed = true
+		}
+		src.sample = nil
 
-	override := overrideProcessors[src.Mime]
-	if override != nil {
-		fn = override
-	} else {
-		switch src.Mime {
-		case
-			"image/jpeg",
-			"image/png",
-			"image/gif",
-			"image/webp",
-			"application/ogg",
-			"video/webm",
-			"video/x-matroska",
-			"video/mp4",
-			"video/avi",
-			"video/quicktime",
-			"video/x-ms-wmv",
-			"video/x-flv",
-			"audio/mpeg",
-			"audio/aac",
-			"audio/wave",
-			"audio/x-flac",
-			"audio/midi":
-			fn = processMedia
-		case mimeZip:
-			fn = processZip
-		case mimeRar:
-			fn = processRar
-		default:
-			err = ErrUnsupportedMIME(src.Mime)
-			return
+		if opts.Placeholder != 0 {
+			err = generatePlaceholders(&src, thumb, opts.Placeholder)
 		}
 	}
-
-	thumb, err = fn(rs, &src, opts)
 	switch src.Mime {
 	case "image/jpeg",
 		"image/png",
 		"image/gif",
-		"image/webp":
+		"image/webp",
+		"image/bmp",
+		"image/tiff",
+		"image/x-icon",
+		"image/x-tga",
+		"image/jp2",
+		"image/qoi",
+		"image/vnd-ms.dds",
+		"image/x-pcx",
+		"image/sgi",
+		"image/x-exr",
+		"image/vnd.radiance":
 		// FFmpeg considers images to be video for processing reasons
 		src.HasVideo = false
 	}
 	return
 }
+
+// Process a file embedded in or extracted from the processed file, like cover
+// art or an archive entry. Any processable MIME type is accepted.
+//
+// The hashes and palette of the embedded file are copied to src, so they
+// describe the same frame as for the embedded file processed on its own
+// rather than the fitted thumbnail.
+func processEmbedded(ctx context.Context, r io.ReadSeeker, src *Source,
+	opts Options,
+) (
+	inner Source, thumb image.Image, err error,
+) {
+	opts.AcceptedMimeTypes = nil
+	// Placeholders are generated from the returned thumbnail by the caller
+	opts.Placeholder = 0
+
+	inner, thumb, err = process(ctx, r, opts)
+	if err == nil && inner.analysed {
+		src.AHash, src.DHash, src.PHash = inner.AHash, inner.DHash,
+			inner.PHash
+		src.Palette, src.AverageColor = inner.Palette, inner.AverageColor
+		src.analysed = true
+	}
+	return
+}
+
+// Returns the processor of a MIME type or nil, if the MIME type is not
+// supported
+func processorFor(mime string) Processor {
+	if fn := overrideProcessors[mime]; fn != nil {
+		return fn
+	}
+	switch {
+	case mediaMIMEs[mime]:
+		return processMedia
+	case mime == mimeZip:
+		return processZip
+	case mime == mimeRar:
+		return processRar
+	case mime == mime7Zip:
+		return process7z
+	case mime == mimeTar:
+		return processTar
+	case compressedMIMEs[mime]:
+		return processCompressed
+	case mime == mimePDF:
+		return processPDF
+	case mime == mimeSVG:
+		return processSVG
+	case mime == mimePSD:
+		return processPSD
+	default:
+		return nil
+	}
+}
@@ -1,9 +1,12 @@
 package thumbnailer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -48,6 +51,8 @@ var samples = [...]string{
 	"invalid_data.jpg", // Check handling images with some invalid data
 	"sample.zip",
 	"sample.rar",
+	"sample.7z",
+	"sample.tar",
 	"too small.png",
 	"exact_thumb_size.jpg",
 	"meta_segfault.mp4",
@@ -61,12 +66,42 @@ var samples = [...]string{
 	"jannu_90.jpg",
 	"jannu_90_h_mirrored.jpg",
 	"jannu_270.jpg",
+
+	// Images decoded by FFmpeg
+	"sample.bmp",
+	"sample.tiff",
+	"sample.ico",
+	"sample.tga",
+	"sample.qoi",
+	"sample.dds",
+	"sample.pcx",
+	"sample.sgi",
+	"sample.exr",
+	"sample.hdr",
+
+	"sample.psd",
 }
 
 var ignore = map[string]bool{
 	"invalid_data.jpg": true,
 	"sample.zip":       true,
 	"sample.rar":       true,
+	"sample.7z":        true,
+	"sample.tar":       true,
+
+	// Generated images not matching the dimensions of the other samples.
+	// TGA and PCX can not be opened by NewFFContext.
+	"sample.bmp":  true,
+	"sample.tiff": true,
+	"sample.ico":  true,
+	"sample.tga":  true,
+	"sample.qoi":  true,
+	"sample.dds":  true,
+	"sample.pcx":  true,
+	"sample.sgi":  true,
+	"sample.exr":  true,
+	"sample.hdr":  true,
+	"sample.psd":  true,
 }
 
 func TestProcess(t *testing.T) {
@@ -112,6 +147,16 @@ func openSample(t *testing.T, name string) *os.File {
 	return f
 }
 
+func readSample(t *testing.T, name string) []byte {
+	t.Helper()
+
+	buf, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
 func writeSample(t *testing.T, name string, img image.Image) {
 	t.Helper()
 
@@ -187,3 +232,62 @@ func TestUnprocessedLine(t *testing.T) {
 	name := fmt.Sprintf(`%s_to_300x300_thumb.png`, sample)
 	writeSample(t, name, thumb)
 }
+
+// Cancels the context on the first read
+type cancelingReadSeeker struct {
+	*os.File
+	cancel context.CancelFunc
+}
+
+func (c cancelingReadSeeker) Read(p []byte) (int, error) {
+	c.cancel()
+	return c.File.Read(p)
+}
+
+func TestProcessContext(t *testing.T) {
+	t.Parallel()
+
+	for _, sample := range [...]string{
+		"sample.jpg",
+		"no_sound.mkv",
+		"with_cover.mp3",
+		"sample.zip",
+		"sample.rar",
+		"sample.7z",
+		"sample.tar",
+		"image.png.gz",
+	} {
+		sample := sample
+		t.Run(sample, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("canceled", func(t *testing.T) {
+				f := openSample(t, sample)
+				defer f.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, _, err := ProcessContext(ctx, f, Options{})
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			t.Run("canceled while processing", func(t *testing.T) {
+				f := openSample(t, sample)
+				defer f.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				_, _, err := ProcessContext(
+					ctx,
+					cancelingReadSeeker{f, cancel},
+					Options{},
+				)
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+		})
+	}
+}
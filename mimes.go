@@ -56,7 +56,7 @@ var matchers = []Matcher{
 		[]byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x00\xFF\xFF\xFF\xFF"),
 		[]byte("RIFF\x00\x00\x00\x00AVI "),
 	},
-	&exactSig{"psd", "image/photoshop", []byte("8BPS")},
+	&exactSig{"psd", mimePSD, []byte("8BPS")},
 	&exactSig{"flac", "audio/x-flac", []byte("fLaC")},
 	&exactSig{"tiff", "image/tiff", []byte("II*\x00")},
 	&exactSig{"tiff", "image/tiff", []byte("MM\x00*")},
@@ -75,12 +75,33 @@ var matchers = []Matcher{
 		[]byte("MThd\x00\x00\x00\x06"),
 	},
 	&exactSig{"zip", mimeZip, []byte("\x50\x4B\x03\x04")},
-	&exactSig{"rar", mimeRar, []byte("\x52\x61\x72\x20\x1A\x07\x00")},
+	// First volume of a split zip archive
+	&exactSig{"zip", mimeZip, []byte("\x50\x4B\x07\x08")},
+	&exactSig{"rar", mimeRar, []byte("\x52\x61\x72\x21\x1A\x07\x00")},
 
 	// RAR v5 archive
 	&exactSig{"rar", mimeRar, []byte("\x52\x61\x72\x21\x1A\x07\x01\x00")},
 
 	&exactSig{"7z", mime7Zip, []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}},
+	MatcherFunc(matchTar),
+	MatcherFunc(matchCompressed),
+	&exactSig{"jp2", "image/jp2", []byte("\x00\x00\x00\x0CjP  \r\n\x87\n")},
+
+	// JPEG 2000 codestream
+	&exactSig{"j2k", "image/jp2", []byte("\xFF\x4F\xFF\x51")},
+
+	&exactSig{"qoi", "image/qoi", []byte("qoif")},
+	&exactSig{"dds", "image/vnd-ms.dds", []byte("DDS ")},
+	&exactSig{"exr", "image/x-exr", []byte("\x76\x2F\x31\x01")},
+	&exactSig{"hdr", "image/vnd.radiance", []byte("#?RADIANCE\n")},
+	MatcherFunc(matchSGI),
+
+	// Formats without a magic number are only matched by header heuristics
+	MatcherFunc(matchPCX),
+	MatcherFunc(matchTGA),
+
+	// Text-based formats last
+	MatcherFunc(matchSVG),
 }
 
 var (
@@ -177,6 +198,158 @@ func matchMP4(data []byte) (string, string) {
 	return "", ""
 }
 
+// Match an XML document with an <svg> root element. The root element may be
+// preceded by an XML declaration, processing instructions, comments and a
+// doctype declaration.
+func matchSVG(data []byte) (string, string) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	for {
+		data = bytes.TrimLeft(data, " \t\r\n")
+
+		var end int
+		switch {
+		case bytes.HasPrefix(data, []byte("<svg")):
+			if len(data) > 4 {
+				switch data[4] {
+				case ' ', '\t', '\r', '\n', '>', '/':
+					return mimeSVG, "svg"
+				}
+			}
+			return "", ""
+		case bytes.HasPrefix(data, []byte("<?")):
+			end = indexEnd(data, "?>")
+		case bytes.HasPrefix(data, []byte("<!--")):
+			end = indexEnd(data, "-->")
+		case bytes.HasPrefix(data, []byte("<!")):
+			// Doctype declaration with an optional internal subset
+			inSubset := false
+		loop:
+			for i, b := range data {
+				switch {
+				case b == '[':
+					inSubset = true
+				case b == ']':
+					inSubset = false
+				case b == '>' && !inSubset:
+					end = i + 1
+					break loop
+				}
+			}
+		}
+		if end <= 0 {
+			return "", ""
+		}
+		data = data[end:]
+	}
+}
+
+// Return the index after the first occurrence of sep in data or -1, if none
+func indexEnd(data []byte, sep string) int {
+	i := bytes.Index(data, []byte(sep))
+	if i == -1 {
+		return -1
+	}
+	return i + len(sep)
+}
+
+// Match a POSIX or GNU tar archive by the magic number of the first header
+func matchTar(data []byte) (string, string) {
+	if len(data) < 512 || !bytes.Equal(data[257:262], []byte("ustar")) {
+		return "", ""
+	}
+	return mimeTar, "tar"
+}
+
+// Match an SGI image by its magic number and the valid ranges of the
+// following header fields
+func matchSGI(data []byte) (string, string) {
+	if len(data) < 512 || !bytes.HasPrefix(data, []byte("\x01\xDA")) {
+		return "", ""
+	}
+	storage, bpc := data[2], data[3]
+	dimension := binary.BigEndian.Uint16(data[4:])
+	if storage > 1 || (bpc != 1 && bpc != 2) || dimension < 1 ||
+		dimension > 3 {
+		return "", ""
+	}
+	return "image/sgi", "sgi"
+}
+
+// Match a PCX image by the valid ranges of its header fields
+func matchPCX(data []byte) (string, string) {
+	if len(data) < 128 || data[0] != 0x0A {
+		return "", ""
+	}
+	switch data[1] {
+	case 0, 2, 3, 4, 5: // Version
+	default:
+		return "", ""
+	}
+	switch data[3] {
+	case 1, 2, 4, 8: // Bits per plane
+	default:
+		return "", ""
+	}
+	le := binary.LittleEndian
+	xMin, yMin := le.Uint16(data[4:]), le.Uint16(data[6:])
+	xMax, yMax := le.Uint16(data[8:]), le.Uint16(data[10:])
+	planes := data[65]
+	if data[2] > 1 || xMax < xMin || yMax < yMin || data[64] != 0 ||
+		planes == 0 || planes > 4 {
+		return "", ""
+	}
+	return "image/x-pcx", "pcx"
+}
+
+// Match a TGA image by the valid ranges of its header fields. TGA has no
+// magic number at the start of the file, so only images with consistent
+// colour map specifications and standard pixel depths are matched.
+func matchTGA(data []byte) (string, string) {
+	if len(data) < 18 {
+		return "", ""
+	}
+	le := binary.LittleEndian
+	colorMapType, imageType := data[1], data[2]
+	width, height := le.Uint16(data[12:]), le.Uint16(data[14:])
+	depth, descriptor := data[16], data[17]
+
+	switch imageType {
+	case 1, 9: // Colour-mapped
+		if colorMapType != 1 || depth != 8 {
+			return "", ""
+		}
+		switch data[7] { // Colour map entry size
+		case 15, 16, 24, 32:
+		default:
+			return "", ""
+		}
+	case 2, 10: // True-colour
+		if colorMapType != 0 {
+			return "", ""
+		}
+		switch depth {
+		case 15, 16, 24, 32:
+		default:
+			return "", ""
+		}
+	case 3, 11: // Greyscale
+		if colorMapType != 0 || (depth != 8 && depth != 16) {
+			return "", ""
+		}
+	default:
+		return "", ""
+	}
+	if colorMapType == 0 && !bytes.Equal(data[3:8], make([]byte, 5)) {
+		return "", ""
+	}
+	// Reserved descriptor bits and attribute bits exceeding the pixel depth
+	if width == 0 || height == 0 || descriptor&0xC0 != 0 ||
+		descriptor&0x0F > depth {
+		return "", ""
+	}
+	return "image/x-tga", "tga"
+}
+
 // MP3 is a retarded standard, that will not always even have a magic number.
 // Need to detect with FFMPEG as a last resort.
 func matchMP3(data []byte) (mime string, ext string) {
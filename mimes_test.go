@@ -0,0 +1,54 @@
+package thumbnailer
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMatchHeuristics(t *testing.T) {
+	t.Parallel()
+
+	tga, err := ioutil.ReadFile("testdata/sample.tga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcx, err := ioutil.ReadFile("testdata/sample.pcx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(buf []byte, i int, b byte) []byte {
+		buf = append([]byte(nil), buf...)
+		buf[i] = b
+		return buf
+	}
+
+	cases := [...]struct {
+		name string
+		fn   func([]byte) (string, string)
+		data []byte
+		mime string
+	}{
+		{"TGA", matchTGA, tga, "image/x-tga"},
+		{"TGA invalid image type", matchTGA, corrupt(tga, 2, 4), ""},
+		{"TGA invalid pixel depth", matchTGA, corrupt(tga, 16, 12), ""},
+		{"TGA zero width", matchTGA, corrupt(tga, 12, 0), ""},
+		{"TGA unexpected colour map", matchTGA, corrupt(tga, 1, 1), ""},
+		{"TGA text", matchTGA, []byte("Lorem ipsum dolor sit amet"), ""},
+		{"PCX", matchPCX, pcx, "image/x-pcx"},
+		{"PCX invalid version", matchPCX, corrupt(pcx, 1, 1), ""},
+		{"PCX invalid encoding", matchPCX, corrupt(pcx, 2, 2), ""},
+		{"PCX no planes", matchPCX, corrupt(pcx, 65, 0), ""},
+		{"PCX truncated", matchPCX, pcx[:64], ""},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			if mime, _ := c.fn(c.data); mime != c.mime {
+				t.Fatalf("expected %q, got %q", c.mime, mime)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package thumbnailer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// PaletteColor is a dominant colour of an image
+type PaletteColor struct {
+	color.RGBA
+
+	// Fraction of the visible image area covered by this colour
+	Weight float64
+}
+
+// Extract up to n dominant colours of img by median cut quantisation sorted by
+// weight in descending order and the average colour. Transparent pixels are
+// ignored.
+func extractPalette(img image.Image, n int,
+) (palette []PaletteColor, avg color.RGBA) {
+	// Straight alpha colours of all visible pixels
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	pixels := make([][3]uint8, 0, b.Dx()*b.Dy())
+	var sum [3]int
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i+3] < 0x80 {
+			continue
+		}
+		var p [3]uint8
+		copy(p[:], nrgba.Pix[i:i+3])
+		pixels = append(pixels, p)
+		for j, c := range p {
+			sum[j] += int(c)
+		}
+	}
+	if len(pixels) == 0 {
+		return
+	}
+	avg = color.RGBA{
+		R: uint8(sum[0] / len(pixels)),
+		G: uint8(sum[1] / len(pixels)),
+		B: uint8(sum[2] / len(pixels)),
+		A: 0xff,
+	}
+
+	boxes := []colorBox{newColorBox(pixels)}
+	for len(boxes) < n {
+		// Split the box with the largest count-weighted colour range
+		var (
+			best  = -1
+			score int
+		)
+		for i, b := range boxes {
+			_, r := b.widestChannel()
+			if s := r * len(b.pixels); r > 0 && s > score {
+				best, score = i, s
+			}
+		}
+		if best == -1 {
+			// All boxes consist of a single colour
+			break
+		}
+		a, b := boxes[best].split()
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+
+	palette = make([]PaletteColor, len(boxes))
+	for i, b := range boxes {
+		palette[i] = PaletteColor{
+			RGBA:   b.mean(),
+			Weight: float64(len(b.pixels)) / float64(len(pixels)),
+		}
+	}
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].Weight > palette[j].Weight
+	})
+	return
+}
+
+// Set of pixels in RGB space
+type colorBox struct {
+	pixels   [][3]uint8
+	min, max [3]uint8
+}
+
+func newColorBox(pixels [][3]uint8) colorBox {
+	b := colorBox{
+		pixels: pixels,
+		min:    pixels[0],
+		max:    pixels[0],
+	}
+	for _, p := range pixels[1:] {
+		for i, c := range p {
+			if c < b.min[i] {
+				b.min[i] = c
+			}
+			if c > b.max[i] {
+				b.max[i] = c
+			}
+		}
+	}
+	return b
+}
+
+// Returns the channel with the widest value range and the range
+func (b colorBox) widestChannel() (ch int, r int) {
+	for i := range b.min {
+		if d := int(b.max[i]) - int(b.min[i]); d > r {
+			ch, r = i, d
+		}
+	}
+	return
+}
+
+// Split box at the median of its widest channel
+func (b colorBox) split() (colorBox, colorBox) {
+	ch, _ := b.widestChannel()
+	sort.Slice(b.pixels, func(i, j int) bool {
+		return b.pixels[i][ch] < b.pixels[j][ch]
+	})
+
+	// Do not separate pixels of equal value
+	mid := len(b.pixels) / 2
+	for mid > 1 && b.pixels[mid][ch] == b.pixels[mid-1][ch] {
+		mid--
+	}
+	if b.pixels[mid][ch] == b.pixels[mid-1][ch] {
+		mid = len(b.pixels) / 2
+		for mid < len(b.pixels)-1 &&
+			b.pixels[mid][ch] == b.pixels[mid-1][ch] {
+			mid++
+		}
+	}
+
+	return newColorBox(b.pixels[:mid]), newColorBox(b.pixels[mid:])
+}
+
+// Average colour of the box
+func (b colorBox) mean() color.RGBA {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for i, c := range p {
+			sum[i] += int(c)
+		}
+	}
+	n := len(b.pixels)
+	return color.RGBA{
+		R: uint8(sum[0] / n),
+		G: uint8(sum[1] / n),
+		B: uint8(sum[2] / n),
+		A: 0xff,
+	}
+}
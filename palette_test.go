@@ -0,0 +1,80 @@
+package thumbnailer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestExtractPalette(t *testing.T) {
+	t.Parallel()
+
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+
+	// 3/4 red, 1/4 blue and a transparent row, that must be ignored
+	img := image.NewRGBA(image.Rect(0, 0, 16, 17))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := red
+			if x >= 12 {
+				c = blue
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	palette, avg := extractPalette(img, 4)
+	if len(palette) != 2 {
+		t.Fatalf("expected 2 colours, got %d", len(palette))
+	}
+	for i, c := range [...]PaletteColor{
+		{red, 0.75},
+		{blue, 0.25},
+	} {
+		if palette[i] != c {
+			t.Errorf("colour %d: expected %v, got %v", i, c, palette[i])
+		}
+	}
+	std := color.RGBA{0xbf, 0, 0x3f, 0xff}
+	if avg != std {
+		t.Errorf("average: expected %v, got %v", std, avg)
+	}
+
+	palette, _ = extractPalette(image.NewRGBA(image.Rect(0, 0, 4, 4)), 4)
+	if len(palette) != 0 {
+		t.Errorf("transparent image has palette: %v", palette)
+	}
+}
+
+func TestProcessPalette(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "sample.jpg")
+	defer f.Close()
+
+	src, _, err := Process(f, Options{
+		Palette: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(src.Palette); l == 0 || l > 5 {
+		t.Fatalf("invalid palette size: %d", l)
+	}
+	var sum float64
+	for i, c := range src.Palette {
+		if i != 0 && c.Weight > src.Palette[i-1].Weight {
+			t.Fatal("palette not sorted by weight")
+		}
+		sum += c.Weight
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("weights do not add up to 1: %f", sum)
+	}
+	if src.AverageColor.A != 0xff {
+		t.Fatal("average colour not set")
+	}
+}
@@ -0,0 +1,423 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+const mimePDF = "application/pdf"
+
+const (
+	// Maximum nesting of form XObjects searched for images
+	maxPDFFormDepth = 3
+
+	// Raw images with more pixels are not decoded, as they are expanded to 4
+	// bytes per pixel regardless of their bit depth
+	maxPDFImagePixels = 1 << 26
+)
+
+// Image XObject on a PDF page
+type pdfImage struct {
+	*pdfStream
+	width, height int64
+}
+
+// Thumbnail the largest embedded image of the first page of a PDF file and
+// extract its metadata
+func processPDF(rs io.ReadSeeker, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	ra, size, cleanup, err := readerAt(rs, opts)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	d, err := openPDF(opts.Context(), ra, size)
+	if err != nil {
+		return
+	}
+	src.Pages = d.pageCount()
+	src.Document = d.metadata()
+	src.Title = src.Document.Title
+	src.Artist = src.Document.Author
+	if d.locked {
+		err = ErrCantThumbnail
+		return
+	}
+
+	page := d.page(0)
+	if page == nil {
+		err = ErrCantThumbnail
+		return
+	}
+	res, _ := d.resolve(page["Resources"]).(pdfDict)
+	images := d.pageImages(res, 0, make(map[int64]bool))
+	if len(images) == 0 {
+		err = ErrCantThumbnail
+		return
+	}
+
+	img := images[0]
+	if max := opts.MaxSourceDims.Width; max != 0 && img.width > int64(max) {
+		err = ErrTooWide
+		return
+	}
+	if max := opts.MaxSourceDims.Height; max != 0 && img.height > int64(max) {
+		err = ErrTooTall
+		return
+	}
+	src.Width = uint(img.width)
+	src.Height = uint(img.height)
+
+	err = opts.Context().Err()
+	if err != nil {
+		return
+	}
+	buf, err := d.encodeImage(img)
+	if err != nil {
+		return
+	}
+
+	_, thumb, err = processEmbedded(opts.Context(), bytes.NewReader(buf), src,
+		opts)
+	return
+}
+
+// Return the number of pages in the document
+func (d *pdfDoc) pageCount() int {
+	root, _ := d.resolve(d.trailer["Root"]).(pdfDict)
+	pages, _ := d.resolve(root["Pages"]).(pdfDict)
+	n, _ := d.resolve(pages["Count"]).(int64)
+	if n < 0 {
+		n = 0
+	}
+	return int(n)
+}
+
+// Return the page dictionary of page n with any inherited attributes merged
+// in or nil, if not found
+func (d *pdfDoc) page(n int) pdfDict {
+	root, _ := d.resolve(d.trailer["Root"]).(pdfDict)
+	seen := make(map[pdfRef]bool)
+	var (
+		i    int
+		walk func(obj interface{}, inherited pdfDict, depth int) pdfDict
+	)
+	walk = func(obj interface{}, inherited pdfDict, depth int) pdfDict {
+		if depth > maxPDFDepth {
+			return nil
+		}
+		if ref, ok := obj.(pdfRef); ok {
+			if seen[ref] {
+				return nil
+			}
+			seen[ref] = true
+		}
+		node, ok := d.resolve(obj).(pdfDict)
+		if !ok {
+			return nil
+		}
+
+		attrs := make(pdfDict, len(inherited))
+		for k, v := range inherited {
+			attrs[k] = v
+		}
+		for _, k := range [...]pdfName{
+			"Resources", "MediaBox", "CropBox", "Rotate",
+		} {
+			if v, ok := node[k]; ok {
+				attrs[k] = v
+			}
+		}
+
+		kids, ok := d.resolve(node["Kids"]).(pdfArray)
+		if !ok || node["Type"] == pdfName("Page") {
+			if i == n {
+				for k, v := range node {
+					if _, ok := attrs[k]; !ok {
+						attrs[k] = v
+					}
+				}
+				return attrs
+			}
+			i++
+			return nil
+		}
+		for _, k := range kids {
+			if p := walk(k, attrs, depth+1); p != nil {
+				return p
+			}
+		}
+		return nil
+	}
+	return walk(root["Pages"], nil, 0)
+}
+
+// Find all images with supported encodings in the resources of a page or form
+// XObject sorted by descending area
+func (d *pdfDoc) pageImages(res pdfDict, depth int, seen map[int64]bool,
+) (images []pdfImage) {
+	xobjects, _ := d.resolve(res["XObject"]).(pdfDict)
+	for _, v := range xobjects {
+		ref, ok := v.(pdfRef)
+		if !ok || seen[ref.num] {
+			continue
+		}
+		seen[ref.num] = true
+		s, ok := d.resolve(ref).(*pdfStream)
+		if !ok {
+			continue
+		}
+
+		switch s.dict["Subtype"] {
+		case pdfName("Image"):
+			if d.resolve(s.dict["ImageMask"]) == true || !d.canDecode(s) {
+				continue
+			}
+			w, _ := d.resolve(s.dict["Width"]).(int64)
+			h, _ := d.resolve(s.dict["Height"]).(int64)
+			if w > 0 && h > 0 {
+				images = append(images, pdfImage{s, w, h})
+			}
+		case pdfName("Form"):
+			if depth < maxPDFFormDepth {
+				formRes, ok := d.resolve(s.dict["Resources"]).(pdfDict)
+				if !ok {
+					formRes = res
+				}
+				images = append(images,
+					d.pageImages(formRes, depth+1, seen)...)
+			}
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if a.width*a.height != b.width*b.height {
+			return a.width*a.height > b.width*b.height
+		}
+		return a.ref.num < b.ref.num
+	})
+	return
+}
+
+// Returns the last filter of a stream, which is the image compression filter
+// for images
+func (d *pdfDoc) imageFilter(s *pdfStream) pdfName {
+	var last interface{}
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		last = f
+	case pdfArray:
+		if len(f) != 0 {
+			last = d.resolve(f[len(f)-1])
+		}
+	}
+	name, _ := last.(pdfName)
+	return name
+}
+
+// Returns, if the image stream has a supported encoding and colour space
+func (d *pdfDoc) canDecode(s *pdfStream) bool {
+	switch f := d.imageFilter(s); {
+	case f == "DCTDecode":
+		return true
+	case pdfImageFilters[f]:
+		return false
+	}
+	switch bpc, _ := d.resolve(s.dict["BitsPerComponent"]).(int64); bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return false
+	}
+	_, err := d.colorSpace(s.dict["ColorSpace"], 0)
+	return err == nil
+}
+
+// Encode image to a format processable by FFmpeg
+func (d *pdfDoc) encodeImage(img pdfImage) (buf []byte, err error) {
+	if d.imageFilter(img.pdfStream) == "DCTDecode" {
+		// JPEG can be processed directly
+		buf, _, err = d.decodeStream(img.pdfStream, maxPDFStreamSize, true)
+		return
+	}
+
+	bpc, _ := d.resolve(img.dict["BitsPerComponent"]).(int64)
+	cs, err := d.colorSpace(img.dict["ColorSpace"], 0)
+	if err != nil {
+		return
+	}
+
+	if img.width > maxPDFImagePixels/img.height {
+		return nil, errPDFStreamSize
+	}
+
+	// Only allow for the expected size of raw image data
+	rowLen := (img.width*int64(cs.comps)*bpc + 7) / 8
+	if rowLen*img.height > maxPDFStreamSize {
+		return nil, errPDFStreamSize
+	}
+	data, filter, err := d.decodeStream(img.pdfStream, rowLen*img.height,
+		true)
+	if err != nil {
+		return
+	}
+	if filter != "" {
+		return nil, errPDFUnsupported
+	}
+
+	decode, _ := d.resolve(img.dict["Decode"]).(pdfArray)
+	return encodeIntermediate(
+		cs.decode(data, int(img.width), int(img.height), int(bpc), decode),
+	)
+}
+
+// Colour space of raw image data
+type pdfColorSpace struct {
+	// Components per pixel. 1 for indexed colour spaces.
+	comps int
+
+	// Base colour space and palette of indexed colour spaces
+	base    *pdfColorSpace
+	palette []byte
+}
+
+// Resolve a colour space definition to one of the supported colour spaces
+func (d *pdfDoc) colorSpace(obj interface{}, depth int,
+) (cs *pdfColorSpace, err error) {
+	if depth > 2 {
+		return nil, errPDFDepth
+	}
+	var (
+		name pdfName
+		arr  pdfArray
+	)
+	switch o := d.resolve(obj).(type) {
+	case pdfName:
+		name = o
+	case pdfArray:
+		if len(o) != 0 {
+			arr = o
+			name, _ = d.resolve(o[0]).(pdfName)
+		}
+	}
+
+	switch name {
+	case "DeviceGray", "CalGray", "G":
+		return &pdfColorSpace{comps: 1}, nil
+	case "DeviceRGB", "CalRGB", "RGB":
+		return &pdfColorSpace{comps: 3}, nil
+	case "DeviceCMYK", "CMYK":
+		return &pdfColorSpace{comps: 4}, nil
+	case "ICCBased":
+		// Treat as the device colour space with the same component count
+		if len(arr) >= 2 {
+			if s, ok := d.resolve(arr[1]).(*pdfStream); ok {
+				switch n, _ := d.resolve(s.dict["N"]).(int64); n {
+				case 1, 3, 4:
+					return &pdfColorSpace{comps: int(n)}, nil
+				}
+			}
+		}
+	case "Indexed", "I":
+		if len(arr) < 4 {
+			break
+		}
+		cs = &pdfColorSpace{comps: 1}
+		cs.base, err = d.colorSpace(arr[1], depth+1)
+		if err != nil || cs.base.base != nil {
+			break
+		}
+		hival, _ := d.resolve(arr[2]).(int64)
+		switch p := d.resolve(arr[3]).(type) {
+		case pdfString:
+			cs.palette = []byte(p)
+		case *pdfStream:
+			cs.palette, _, err = d.decodeStream(p, 256*4, true)
+			if err != nil {
+				return
+			}
+		}
+		if n := (int(hival) + 1) * cs.base.comps; n >= 0 && n < len(cs.palette) {
+			cs.palette = cs.palette[:n]
+		}
+		return cs, nil
+	}
+	return nil, errPDFUnsupported
+}
+
+// Decode raw image data with bpc bits per component
+func (cs *pdfColorSpace) decode(data []byte, w, h, bpc int,
+	decode pdfArray,
+) *image.RGBA {
+	// Invert components with a decode range of [1 0]
+	invert := make([]bool, cs.comps)
+	if cs.base == nil {
+		for i := range invert {
+			if 2*i+1 < len(decode) {
+				min, _ := decode[2*i].(int64)
+				max, _ := decode[2*i+1].(int64)
+				invert[i] = min > max
+			}
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rowLen := (w*cs.comps*bpc + 7) / 8
+	maxVal := 1<<uint(bpc) - 1
+	comps := make([]byte, cs.comps)
+	for y := 0; y < h; y++ {
+		var row []byte
+		if start := y * rowLen; start < len(data) {
+			row = data[start:]
+		}
+		for x := 0; x < w; x++ {
+			for i := range comps {
+				// Missing data is treated as 0
+				var v int
+				bit := (x*cs.comps + i) * bpc
+				if bit/8 < len(row) && bit/8 < rowLen {
+					if bpc == 16 {
+						v = int(row[bit/8])
+					} else {
+						v = int(row[bit/8]>>uint(8-bpc-bit%8)) & maxVal
+						if cs.base == nil {
+							v = v * 255 / maxVal
+						}
+					}
+				}
+				if invert[i] {
+					v = 255 - v
+				}
+				comps[i] = byte(v)
+			}
+			img.SetRGBA(x, y, cs.color(comps))
+		}
+	}
+	return img
+}
+
+// Convert components to colour
+func (cs *pdfColorSpace) color(comps []byte) color.RGBA {
+	if cs.base != nil {
+		n := cs.base.comps
+		i := int(comps[0]) * n
+		c := make([]byte, n)
+		if i+n <= len(cs.palette) {
+			copy(c, cs.palette[i:i+n])
+		}
+		return cs.base.color(c)
+	}
+	switch cs.comps {
+	case 1:
+		return color.RGBA{comps[0], comps[0], comps[0], 0xff}
+	case 3:
+		return color.RGBA{comps[0], comps[1], comps[2], 0xff}
+	default:
+		r, g, b := color.CMYKToRGB(comps[0], comps[1], comps[2], comps[3])
+		return color.RGBA{r, g, b, 0xff}
+	}
+}
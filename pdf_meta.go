@@ -0,0 +1,263 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Maximum size of an XMP metadata stream
+const maxXMPSize = 4 << 20
+
+// XML namespaces of XMP properties
+const (
+	xmlnsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlnsDC  = "http://purl.org/dc/elements/1.1/"
+	xmlnsPDF = "http://ns.adobe.com/pdf/1.3/"
+	xmlnsXMP = "http://ns.adobe.com/xap/1.0/"
+)
+
+// Code points of PDFDocEncoding, that differ from ISO 8859-1
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙', 0x1c: '˝', 0x1d: '˛',
+	0x1e: '˚', 0x1f: '˜', 0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…',
+	0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄', 0x88: '‹', 0x89: '›',
+	0x8a: '−', 0x8b: '‰', 0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł',
+	0x96: 'Œ', 0x97: 'Š', 0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł',
+	0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0x9f: utf8.RuneError, 0xa0: '€',
+}
+
+// Extract document metadata from the document information dictionary and
+// fill any missing fields from the XMP metadata stream
+func (d *pdfDoc) metadata() (m DocumentMeta) {
+	m.Encrypted = d.trailer["Encrypt"] != nil
+	if d.locked {
+		return
+	}
+
+	info, _ := d.resolve(d.trailer["Info"]).(pdfDict)
+	text := func(key pdfName) string {
+		s, _ := d.resolve(info[key]).(pdfString)
+		return decodePDFText(s)
+	}
+	m.Title = text("Title")
+	m.Author = text("Author")
+	m.Subject = text("Subject")
+	m.Producer = text("Producer")
+	m.Created, _ = parsePDFDate(text("CreationDate"))
+
+	root, _ := d.resolve(d.trailer["Root"]).(pdfDict)
+	if s, ok := d.resolve(root["Metadata"]).(*pdfStream); ok {
+		data, _, err := d.decodeStream(s, maxXMPSize, true)
+		if err == nil {
+			xmp := parseXMP(data)
+			for _, f := range [...]struct{ dst, src *string }{
+				{&m.Title, &xmp.Title},
+				{&m.Author, &xmp.Author},
+				{&m.Subject, &xmp.Subject},
+				{&m.Producer, &xmp.Producer},
+			} {
+				if *f.dst == "" {
+					*f.dst = *f.src
+				}
+			}
+			if m.Created.IsZero() {
+				m.Created = xmp.Created
+			}
+		}
+	}
+	return
+}
+
+// Decode a PDF text string encoded in UTF-16BE or UTF-8 with a byte order mark
+// or PDFDocEncoding otherwise
+func decodePDFText(s pdfString) string {
+	var r []rune
+	switch {
+	case strings.HasPrefix(string(s), "\xfe\xff"):
+		u := make([]uint16, (len(s)-2)/2)
+		for i := range u {
+			u[i] = uint16(s[2+2*i])<<8 | uint16(s[3+2*i])
+		}
+		r = utf16.Decode(u)
+	case strings.HasPrefix(string(s), "\xef\xbb\xbf"):
+		r = []rune(string(s[3:]))
+	default:
+		r = make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			if c, ok := pdfDocEncoding[s[i]]; ok {
+				r[i] = c
+			} else {
+				r[i] = rune(s[i])
+			}
+		}
+	}
+
+	// Strip language escape sequences and surrounding whitespace
+	var w strings.Builder
+	escaped := false
+	for _, c := range r {
+		if c == 0x1b {
+			escaped = !escaped
+			continue
+		}
+		if !escaped {
+			w.WriteRune(c)
+		}
+	}
+	str := strings.TrimSpace(w.String())
+	sanitize(&str)
+	return str
+}
+
+// Parse a date string in the format "D:YYYYMMDDHHmmSSOHH'mm'". All fields
+// after the year are optional.
+func parsePDFDate(s string) (t time.Time, ok bool) {
+	s = strings.TrimPrefix(s, "D:")
+	num := func(n int) (v int, ok bool) {
+		if len(s) < n {
+			return 0, false
+		}
+		for _, c := range s[:n] {
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+			v = v*10 + int(c-'0')
+		}
+		s = s[n:]
+		return v, true
+	}
+
+	fields := [...]int{0, 1, 1, 0, 0, 0}
+	widths := [...]int{4, 2, 2, 2, 2, 2}
+	for i := range fields {
+		v, valid := num(widths[i])
+		if !valid {
+			if i == 0 {
+				return
+			}
+			break
+		}
+		fields[i] = v
+	}
+
+	loc := time.UTC
+	if len(s) != 0 && (s[0] == '+' || s[0] == '-') {
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+		h, _ := num(2)
+		s = strings.TrimPrefix(s, "'")
+		m, _ := num(2)
+		loc = time.FixedZone("", sign*(h*3600+m*60))
+	}
+
+	t = time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3],
+		fields[4], fields[5], 0, loc)
+	return t, true
+}
+
+// Layouts of XMP dates
+var xmpDateLayouts = [...]string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Parse XMP metadata of a PDF document. Invalid XML is ignored.
+func parseXMP(data []byte) (m DocumentMeta) {
+	var (
+		dec   = xml.NewDecoder(bytes.NewReader(data))
+		stack []xml.Name
+		text  strings.Builder
+	)
+	dec.Strict = false
+
+	set := func(name xml.Name, val string) {
+		val = strings.TrimSpace(val)
+		sanitize(&val)
+		if val == "" {
+			return
+		}
+		switch name {
+		case xml.Name{Space: xmlnsDC, Local: "title"}:
+			if m.Title == "" {
+				m.Title = val
+			}
+		case xml.Name{Space: xmlnsDC, Local: "creator"}:
+			// Multiple authors are joined
+			if m.Author != "" {
+				m.Author += ", "
+			}
+			m.Author += val
+		case xml.Name{Space: xmlnsDC, Local: "description"}:
+			if m.Subject == "" {
+				m.Subject = val
+			}
+		case xml.Name{Space: xmlnsPDF, Local: "Producer"}:
+			m.Producer = val
+		case xml.Name{Space: xmlnsXMP, Local: "CreateDate"}:
+			for _, l := range xmpDateLayouts {
+				if t, err := time.Parse(l, val); err == nil {
+					m.Created = t
+					break
+				}
+			}
+		}
+	}
+
+	// Returns the innermost property element, skipping RDF containers
+	property := func() (name xml.Name) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].Space != xmlnsRDF {
+				return stack[i]
+			}
+		}
+		return
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			text.Reset()
+			stack = append(stack, tok.Name)
+			if tok.Name == (xml.Name{Space: xmlnsRDF, Local: "Description"}) {
+				// Simple properties can be stored as attributes
+				for _, a := range tok.Attr {
+					set(a.Name, a.Value)
+				}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			switch {
+			case name.Space != xmlnsRDF:
+				// Simple property
+				set(name, text.String())
+			case name.Local == "li":
+				// Item of an array property
+				set(property(), text.String())
+			}
+			text.Reset()
+		}
+	}
+}
@@ -0,0 +1,1515 @@
+package thumbnailer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/ascii85"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+// Minimal reader of the PDF object model. Only supports what is needed to
+// extract metadata and embedded images.
+
+const (
+	// Maximum nesting of arrays, dictionaries and indirect references
+	maxPDFDepth = 32
+
+	// Maximum size of a decoded PDF stream
+	maxPDFStreamSize = 100 << 20
+
+	// Maximum total size of all streams decoded from a PDF document
+	maxPDFDecodedSize = 256 << 20
+)
+
+var (
+	errPDFNoXref      = ErrInvalidPDF("cross-reference table not found")
+	errPDFSyntax      = ErrInvalidPDF("syntax error")
+	errPDFDepth       = ErrInvalidPDF("nesting too deep")
+	errPDFStreamSize  = ErrInvalidPDF("stream too big")
+	errPDFUnsupported = ErrInvalidPDF("unsupported stream filter")
+	errPDFNotStream   = ErrInvalidPDF("object is not a stream")
+	errPDFInvalidXref = ErrInvalidPDF("invalid cross-reference stream")
+	errPDFInvalidRoot = ErrInvalidPDF("document catalog not found")
+	errPDFEncryption  = ErrInvalidPDF("unsupported encryption")
+	errPDFEncrypted   = ErrInvalidPDF("invalid encrypted data")
+)
+
+// PDF object types. Null is nil, booleans are bool, integers are int64 and
+// reals are float64.
+type (
+	pdfName    string
+	pdfString  string
+	pdfKeyword string
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+
+	pdfRef struct {
+		num, gen int64
+	}
+
+	pdfStream struct {
+		dict   pdfDict
+		ref    pdfRef // Indirect object containing the stream
+		offset int64  // Start of stream data in the file
+	}
+)
+
+// Byte classes of the PDF lexer
+func isPDFSpace(b byte) bool {
+	switch b {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(b byte) bool {
+	switch b {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func isPDFRegular(b byte) bool {
+	return !isPDFSpace(b) && !isPDFDelimiter(b)
+}
+
+// Matches the remainder of an indirect reference after the object number
+var pdfRefSuffix = regexp.MustCompile(`^[\x00\t\n\f\r ]+(\d+)[\x00\t\n\f\r ]+R`)
+
+// Tokenizes and parses PDF objects starting at an offset in the file
+type pdfLexer struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func newPDFLexer(ra io.ReaderAt, size, offset int64) *pdfLexer {
+	return &pdfLexer{
+		r:   bufio.NewReader(io.NewSectionReader(ra, offset, size-offset)),
+		pos: offset,
+	}
+}
+
+func (l *pdfLexer) readByte() (b byte, err error) {
+	b, err = l.r.ReadByte()
+	if err == nil {
+		l.pos++
+	}
+	return
+}
+
+func (l *pdfLexer) unreadByte() {
+	l.r.UnreadByte()
+	l.pos--
+}
+
+// Skip whitespace and comments
+func (l *pdfLexer) skipSpace() (err error) {
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '%':
+			for b != '\r' && b != '\n' {
+				b, err = l.readByte()
+				if err != nil {
+					return err
+				}
+			}
+		case !isPDFSpace(b):
+			l.unreadByte()
+			return nil
+		}
+	}
+}
+
+// Read a sequence of regular characters
+func (l *pdfLexer) readRegular() []byte {
+	var buf []byte
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return buf
+		}
+		if !isPDFRegular(b) {
+			l.unreadByte()
+			return buf
+		}
+		buf = append(buf, b)
+	}
+}
+
+// Read the next object. Keywords not part of any object, such as "obj" or
+// "stream", are returned as pdfKeyword.
+func (l *pdfLexer) readObject(depth int) (interface{}, error) {
+	if depth > maxPDFDepth {
+		return nil, errPDFDepth
+	}
+	err := l.skipSpace()
+	if err != nil {
+		return nil, err
+	}
+	b, err := l.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch b {
+	case '/':
+		return l.readName(), nil
+	case '(':
+		return l.readLiteralString()
+	case '<':
+		b, err = l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '<' {
+			return l.readDict(depth)
+		}
+		l.unreadByte()
+		return l.readHexString()
+	case '[':
+		var arr pdfArray
+		for {
+			err = l.skipSpace()
+			if err != nil {
+				return nil, err
+			}
+			b, err = l.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == ']' {
+				return arr, nil
+			}
+			l.unreadByte()
+			obj, err := l.readObject(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, obj)
+		}
+	case ')', '>', ']', '{', '}':
+		return nil, errPDFSyntax
+	}
+
+	l.unreadByte()
+	tok := l.readRegular()
+	if len(tok) == 0 {
+		// Skip stray delimiter
+		l.readByte()
+		return nil, errPDFSyntax
+	}
+	switch s := string(tok); s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			// Check, if this is the start of an indirect reference
+			peek, _ := l.r.Peek(32)
+			if m := pdfRefSuffix.FindSubmatch(peek); m != nil {
+				gen, _ := strconv.ParseInt(string(m[1]), 10, 64)
+				l.r.Discard(len(m[0]))
+				l.pos += int64(len(m[0]))
+				return pdfRef{i, gen}, nil
+			}
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+		return pdfKeyword(s), nil
+	}
+}
+
+func (l *pdfLexer) readName() pdfName {
+	tok := l.readRegular()
+	buf := make([]byte, 0, len(tok))
+	for i := 0; i < len(tok); i++ {
+		if tok[i] == '#' && i+2 < len(tok) {
+			var b [1]byte
+			if _, err := hex.Decode(b[:], tok[i+1:i+3]); err == nil {
+				buf = append(buf, b[0])
+				i += 2
+				continue
+			}
+		}
+		buf = append(buf, tok[i])
+	}
+	return pdfName(buf)
+}
+
+func (l *pdfLexer) readLiteralString() (pdfString, error) {
+	var (
+		buf   []byte
+		depth = 1
+	)
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(buf), nil
+			}
+		case '\\':
+			b, err = l.readByte()
+			if err != nil {
+				return "", err
+			}
+			switch b {
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			case 't':
+				b = '\t'
+			case 'b':
+				b = '\b'
+			case 'f':
+				b = '\f'
+			case '\r':
+				// Line continuation
+				if b, err = l.readByte(); err == nil && b != '\n' {
+					l.unreadByte()
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if b >= '0' && b <= '7' {
+					// Up to 3 octal digits
+					val := b - '0'
+					for i := 0; i < 2; i++ {
+						b, err = l.readByte()
+						if err != nil {
+							return "", err
+						}
+						if b < '0' || b > '7' {
+							l.unreadByte()
+							break
+						}
+						val = val*8 + b - '0'
+					}
+					b = val
+				}
+			}
+		}
+		buf = append(buf, b)
+	}
+}
+
+func (l *pdfLexer) readHexString() (pdfString, error) {
+	var digits []byte
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '>' {
+			break
+		}
+		if !isPDFSpace(b) {
+			digits = append(digits, b)
+		}
+	}
+	if len(digits)%2 != 0 {
+		digits = append(digits, '0')
+	}
+	buf := make([]byte, len(digits)/2)
+	_, err := hex.Decode(buf, digits)
+	if err != nil {
+		return "", errPDFSyntax
+	}
+	return pdfString(buf), nil
+}
+
+func (l *pdfLexer) readDict(depth int) (pdfDict, error) {
+	dict := make(pdfDict)
+	for {
+		err := l.skipSpace()
+		if err != nil {
+			return nil, err
+		}
+		b, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '>' {
+			b, err = l.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if b != '>' {
+				return nil, errPDFSyntax
+			}
+			return dict, nil
+		}
+		if b != '/' {
+			return nil, errPDFSyntax
+		}
+		key := l.readName()
+		val, err := l.readObject(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if kw, ok := val.(pdfKeyword); ok {
+			return nil, ErrInvalidPDF("unexpected keyword: " + string(kw))
+		}
+		dict[key] = val
+	}
+}
+
+// Location of an object in the file
+type pdfXrefEntry struct {
+	free bool
+
+	// Offset in the file or index in the containing object stream
+	offset int64
+
+	// Containing object stream, if compressed
+	stream     int64
+	compressed bool
+}
+
+// Decoded object stream
+type pdfObjStream struct {
+	data    []byte
+	offsets []int64 // Object offsets in data by index
+}
+
+// Security handler parameters of an encrypted document
+type pdfEncryption struct {
+	key              []byte
+	stmAES, strAES   bool
+	stmNone, strNone bool // Identity crypt filter
+
+	// Metadata streams are not encrypted
+	plainMetadata bool
+}
+
+// PDF document opened for reading objects
+type pdfDoc struct {
+	ctx     context.Context
+	ra      io.ReaderAt
+	size    int64
+	xref    map[int64]pdfXrefEntry
+	trailer pdfDict
+	objects map[int64]interface{}
+	objStms map[int64]*pdfObjStream
+	crypt   *pdfEncryption
+	depth   int
+
+	// Bytes, that may still be decoded from streams of the document
+	budget int64
+
+	// Document is encrypted with a password other than the empty user
+	// password
+	locked bool
+}
+
+func openPDF(ctx context.Context, ra io.ReaderAt, size int64,
+) (d *pdfDoc, err error) {
+	d = &pdfDoc{
+		ctx:     ctx,
+		ra:      ra,
+		size:    size,
+		xref:    make(map[int64]pdfXrefEntry),
+		objects: make(map[int64]interface{}),
+		objStms: make(map[int64]*pdfObjStream),
+		budget:  maxPDFDecodedSize,
+	}
+	err = d.readXref()
+	if err != nil || d.trailer["Root"] == nil {
+		// Damaged cross-reference table. Reconstruct by scanning the file.
+		d.xref = make(map[int64]pdfXrefEntry)
+		d.trailer = nil
+		err = d.rebuildXref()
+		if err != nil {
+			return
+		}
+	}
+	if _, ok := d.resolve(d.trailer["Root"]).(pdfDict); !ok {
+		err = errPDFInvalidRoot
+		return
+	}
+	if d.trailer["Encrypt"] != nil {
+		err = d.initEncryption()
+	}
+	return
+}
+
+// Read the cross-reference sections starting from the last one
+func (d *pdfDoc) readXref() (err error) {
+	off, err := d.findStartXref()
+	if err != nil {
+		return
+	}
+	seen := make(map[int64]bool)
+	for off > 0 && !seen[off] {
+		seen[off] = true
+		trailer, err := d.readXrefSection(off)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+
+		// Hybrid files store compressed objects in an additional stream
+		if stm, ok := trailer["XRefStm"].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			if _, err := d.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+
+		prev, _ := trailer["Prev"].(int64)
+		off = prev
+	}
+	if d.trailer == nil {
+		return errPDFNoXref
+	}
+	return
+}
+
+// Find the offset of the last cross-reference section
+func (d *pdfDoc) findStartXref() (off int64, err error) {
+	const tail = 1 << 10
+	start := d.size - tail
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, d.size-start)
+	_, err = d.ra.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return
+	}
+	i := bytes.LastIndex(buf, []byte("startxref"))
+	if i == -1 {
+		return 0, errPDFNoXref
+	}
+	l := newPDFLexer(d.ra, d.size, start+int64(i)+9)
+	obj, err := l.readObject(0)
+	if err != nil {
+		return
+	}
+	off, ok := obj.(int64)
+	if !ok || off <= 0 || off >= d.size {
+		return 0, errPDFNoXref
+	}
+	return
+}
+
+// Record entry, unless already defined by a newer section
+func (d *pdfDoc) setXref(num int64, e pdfXrefEntry) {
+	if _, ok := d.xref[num]; !ok {
+		d.xref[num] = e
+	}
+}
+
+// Read a cross-reference table or stream at off and return its trailer
+func (d *pdfDoc) readXrefSection(off int64) (trailer pdfDict, err error) {
+	l := newPDFLexer(d.ra, d.size, off)
+	obj, err := l.readObject(0)
+	if err != nil {
+		return
+	}
+	if obj == pdfKeyword("xref") {
+		return d.readXrefTable(l)
+	}
+
+	// Cross-reference stream
+	s, err := d.readIndirect(l, obj)
+	if err != nil {
+		return
+	}
+	stream, ok := s.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, errPDFNoXref
+	}
+	return stream.dict, d.readXrefStream(stream)
+}
+
+func (d *pdfDoc) readXrefTable(l *pdfLexer) (trailer pdfDict, err error) {
+	for {
+		obj, err := l.readObject(0)
+		if err != nil {
+			return nil, err
+		}
+		if obj == pdfKeyword("trailer") {
+			obj, err = l.readObject(0)
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(pdfDict)
+			if !ok {
+				return nil, errPDFNoXref
+			}
+			return trailer, nil
+		}
+
+		start, ok := obj.(int64)
+		if !ok {
+			return nil, errPDFNoXref
+		}
+		obj, err = l.readObject(0)
+		if err != nil {
+			return nil, err
+		}
+		count, ok := obj.(int64)
+		if !ok || count < 0 {
+			return nil, errPDFNoXref
+		}
+		for i := int64(0); i < count; i++ {
+			var fields [3]interface{}
+			for j := range fields {
+				fields[j], err = l.readObject(0)
+				if err != nil {
+					return nil, err
+				}
+			}
+			off, ok1 := fields[0].(int64)
+			_, ok2 := fields[1].(int64)
+			typ, ok3 := fields[2].(pdfKeyword)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, errPDFNoXref
+			}
+			d.setXref(start+i, pdfXrefEntry{
+				free:   typ != "n",
+				offset: off,
+			})
+		}
+	}
+}
+
+func (d *pdfDoc) readXrefStream(s *pdfStream) (err error) {
+	// Cross-reference streams are never encrypted
+	data, _, err := d.decodeStream(s, maxPDFStreamSize, false)
+	if err != nil {
+		return
+	}
+
+	w, ok := s.dict["W"].(pdfArray)
+	if !ok || len(w) != 3 {
+		return errPDFInvalidXref
+	}
+	var widths [3]int
+	rowLen := 0
+	for i, v := range w {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return errPDFInvalidXref
+		}
+		widths[i] = int(n)
+		rowLen += int(n)
+	}
+	if rowLen == 0 {
+		return errPDFInvalidXref
+	}
+
+	index, ok := s.dict["Index"].(pdfArray)
+	if !ok {
+		size, _ := s.dict["Size"].(int64)
+		index = pdfArray{int64(0), size}
+	}
+	field := func(row []byte, i int) (v int64) {
+		start := 0
+		for j := 0; j < i; j++ {
+			start += widths[j]
+		}
+		for _, b := range row[start : start+widths[i]] {
+			v = v<<8 | int64(b)
+		}
+		return
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 {
+			return errPDFInvalidXref
+		}
+		for j := int64(0); j < count; j++ {
+			if len(data) < rowLen {
+				return
+			}
+			row := data[:rowLen]
+			data = data[rowLen:]
+
+			typ := int64(1)
+			if widths[0] != 0 {
+				typ = field(row, 0)
+			}
+			switch typ {
+			case 0:
+				d.setXref(start+j, pdfXrefEntry{free: true})
+			case 1:
+				d.setXref(start+j, pdfXrefEntry{offset: field(row, 1)})
+			case 2:
+				d.setXref(start+j, pdfXrefEntry{
+					compressed: true,
+					stream:     field(row, 1),
+					offset:     field(row, 2),
+				})
+			}
+		}
+	}
+	return
+}
+
+// Matches the header of an indirect object
+var pdfObjHeader = regexp.MustCompile(`(\d+)[\x00\t\n\f\r ]+(\d+)[\x00\t\n\f\r ]+obj\b`)
+
+// Reconstruct the cross-reference table by scanning the file for objects
+func (d *pdfDoc) rebuildXref() (err error) {
+	const (
+		chunkSize = 1 << 20
+		overlap   = 64
+	)
+	var trailers []int64
+	buf := make([]byte, chunkSize+overlap)
+	for off := int64(0); off < d.size; off += chunkSize {
+		if err = d.ctx.Err(); err != nil {
+			return
+		}
+		n, err := d.ra.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		chunk := buf[:n]
+		for _, m := range pdfObjHeader.FindAllSubmatchIndex(chunk, -1) {
+			if m[0] >= chunkSize || (m[0] > 0 && isPDFRegular(chunk[m[0]-1])) {
+				// Handled by the next chunk or part of another token
+				continue
+			}
+			num, _ := strconv.ParseInt(string(chunk[m[2]:m[3]]), 10, 64)
+
+			// Later objects override earlier ones
+			d.xref[num] = pdfXrefEntry{offset: off + int64(m[0])}
+		}
+		for i := 0; ; {
+			j := bytes.Index(chunk[i:], []byte("trailer"))
+			if j == -1 || i+j >= chunkSize {
+				break
+			}
+			trailers = append(trailers, off+int64(i+j+7))
+			i += j + 7
+		}
+	}
+
+	d.trailer = make(pdfDict)
+	for _, off := range trailers {
+		l := newPDFLexer(d.ra, d.size, off)
+		obj, _ := l.readObject(0)
+		if t, ok := obj.(pdfDict); ok {
+			for k, v := range t {
+				d.trailer[k] = v
+			}
+		}
+	}
+
+	// Register objects in object streams and find the catalog and
+	// cross-reference stream trailers, if no trailer dictionary was found
+	nums := make([]int64, 0, len(d.xref))
+	for num := range d.xref {
+		nums = append(nums, num)
+	}
+	for _, num := range nums {
+		if err = d.ctx.Err(); err != nil {
+			return
+		}
+		s, ok := d.resolve(pdfRef{num: num}).(*pdfStream)
+		if !ok {
+			continue
+		}
+		switch s.dict["Type"] {
+		case pdfName("ObjStm"):
+			os, err := d.objStream(num)
+			if err != nil {
+				continue
+			}
+			data := os.data
+			l := newPDFLexer(bytes.NewReader(data), int64(len(data)), 0)
+			for i := range os.offsets {
+				obj, _ := l.readObject(0)
+				n, ok := obj.(int64)
+				l.readObject(0)
+				if ok {
+					d.setXref(n, pdfXrefEntry{
+						compressed: true,
+						stream:     num,
+						offset:     int64(i),
+					})
+				}
+			}
+		case pdfName("XRef"):
+			for _, k := range [...]pdfName{"Root", "Info", "Encrypt", "ID"} {
+				if d.trailer[k] == nil && s.dict[k] != nil {
+					d.trailer[k] = s.dict[k]
+				}
+			}
+		}
+	}
+	if d.trailer["Root"] == nil {
+		for num := range d.xref {
+			dict, ok := d.resolve(pdfRef{num: num}).(pdfDict)
+			if ok && dict["Type"] == pdfName("Catalog") {
+				d.trailer["Root"] = pdfRef{num: num}
+				break
+			}
+		}
+	}
+	if d.trailer["Root"] == nil {
+		return errPDFInvalidRoot
+	}
+	return
+}
+
+// Read an indirect object definition after its object number was already read
+// into first
+func (d *pdfDoc) readIndirect(l *pdfLexer, first interface{},
+) (obj interface{}, err error) {
+	num, ok := first.(int64)
+	if !ok {
+		return nil, errPDFSyntax
+	}
+	gen, err := l.readObject(0)
+	if err != nil {
+		return
+	}
+	kw, err := l.readObject(0)
+	if err != nil {
+		return
+	}
+	g, ok := gen.(int64)
+	if !ok || kw != pdfKeyword("obj") {
+		return nil, errPDFSyntax
+	}
+	ref := pdfRef{num, g}
+
+	obj, err = l.readObject(0)
+	if err != nil {
+		return
+	}
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return d.decryptObject(obj, ref), nil
+	}
+
+	// Check for stream data following the dictionary
+	next, err := l.readObject(0)
+	if err != nil || next != pdfKeyword("stream") {
+		return d.decryptObject(dict, ref), nil
+	}
+	b, err := l.readByte()
+	if err != nil {
+		return
+	}
+	if b == '\r' {
+		if b, err = l.readByte(); err == nil && b != '\n' {
+			l.unreadByte()
+		}
+	} else if b != '\n' {
+		l.unreadByte()
+	}
+	return &pdfStream{
+		dict:   d.decryptObject(dict, ref).(pdfDict),
+		ref:    ref,
+		offset: l.pos,
+	}, nil
+}
+
+// Resolve an indirect reference to the referenced object. Other objects are
+// returned unchanged. Unresolvable references are null, as per the
+// specification.
+func (d *pdfDoc) resolve(obj interface{}) interface{} {
+	ref, ok := obj.(pdfRef)
+	if !ok {
+		return obj
+	}
+	if cached, ok := d.objects[ref.num]; ok {
+		return cached
+	}
+	if d.depth > maxPDFDepth {
+		return nil
+	}
+	d.depth++
+	defer func() {
+		d.depth--
+	}()
+
+	// Guard against reference cycles
+	d.objects[ref.num] = nil
+
+	e, ok := d.xref[ref.num]
+	if !ok || e.free {
+		return nil
+	}
+	if e.compressed {
+		obj = d.loadCompressed(e)
+	} else {
+		l := newPDFLexer(d.ra, d.size, e.offset)
+		first, err := l.readObject(0)
+		if err != nil {
+			return nil
+		}
+		obj, err = d.readIndirect(l, first)
+		if err != nil {
+			return nil
+		}
+	}
+	d.objects[ref.num] = obj
+	return obj
+}
+
+// Load an object from an object stream
+func (d *pdfDoc) loadCompressed(e pdfXrefEntry) interface{} {
+	os, err := d.objStream(e.stream)
+	if err != nil || e.offset < 0 || e.offset >= int64(len(os.offsets)) {
+		return nil
+	}
+	off := os.offsets[e.offset]
+	if off < 0 || off >= int64(len(os.data)) {
+		return nil
+	}
+	l := newPDFLexer(bytes.NewReader(os.data), int64(len(os.data)), off)
+	obj, err := l.readObject(0)
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// Decode the object stream with the passed object number
+func (d *pdfDoc) objStream(num int64) (os *pdfObjStream, err error) {
+	if os, ok := d.objStms[num]; ok {
+		if os == nil {
+			return nil, errPDFSyntax
+		}
+		return os, nil
+	}
+	d.objStms[num] = nil
+
+	s, ok := d.resolve(pdfRef{num: num}).(*pdfStream)
+	if !ok {
+		return nil, errPDFNotStream
+	}
+	data, _, err := d.decodeStream(s, maxPDFStreamSize, true)
+	if err != nil {
+		return
+	}
+	n, _ := s.dict["N"].(int64)
+	first, _ := s.dict["First"].(int64)
+	if n < 0 || first < 0 || first > int64(len(data)) {
+		return nil, errPDFSyntax
+	}
+	// Each object number and offset pair takes at least 2 bytes
+	if n > first/2 {
+		n = first / 2
+	}
+
+	os = &pdfObjStream{
+		data: data,
+	}
+	l := newPDFLexer(bytes.NewReader(data), first, 0)
+	for i := int64(0); i < n; i++ {
+		l.readObject(0)
+		obj, err := l.readObject(0)
+		if err != nil {
+			break
+		}
+		off, _ := obj.(int64)
+		os.offsets = append(os.offsets, first+off)
+	}
+	d.objStms[num] = os
+	return
+}
+
+// Image compression filters, that are not decoded by decodeStream
+var pdfImageFilters = map[pdfName]bool{
+	"DCTDecode":      true,
+	"JPXDecode":      true,
+	"JBIG2Decode":    true,
+	"CCITTFaxDecode": true,
+}
+
+// Return the raw stream data
+func (d *pdfDoc) rawStream(s *pdfStream, limit int64) (data []byte, err error) {
+	length, ok := d.resolve(s.dict["Length"]).(int64)
+	if !ok || length < 0 || s.offset+length > d.size {
+		// Invalid length. Search for the end of the stream instead.
+		length, err = d.findEndstream(s.offset, limit)
+		if err != nil {
+			return
+		}
+	}
+	if length > limit {
+		return nil, errPDFStreamSize
+	}
+	data = make([]byte, length)
+	_, err = d.ra.ReadAt(data, s.offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+// Find the length of stream data starting at off by searching for the
+// endstream keyword
+func (d *pdfDoc) findEndstream(off, limit int64) (length int64, err error) {
+	const chunkSize = 1 << 16
+	kw := []byte("endstream")
+	buf := make([]byte, chunkSize+len(kw))
+	for pos := off; pos < d.size && pos-off <= limit; pos += chunkSize {
+		n, err := d.ra.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.Index(buf[:n], kw); i != -1 {
+			length = pos - off + int64(i)
+			// Strip the end of line marker preceding the keyword
+			for length > 0 {
+				var b [1]byte
+				d.ra.ReadAt(b[:], off+length-1)
+				if b[0] != '\n' && b[0] != '\r' {
+					break
+				}
+				length--
+			}
+			return length, nil
+		}
+	}
+	return 0, errPDFSyntax
+}
+
+// Decode stream data of up to limit bytes. Intermediate decoding steps are
+// limited to maxPDFStreamSize. All decoded data is charged against the budget
+// of the document. Image compression filters are not decoded and returned as
+// filter instead. decrypt specifies, if the stream is subject to document
+// encryption.
+func (d *pdfDoc) decodeStream(s *pdfStream, limit int64, decrypt bool) (
+	data []byte, filter pdfName, err error,
+) {
+	data, err = d.rawStream(s, maxPDFStreamSize)
+	if err == nil {
+		err = d.charge(len(data))
+	}
+	if err != nil {
+		return
+	}
+	if decrypt && d.crypt != nil && !d.crypt.stmNone &&
+		s.dict["Type"] != pdfName("XRef") &&
+		!(d.crypt.plainMetadata && s.dict["Type"] == pdfName("Metadata")) {
+		data, err = d.crypt.decrypt(data, s.ref, d.crypt.stmAES)
+		if err != nil {
+			return
+		}
+	}
+
+	var filters, params pdfArray
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	switch p := d.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = pdfArray{p}
+	case pdfArray:
+		params = p
+	}
+
+	for i, f := range filters {
+		name, _ := d.resolve(f).(pdfName)
+		var param pdfDict
+		if i < len(params) {
+			param, _ = d.resolve(params[i]).(pdfDict)
+		}
+		if pdfImageFilters[name] {
+			if i != len(filters)-1 {
+				return nil, "", errPDFUnsupported
+			}
+			return data, name, nil
+		}
+		filterLimit := int64(maxPDFStreamSize)
+		if i == len(filters)-1 {
+			// Stop decompressing the last filter as soon as the limit is
+			// exceeded. PNG predictors add up to one byte per byte of output.
+			filterLimit = limit
+			if pred, _ := param["Predictor"].(int64); pred > 1 {
+				filterLimit *= 2
+			}
+			if filterLimit > maxPDFStreamSize {
+				filterLimit = maxPDFStreamSize
+			}
+		}
+		if filterLimit > d.budget {
+			filterLimit = d.budget
+		}
+		data, err = d.applyFilter(data, name, param, filterLimit)
+		if err == nil {
+			err = d.charge(len(data))
+		}
+		if err != nil {
+			return
+		}
+	}
+	if int64(len(data)) > limit {
+		return nil, "", errPDFStreamSize
+	}
+	return
+}
+
+// Charge n decoded bytes against the budget of the document
+func (d *pdfDoc) charge(n int) error {
+	if int64(n) > d.budget {
+		d.budget = 0
+		return errPDFStreamSize
+	}
+	d.budget -= int64(n)
+	return nil
+}
+
+// Decode data with a single non-image stream filter
+func (d *pdfDoc) applyFilter(data []byte, name pdfName, param pdfDict,
+	limit int64,
+) (out []byte, err error) {
+	var r io.Reader
+	switch name {
+	case "FlateDecode", "Fl":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+	case "ASCIIHexDecode", "AHx":
+		end := bytes.IndexByte(data, '>')
+		if end != -1 {
+			data = data[:end]
+		}
+		l := newPDFLexer(bytes.NewReader(append(data, '>')),
+			int64(len(data)+1), 0)
+		s, err := l.readHexString()
+		return []byte(s), err
+	case "ASCII85Decode", "A85":
+		data = bytes.TrimSpace(data)
+		data = bytes.TrimPrefix(data, []byte("<~"))
+		if end := bytes.Index(data, []byte("~>")); end != -1 {
+			data = data[:end]
+		}
+		r = ascii85.NewDecoder(bytes.NewReader(data))
+	case "RunLengthDecode", "RL":
+		r = &runLengthReader{data: data}
+	default:
+		return nil, errPDFUnsupported
+	}
+
+	out, err = ioutil.ReadAll(io.LimitReader(r, limit+1))
+	switch {
+	case err == io.ErrUnexpectedEOF && len(out) != 0:
+		// Tolerate truncated compressed data
+		err = nil
+	case err != nil:
+		return
+	}
+	if int64(len(out)) > limit {
+		return nil, errPDFStreamSize
+	}
+
+	if pred, _ := param["Predictor"].(int64); pred > 1 {
+		out, err = unpredict(out, param, pred)
+	}
+	return
+}
+
+// Reverse PNG or TIFF predictor encoding
+func unpredict(data []byte, param pdfDict, pred int64) ([]byte, error) {
+	colors, bpc, columns := int64(1), int64(8), int64(1)
+	if v, ok := param["Colors"].(int64); ok {
+		colors = v
+	}
+	if v, ok := param["BitsPerComponent"].(int64); ok {
+		bpc = v
+	}
+	if v, ok := param["Columns"].(int64); ok {
+		columns = v
+	}
+	if colors < 1 || colors > 32 || bpc < 1 || bpc > 16 || columns < 1 ||
+		columns > 1<<20 {
+		return nil, errPDFSyntax
+	}
+	bpp := int((colors*bpc + 7) / 8)
+	rowLen := int((colors*bpc*columns + 7) / 8)
+
+	if pred == 2 {
+		// TIFF predictor. Only 8 bit components are supported.
+		if bpc != 8 {
+			return nil, errPDFUnsupported
+		}
+		for row := 0; row+rowLen <= len(data); row += rowLen {
+			for i := row + bpp; i < row+rowLen; i++ {
+				data[i] += data[i-bpp]
+			}
+		}
+		return data, nil
+	}
+
+	// PNG predictors with a filter type byte before each row
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) >= rowLen+1 {
+		typ, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch typ {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// Decodes RunLengthDecode data
+type runLengthReader struct {
+	data []byte
+	buf  []byte
+}
+
+func (r *runLengthReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if len(r.data) == 0 {
+			return 0, io.EOF
+		}
+		l := int(r.data[0])
+		switch {
+		case l == 128:
+			// End of data
+			r.data = nil
+			return 0, io.EOF
+		case l < 128:
+			end := 1 + l + 1
+			if end > len(r.data) {
+				end = len(r.data)
+			}
+			r.buf = r.data[1:end]
+			r.data = r.data[end:]
+		default:
+			if len(r.data) < 2 {
+				r.data = nil
+				return 0, io.EOF
+			}
+			r.buf = bytes.Repeat(r.data[1:2], 257-l)
+			r.data = r.data[2:]
+		}
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
+
+// Padding of passwords in the standard security handler
+var pdfPasswordPadding = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56,
+	0xFF, 0xFA, 0x01, 0x08, 0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80,
+	0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+// Derive the file key of the standard security handler from the empty user
+// password. Documents encrypted with any other user password are locked.
+func (d *pdfDoc) initEncryption() (err error) {
+	enc, ok := d.resolve(d.trailer["Encrypt"]).(pdfDict)
+	if !ok || enc["Filter"] != pdfName("Standard") {
+		d.locked = true
+		return
+	}
+	// The encryption dictionary itself is not encrypted. Drop any strings,
+	// that might have been cached before encryption was known.
+	d.objects = make(map[int64]interface{})
+
+	v, _ := enc["V"].(int64)
+	r, _ := enc["R"].(int64)
+	o, _ := enc["O"].(pdfString)
+	u, _ := enc["U"].(pdfString)
+	p, _ := enc["P"].(int64)
+	c := &pdfEncryption{
+		plainMetadata: enc["EncryptMetadata"] == false,
+	}
+
+	switch v {
+	case 1, 2:
+	case 4, 5:
+		// Crypt filters
+		cf, _ := d.resolve(enc["CF"]).(pdfDict)
+		method := func(key pdfName) (aes, none bool) {
+			name, _ := enc[key].(pdfName)
+			if name == "" || name == "Identity" {
+				return false, true
+			}
+			f, _ := d.resolve(cf[name]).(pdfDict)
+			switch f["CFM"] {
+			case pdfName("AESV2"), pdfName("AESV3"):
+				return true, false
+			case pdfName("None"):
+				return false, true
+			}
+			return false, false
+		}
+		c.stmAES, c.stmNone = method("StmF")
+		c.strAES, c.strNone = method("StrF")
+	default:
+		return errPDFEncryption
+	}
+
+	switch {
+	case r >= 2 && r <= 4:
+		n := 5
+		if r >= 3 {
+			n = 16
+			if l, ok := enc["Length"].(int64); ok && l >= 40 && l <= 128 {
+				n = int(l / 8)
+			}
+		}
+		var id []byte
+		if ids, ok := d.resolve(d.trailer["ID"]).(pdfArray); ok && len(ids) != 0 {
+			s, _ := ids[0].(pdfString)
+			id = []byte(s)
+		}
+		h := md5.New()
+		h.Write(pdfPasswordPadding)
+		h.Write([]byte(o))
+		var pb [4]byte
+		binary.LittleEndian.PutUint32(pb[:], uint32(p))
+		h.Write(pb[:])
+		h.Write(id)
+		if r == 4 && enc["EncryptMetadata"] == false {
+			h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		}
+		key := h.Sum(nil)
+		if r >= 3 {
+			for i := 0; i < 50; i++ {
+				sum := md5.Sum(key[:n])
+				key = sum[:]
+			}
+		}
+		c.key = key[:n]
+
+		// Validate the empty user password
+		var check []byte
+		if r == 2 {
+			check = rc4Crypt(c.key, pdfPasswordPadding)
+		} else {
+			h := md5.New()
+			h.Write(pdfPasswordPadding)
+			h.Write(id)
+			check = h.Sum(nil)
+			for i := 0; i < 20; i++ {
+				k := make([]byte, len(c.key))
+				for j := range k {
+					k[j] = c.key[j] ^ byte(i)
+				}
+				check = rc4Crypt(k, check)
+			}
+		}
+		if len(u) < len(check) || !bytes.Equal([]byte(u)[:len(check)], check) {
+			d.locked = true
+			return
+		}
+	case r == 5 || r == 6:
+		ue, _ := enc["UE"].(pdfString)
+		if len(u) < 48 || len(ue) != 32 {
+			return errPDFEncryption
+		}
+		if !bytes.Equal(pdfHash(r, nil, []byte(u)[32:40], nil),
+			[]byte(u)[:32]) {
+			d.locked = true
+			return
+		}
+		block, err := aes.NewCipher(pdfHash(r, nil, []byte(u)[40:48], nil))
+		if err != nil {
+			return err
+		}
+		c.key = make([]byte, 32)
+		cipher.NewCBCDecrypter(block, make([]byte, 16)).
+			CryptBlocks(c.key, []byte(ue))
+	default:
+		return errPDFEncryption
+	}
+
+	d.crypt = c
+	return
+}
+
+// Hash a password for revision 5 and 6 security handlers
+func pdfHash(r int64, password, salt, udata []byte) []byte {
+	h := sha256.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(udata)
+	k := h.Sum(nil)
+	if r == 5 {
+		return k
+	}
+
+	for i := 0; ; i++ {
+		k1 := make([]byte, 0, 64*(len(password)+len(k)+len(udata)))
+		for j := 0; j < 64; j++ {
+			k1 = append(k1, password...)
+			k1 = append(k1, k...)
+			k1 = append(k1, udata...)
+		}
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+
+		var sum int
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		switch sum % 3 {
+		case 0:
+			s := sha256.Sum256(e)
+			k = s[:]
+		case 1:
+			s := sha512.Sum384(e)
+			k = s[:]
+		case 2:
+			s := sha512.Sum512(e)
+			k = s[:]
+		}
+		if i >= 63 && int(e[len(e)-1]) <= i-32 {
+			return k[:32]
+		}
+	}
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, _ := rc4.NewCipher(key)
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// Decrypt the data of the object identified by ref
+func (c *pdfEncryption) decrypt(data []byte, ref pdfRef, useAES bool,
+) ([]byte, error) {
+	key := c.key
+	if len(c.key) != 32 {
+		// Object key for revisions below 5
+		h := md5.New()
+		h.Write(c.key)
+		h.Write([]byte{
+			byte(ref.num), byte(ref.num >> 8), byte(ref.num >> 16),
+			byte(ref.gen), byte(ref.gen >> 8),
+		})
+		if useAES {
+			h.Write([]byte("sAlT"))
+		}
+		key = h.Sum(nil)
+		if n := len(c.key) + 5; n < len(key) {
+			key = key[:n]
+		}
+	}
+
+	if !useAES {
+		return rc4Crypt(key, data), nil
+	}
+	if len(data) < 32 || len(data)%16 != 0 {
+		return nil, errPDFEncrypted
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data)-16)
+	cipher.NewCBCDecrypter(block, data[:16]).CryptBlocks(out, data[16:])
+
+	// Strip PKCS#7 padding
+	if pad := int(out[len(out)-1]); pad >= 1 && pad <= 16 {
+		out = out[:len(out)-pad]
+	}
+	return out, nil
+}
+
+// Decrypt all strings in obj, which is part of the indirect object ref
+func (d *pdfDoc) decryptObject(obj interface{}, ref pdfRef) interface{} {
+	if d.crypt == nil || d.crypt.strNone {
+		return obj
+	}
+	switch o := obj.(type) {
+	case pdfString:
+		data, err := d.crypt.decrypt([]byte(o), ref, d.crypt.strAES)
+		if err != nil {
+			return o
+		}
+		return pdfString(data)
+	case pdfArray:
+		for i, v := range o {
+			o[i] = d.decryptObject(v, ref)
+		}
+	case pdfDict:
+		for k, v := range o {
+			o[k] = d.decryptObject(v, ref)
+		}
+	}
+	return obj
+}
@@ -0,0 +1,465 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// Build a PDF file with a classic cross-reference table from the passed
+// object bodies numbered from 1
+func buildPDF(objects ...[]byte) []byte {
+	var (
+		w       bytes.Buffer
+		offsets []int
+	)
+	w.WriteString("%PDF-1.4\n")
+	for i, o := range objects {
+		offsets = append(offsets, w.Len())
+		fmt.Fprintf(&w, "%d 0 obj\n", i+1)
+		w.Write(o)
+		w.WriteString("\nendobj\n")
+	}
+	start := w.Len()
+	fmt.Fprintf(&w, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&w, "%010d 00000 n\r\n", off)
+	}
+	fmt.Fprintf(&w, "trailer\n<< /Size %d /Root 1 0 R >>\n", len(objects)+1)
+	fmt.Fprintf(&w, "startxref\n%d\n%%%%EOF\n", start)
+	return w.Bytes()
+}
+
+// Build a single page PDF file with an image XObject with the passed
+// dictionary entries and data
+func buildImagePDF(dict string, data []byte) []byte {
+	stream := []byte(fmt.Sprintf(
+		"<< /Type /XObject /Subtype /Image %s /Length %d >>\nstream\n",
+		dict, len(data),
+	))
+	stream = append(stream, data...)
+	stream = append(stream, "\nendstream"...)
+	return buildPDF(
+		[]byte("<< /Type /Catalog /Pages 2 0 R >>"),
+		[]byte("<< /Type /Pages /Kids [3 0 R] /Count 1"+
+			" /Resources << /XObject << /Im1 4 0 R >> >> >>"),
+		[]byte("<< /Type /Page /Parent 2 0 R >>"),
+		stream,
+	)
+}
+
+func flate(data []byte) []byte {
+	var w bytes.Buffer
+	z := zlib.NewWriter(&w)
+	z.Write(data)
+	z.Close()
+	return w.Bytes()
+}
+
+func TestPDFObjects(t *testing.T) {
+	t.Parallel()
+
+	const src = `<< /Name#20A (lit\(e\)ral\n\101) /Hex <48 6 > /Ref 12 0 R` +
+		` /Arr [1 -2.5 true null /N] >>`
+	l := newPDFLexer(bytes.NewReader([]byte(src)), int64(len(src)), 0)
+	obj, err := l.readObject(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		t.Fatalf("expected dictionary, got %T", obj)
+	}
+
+	cases := [...]struct {
+		key pdfName
+		std interface{}
+	}{
+		{"Name A", pdfString("lit(e)ral\nA")},
+		{"Hex", pdfString("H`")},
+		{"Ref", pdfRef{12, 0}},
+	}
+	for _, c := range cases {
+		if dict[c.key] != c.std {
+			t.Errorf("%s: expected %#v, got %#v", c.key, c.std, dict[c.key])
+		}
+	}
+
+	arr, _ := dict["Arr"].(pdfArray)
+	std := pdfArray{int64(1), -2.5, true, nil, pdfName("N")}
+	if len(arr) != len(std) {
+		t.Fatalf("expected %v, got %v", std, arr)
+	}
+	for i := range std {
+		if arr[i] != std[i] {
+			t.Errorf("array element %d: expected %#v, got %#v", i, std[i], arr[i])
+		}
+	}
+}
+
+func TestPDFRawImages(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, dict string
+		data       []byte
+		std        [2]color.RGBA // First and last pixel
+	}{
+		{
+			name: "flate RGB",
+			dict: "/Width 2 /Height 1 /ColorSpace /DeviceRGB" +
+				" /BitsPerComponent 8 /Filter /FlateDecode",
+			data: flate([]byte{0xff, 0, 0, 0, 0, 0xff}),
+			std:  [2]color.RGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}},
+		},
+		{
+			name: "PNG predictor",
+			dict: "/Width 2 /Height 2 /ColorSpace /DeviceGray" +
+				" /BitsPerComponent 8 /Filter /FlateDecode" +
+				" /DecodeParms << /Predictor 15 /Columns 2 >>",
+			// Sub and Up filters
+			data: flate([]byte{1, 0x10, 0x10, 2, 0x10, 0x10}),
+			std: [2]color.RGBA{
+				{0x10, 0x10, 0x10, 0xff},
+				{0x30, 0x30, 0x30, 0xff},
+			},
+		},
+		{
+			name: "inverted 1 bit gray",
+			dict: "/Width 9 /Height 1 /ColorSpace /DeviceGray" +
+				" /BitsPerComponent 1 /Decode [1 0] /Filter /ASCIIHexDecode",
+			data: []byte("7F 80>"),
+			std:  [2]color.RGBA{{0xff, 0xff, 0xff, 0xff}, {0, 0, 0, 0xff}},
+		},
+		{
+			name: "indexed",
+			dict: "/Width 2 /Height 1 /BitsPerComponent 8" +
+				" /ColorSpace [/Indexed /DeviceRGB 1 <00ff00 0000ff>]",
+			data: []byte{1, 0},
+			std:  [2]color.RGBA{{0, 0, 0xff, 0xff}, {0, 0xff, 0, 0xff}},
+		},
+		{
+			name: "CMYK",
+			dict: "/Width 1 /Height 1 /ColorSpace /DeviceCMYK" +
+				" /BitsPerComponent 8",
+			data: []byte{0, 0xff, 0xff, 0},
+			std:  [2]color.RGBA{{0xff, 0, 0, 0xff}, {0xff, 0, 0, 0xff}},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			buf := buildImagePDF(c.dict, c.data)
+			d, err := openPDF(context.Background(), bytes.NewReader(buf),
+				int64(len(buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := d.pageCount(); n != 1 {
+				t.Fatalf("expected 1 page, got %d", n)
+			}
+			page := d.page(0)
+			if page == nil {
+				t.Fatal("page not found")
+			}
+			res, _ := d.resolve(page["Resources"]).(pdfDict)
+			images := d.pageImages(res, 0, make(map[int64]bool))
+			if len(images) != 1 {
+				t.Fatalf("expected 1 image, got %d", len(images))
+			}
+			enc, err := d.encodeImage(images[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(enc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b := img.Bounds()
+			first := color.RGBAModel.Convert(img.At(0, 0))
+			last := color.RGBAModel.Convert(img.At(b.Max.X-1, b.Max.Y-1))
+			if first != c.std[0] || last != c.std[1] {
+				t.Fatalf("expected %v, got %v", c.std, [2]color.Color{first, last})
+			}
+		})
+	}
+}
+
+func TestPDFSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	open := func(t *testing.T, dict string, data []byte) (*pdfDoc, pdfImage) {
+		t.Helper()
+
+		buf := buildImagePDF(dict, data)
+		d, err := openPDF(context.Background(), bytes.NewReader(buf),
+			int64(len(buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, _ := d.resolve(d.page(0)["Resources"]).(pdfDict)
+		images := d.pageImages(res, 0, make(map[int64]bool))
+		if len(images) != 1 {
+			t.Fatalf("expected 1 image, got %d", len(images))
+		}
+		return d, images[0]
+	}
+
+	t.Run("too many pixels", func(t *testing.T) {
+		t.Parallel()
+
+		// Only 100 MB packed, but 3.2 GB decoded
+		d, img := open(
+			t,
+			"/Width 40000 /Height 20000 /ColorSpace /DeviceGray"+
+				" /BitsPerComponent 1 /Filter /FlateDecode",
+			flate(make([]byte, 1<<10)),
+		)
+		_, err := d.encodeImage(img)
+		if err != errPDFStreamSize {
+			t.Fatalf("expected %v, got %v", errPDFStreamSize, err)
+		}
+	})
+
+	t.Run("stream over limit", func(t *testing.T) {
+		t.Parallel()
+
+		d, img := open(
+			t,
+			"/Width 1 /Height 1 /ColorSpace /DeviceGray"+
+				" /BitsPerComponent 8 /Filter /FlateDecode",
+			flate(make([]byte, 1<<20)),
+		)
+		_, _, err := d.decodeStream(img.pdfStream, 1<<10, true)
+		if err != errPDFStreamSize {
+			t.Fatalf("expected %v, got %v", errPDFStreamSize, err)
+		}
+	})
+
+	t.Run("document over limit", func(t *testing.T) {
+		t.Parallel()
+
+		d, img := open(
+			t,
+			"/Width 1024 /Height 1024 /ColorSpace /DeviceGray"+
+				" /BitsPerComponent 8 /Filter /FlateDecode",
+			flate(make([]byte, 1<<20)),
+		)
+		// Enough for decoding the stream only once
+		d.budget = 3 << 19
+		_, _, err := d.decodeStream(img.pdfStream, 1<<20, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = d.decodeStream(img.pdfStream, 1<<20, true)
+		if err != errPDFStreamSize {
+			t.Fatalf("expected %v, got %v", errPDFStreamSize, err)
+		}
+	})
+}
+
+func TestPDFRebuildXref(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name  string
+		buf   []byte
+		pages int
+	}{
+		{
+			name: "image",
+			buf: buildImagePDF(
+				"/Width 1 /Height 1 /ColorSpace /DeviceGray"+
+					" /BitsPerComponent 8",
+				[]byte{0},
+			),
+			pages: 1,
+		},
+		{
+			name: "object stream with invalid object count",
+			buf: buildPDF(
+				[]byte("<< /Type /Catalog /Pages 2 0 R >>"),
+				[]byte("<< /Type /Pages /Kids [] /Count 0 >>"),
+				[]byte("<< /Type /ObjStm /N 4611686018427387904 /First 4"+
+					" /Length 8 >>\nstream\n4 0 <<>>\nendstream"),
+			),
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Corrupt the cross-reference table
+			buf := bytes.Replace(c.buf, []byte("xref\n0 "),
+				[]byte("xref\nX "), 1)
+
+			d, err := openPDF(context.Background(), bytes.NewReader(buf),
+				int64(len(buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := d.pageCount(); n != c.pages {
+				t.Fatalf("expected %d pages, got %d", c.pages, n)
+			}
+		})
+	}
+}
+
+func TestProcessPDF(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "sample.pdf")
+	defer f.Close()
+
+	src, thumb, err := Process(f, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Mime != mimePDF {
+		t.Fatalf("unexpected MIME type: %s", src.Mime)
+	}
+	if src.Pages != 2 {
+		t.Fatalf("expected 2 pages, got %d", src.Pages)
+	}
+	// Largest image is nested in a form XObject
+	if src.Dims != (Dims{1280, 720}) {
+		t.Fatalf("unexpected source dimensions: %v", src.Dims)
+	}
+	if src.Title != "Sample 文書" || src.Artist != "Alice, Bob" {
+		t.Fatalf("unexpected metadata: %+v", src.Meta)
+	}
+	writeSample(t, "sample_pdf.png", thumb)
+}
+
+func TestProcessPDFMaxSourceDims(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "sample.pdf")
+	defer f.Close()
+
+	_, _, err := Process(f, Options{
+		MaxSourceDims: Dims{Width: 1000},
+	})
+	if err != ErrTooWide {
+		t.Fatalf("expected %v, got %v", ErrTooWide, err)
+	}
+}
+
+func TestPDFEncryption(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, file string
+		locked     bool
+	}{
+		{"empty user password", "encrypted.pdf", false},
+		{"user password", "password.pdf", true},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := openPDF(context.Background(), f, info.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.locked != c.locked {
+				t.Fatalf("expected locked=%t", c.locked)
+			}
+			if !d.metadata().Encrypted {
+				t.Fatal("not reported as encrypted")
+			}
+			if d.pageCount() != 1 {
+				t.Fatalf("expected 1 page, got %d", d.pageCount())
+			}
+			if c.locked {
+				return
+			}
+
+			if title := d.metadata().Title; title != "Secret" {
+				t.Fatalf("unexpected title: %q", title)
+			}
+		})
+	}
+}
+
+func TestPDFMetadata(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "sample.pdf")
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := openPDF(context.Background(), f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Missing fields of the information dictionary are filled from XMP
+	std := DocumentMeta{
+		Title:    "Sample 文書",
+		Author:   "Alice, Bob",
+		Subject:  "Test document",
+		Producer: "thumbnailer™ test",
+		Created: time.Date(2021, 3, 4, 5, 6, 7, 0,
+			time.FixedZone("", 90*60)),
+	}
+	m := d.metadata()
+	if !m.Created.Equal(std.Created) {
+		t.Fatalf("expected creation date %s, got %s", std.Created, m.Created)
+	}
+	m.Created = std.Created
+	if m != std {
+		t.Fatalf("expected %+v, got %+v", std, m)
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		in  string
+		std time.Time
+	}{
+		{"D:2021", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"D:20210304", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"D:20210304050607Z", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{
+			"D:20210304050607-05'00'",
+			time.Date(2021, 3, 4, 10, 6, 7, 0, time.UTC),
+		},
+		{"20210304050607", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		res, ok := parsePDFDate(c.in)
+		if !ok || !res.Equal(c.std) {
+			t.Errorf("%s: expected %s, got %s", c.in, c.std, res)
+		}
+	}
+
+	if _, ok := parsePDFDate("D:yesterday"); ok {
+		t.Error("invalid date parsed")
+	}
+}
@@ -0,0 +1,392 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+)
+
+// Placeholder is a set of low-quality image placeholders to generate from the
+// thumbnail. Values can be combined with bitwise OR.
+type Placeholder uint8
+
+// Supported placeholder types
+const (
+	// BlurHash with 4x3 components. See https://blurha.sh.
+	PlaceholderBlurHash Placeholder = 1 << iota
+
+	// ThumbHash with alpha channel support. See https://evanw.github.io/thumbhash.
+	PlaceholderThumbHash
+
+	// Tiny PNG thumbnail encoded as a base64 data URI
+	PlaceholderLQIP
+)
+
+const (
+	// ThumbHash is only defined for images up to 100x100
+	maxThumbHashDims = 100
+
+	// Maximum dimensions of LQIP data URI images
+	maxLQIPDims = 16
+
+	blurHashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" +
+		"#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// Generate the requested placeholders from the thumbnail and store them in src
+func generatePlaceholders(src *Source, thumb image.Image, p Placeholder,
+) (err error) {
+	thumb = firstFrame(thumb)
+
+	// Both hashes are computed from small images. Downsample once.
+	var small *image.RGBA
+	if p&(PlaceholderBlurHash|PlaceholderThumbHash) != 0 {
+		small = boxDownsample(thumb, maxThumbHashDims)
+	}
+	if p&PlaceholderBlurHash != 0 {
+		src.BlurHash = blurHash(small, 4, 3)
+	}
+	if p&PlaceholderThumbHash != 0 {
+		src.ThumbHash = thumbHash(small)
+	}
+	if p&PlaceholderLQIP != 0 {
+		var w bytes.Buffer
+		err = png.Encode(&w, boxDownsample(thumb, maxLQIPDims))
+		if err != nil {
+			return
+		}
+		src.LQIP = "data:image/png;base64," +
+			base64.StdEncoding.EncodeToString(w.Bytes())
+	}
+	return
+}
+
+// Scale img down to fit inside a max*max box by averaging pixels.
+// Premultiplied alpha keeps averaging of transparent pixels correct.
+func boxDownsample(img image.Image, max int) *image.RGBA {
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+		b = src.Rect
+	}
+
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	dw, dh := w, h
+	if w >= h {
+		dw, dh = max, h*max/w
+	} else {
+		dw, dh = w*max/h, max
+	}
+	if dw == 0 {
+		dw = 1
+	}
+	if dh == 0 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, (dy+1)*h/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, (dx+1)*w/dw
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				off := src.PixOffset(b.Min.X+x0, b.Min.Y+y)
+				for x := x0; x < x1; x++ {
+					for i := range sum {
+						sum[i] += int(src.Pix[off+i])
+					}
+					off += 4
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			off := dst.PixOffset(dx, dy)
+			for i, s := range sum {
+				dst.Pix[off+i] = uint8(s / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Encode img as a BlurHash with cx*cy components. Transparent regions are
+// treated as black.
+func blurHash(img *image.RGBA, cx, cy int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Linear RGB values of the pixels
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		off := img.PixOffset(b.Min.X, b.Min.Y+y)
+		for x := 0; x < w; x++ {
+			for i := 0; i < 3; i++ {
+				linear[y*w+x][i] = sRGBToLinear(img.Pix[off+i])
+			}
+			off += 4
+		}
+	}
+
+	factors := make([][3]float64, 0, cx*cy)
+	cosX := make([]float64, w)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			for x := range cosX {
+				cosX[x] = math.Cos(math.Pi * float64(i*x) / float64(w))
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cosY := math.Cos(math.Pi * float64(j*y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := cosX[x] * cosY
+					for k, v := range linear[y*w+x] {
+						f[k] += basis * v
+					}
+				}
+			}
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			scale := norm / float64(w*h)
+			for k := range f {
+				f[k] *= scale
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var hash strings.Builder
+	encodeBase83(&hash, (cx-1)+(cy-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) != 0 {
+		var actualMax float64
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0,
+			math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encodeBase83(&hash, quantisedMax, 1)
+	} else {
+		encodeBase83(&hash, 0, 1)
+	}
+
+	encodeBase83(
+		&hash,
+		linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]),
+		4,
+	)
+	for _, f := range ac {
+		var v int
+		for _, c := range f {
+			q := math.Floor(signPow(c/maxValue, 0.5)*9 + 9.5)
+			v = v*19 + int(math.Max(0, math.Min(18, q)))
+		}
+		encodeBase83(&hash, v, 2)
+	}
+
+	return hash.String()
+}
+
+func encodeBase83(w *strings.Builder, val, length int) {
+	for i := 1; i <= length; i++ {
+		digit := val
+		for j := 0; j < length-i; j++ {
+			digit /= 83
+		}
+		w.WriteByte(blurHashChars[digit%83])
+	}
+}
+
+func sRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// Encode an image of at most 100x100 pixels as a ThumbHash.
+// Port of the reference implementation by Evan Wallace.
+func thumbHash(img *image.RGBA) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	n := w * h
+
+	// ThumbHash operates on straight alpha
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	pix := nrgba.Pix
+
+	// Determine the average color
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < n; i++ {
+		alpha := float64(pix[i*4+3]) / 255
+		avgR += alpha / 255 * float64(pix[i*4])
+		avgG += alpha / 255 * float64(pix[i*4+1])
+		avgB += alpha / 255 * float64(pix[i*4+2])
+		avgA += alpha
+	}
+	if avgA != 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0
+	if hasAlpha {
+		// Use fewer luminance bits, if there's alpha
+		lLimit = 5
+	}
+	maxDim := float64(w)
+	if h > w {
+		maxDim = float64(h)
+	}
+	lx := int(math.Max(1, jsRound(lLimit*float64(w)/maxDim)))
+	ly := int(math.Max(1, jsRound(lLimit*float64(h)/maxDim)))
+
+	// Convert the image from RGBA to LPQA (composite atop the average color)
+	l := make([]float64, n) // luminance
+	p := make([]float64, n) // yellow - blue
+	q := make([]float64, n) // red - green
+	a := make([]float64, n) // alpha
+	for i := 0; i < n; i++ {
+		alpha := float64(pix[i*4+3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(pix[i*4])
+		g := avgG*(1-alpha) + alpha/255*float64(pix[i*4+1])
+		b := avgB*(1-alpha) + alpha/255*float64(pix[i*4+2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	// Encode using the DCT into DC (constant) and normalized AC (varying)
+	// terms
+	encodeChannel := func(channel []float64, nx, ny int,
+	) (dc float64, ac []float64, scale float64) {
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				var f float64
+				for x := range fx {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) *
+						(float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) *
+						(float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx != 0 || cy != 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale != 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return
+	}
+	lDC, lAC, lScale := encodeChannel(l, maxInt(3, lx), maxInt(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+	acs := [][]float64{lAC, pAC, qAC}
+	var aDC, aScale float64
+	if hasAlpha {
+		var aAC []float64
+		aDC, aAC, aScale = encodeChannel(a, 5, 5)
+		acs = append(acs, aAC)
+	}
+
+	// Write the constants
+	isLandscape := w > h
+	header24 := int(jsRound(63*lDC)) |
+		int(jsRound(31.5+31.5*pDC))<<6 |
+		int(jsRound(31.5+31.5*qDC))<<12 |
+		int(jsRound(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if isLandscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+
+	acStart := 5
+	acCount := 0
+	for _, ac := range acs {
+		acCount += len(ac)
+	}
+	if hasAlpha {
+		acStart = 6
+	}
+	hash := make([]byte, acStart+(acCount+1)/2)
+	hash[0] = byte(header24)
+	hash[1] = byte(header24 >> 8)
+	hash[2] = byte(header24 >> 16)
+	hash[3] = byte(header16)
+	hash[4] = byte(header16 >> 8)
+	if hasAlpha {
+		hash[5] = byte(int(jsRound(15*aDC)) | int(jsRound(15*aScale))<<4)
+	}
+
+	// Write the varying factors
+	i := 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			hash[acStart+i>>1] |= byte(int(jsRound(15*f)) << ((i & 1) << 2))
+			i++
+		}
+	}
+	return hash
+}
+
+// Rounds half up like JavaScript's Math.round()
+func jsRound(f float64) float64 {
+	return math.Floor(f + 0.5)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,124 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderHashes(t *testing.T) {
+	t.Parallel()
+
+	black := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 3; i < len(black.Pix); i += 4 {
+		black.Pix[i] = 0xff
+	}
+
+	t.Run("blurhash", func(t *testing.T) {
+		t.Parallel()
+
+		const std = "L00000fQfQfQfQfQfQfQfQfQfQfQ"
+		if h := blurHash(black, 4, 3); h != std {
+			t.Fatalf("expected %s, got %s", std, h)
+		}
+	})
+
+	t.Run("thumbhash", func(t *testing.T) {
+		t.Parallel()
+
+		std := make([]byte, 24)
+		copy(std, []byte{0x00, 0x08, 0x02, 0x07, 0x00})
+		if h := thumbHash(black); !bytes.Equal(h, std) {
+			t.Fatalf("expected %v, got %v", std, h)
+		}
+	})
+
+	t.Run("thumbhash alpha", func(t *testing.T) {
+		t.Parallel()
+
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		img.Set(2, 2, color.RGBA{0xff, 0, 0, 0xff})
+		h := thumbHash(img)
+		if h[2]&0x80 == 0 {
+			t.Fatal("alpha bit not set")
+		}
+	})
+}
+
+func TestBoxDownsample(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	small := boxDownsample(img, 100)
+	if b := small.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Fatalf("unexpected dimensions: %v", b)
+	}
+	for _, p := range small.Pix {
+		if p != 0x80 {
+			t.Fatalf("unexpected pixel value: %d", p)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, file string
+	}{
+		{"jpeg", "sample.jpg"},
+		{"transparent png", "sample.png"},
+		{"video", "no_sound.webm"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			src, _, err := Process(f, Options{
+				Placeholder: PlaceholderBlurHash | PlaceholderThumbHash |
+					PlaceholderLQIP,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(src.BlurHash) != 28 {
+				t.Fatalf("invalid BlurHash: %s", src.BlurHash)
+			}
+			if len(src.ThumbHash) < 5 {
+				t.Fatalf("invalid ThumbHash: %v", src.ThumbHash)
+			}
+
+			const prefix = "data:image/png;base64,"
+			if !strings.HasPrefix(src.LQIP, prefix) {
+				t.Fatalf("invalid LQIP: %s", src.LQIP)
+			}
+			buf, err := base64.StdEncoding.DecodeString(
+				src.LQIP[len(prefix):],
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := img.Bounds()
+			if b.Dx() > maxLQIPDims || b.Dy() > maxLQIPDims {
+				t.Fatalf("LQIP too big: %v", b)
+			}
+		})
+	}
+}
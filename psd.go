@@ -0,0 +1,463 @@
+package thumbnailer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+const mimePSD = "image/photoshop"
+
+const (
+	// Composite images with more pixels are not decoded and the embedded
+	// thumbnail is used instead
+	maxPSDPixels = 1 << 26
+
+	// Maximum size of the image resources section read for the embedded
+	// thumbnail and version information
+	maxPSDResourcesSize = 16 << 20
+)
+
+// Supported colour modes of PSD files
+const (
+	psdGrayscale = 1
+	psdRGB       = 3
+	psdCMYK      = 4
+	psdDuotone   = 8
+)
+
+// Image resource IDs
+const (
+	psdResThumbnail   = 1036
+	psdResVersionInfo = 1057
+)
+
+var (
+	errPSDHeader      = ErrInvalidImage("invalid PSD header")
+	errPSDCompression = ErrInvalidImage("unsupported PSD compression")
+	errPSDRLE         = ErrInvalidImage("invalid PSD RLE data")
+)
+
+// Header fields and resources of a PSD or PSB file
+type psdFile struct {
+	large                   bool // PSB file with 64 bit section lengths
+	channels, width, height int
+	depth, mode             int
+
+	// Start of the image data section
+	compositeOffset int64
+
+	// Embedded JPEG thumbnail, if any
+	thumbnail []byte
+
+	// The image data section contains the merged image and not a blank
+	// placeholder
+	hasComposite bool
+
+	// The first extra channel is the transparency of the merged image
+	hasMergedAlpha bool
+}
+
+// Decode the merged composite image of a Photoshop document or fall back to
+// the embedded thumbnail
+func processPSD(rs io.ReadSeeker, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	ra, size, cleanup, err := readerAt(rs, opts)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	f, err := readPSD(ra, size)
+	if err != nil {
+		return
+	}
+	src.Width = uint(f.width)
+	src.Height = uint(f.height)
+	if max := opts.MaxSourceDims.Width; max != 0 && src.Width > max {
+		err = ErrTooWide
+		return
+	}
+	if max := opts.MaxSourceDims.Height; max != 0 && src.Height > max {
+		err = ErrTooTall
+		return
+	}
+
+	var buf []byte
+	if f.canDecode() {
+		var img *image.NRGBA
+		img, err = f.decodeComposite(contextReader{
+			opts.Context(),
+			io.NewSectionReader(ra, f.compositeOffset, size-f.compositeOffset),
+		})
+		if err == nil {
+			buf, err = encodeIntermediate(img)
+		}
+		if ctxErr := opts.Context().Err(); ctxErr != nil {
+			err = ctxErr
+			return
+		}
+	}
+	if buf == nil {
+		if f.thumbnail == nil {
+			if err == nil {
+				err = ErrCantThumbnail
+			}
+			return
+		}
+		buf = f.thumbnail
+	}
+
+	_, thumb, err = processEmbedded(opts.Context(), bytes.NewReader(buf), src,
+		opts)
+	return
+}
+
+// Read the file header and the sections preceding the image data section
+func readPSD(ra io.ReaderAt, size int64) (f psdFile, err error) {
+	var off int64
+	read := func(n int64) (buf []byte, err error) {
+		if n < 0 || n > size-off {
+			return nil, io.ErrUnexpectedEOF
+		}
+		buf = make([]byte, n)
+		_, err = ra.ReadAt(buf, off)
+		if err == io.EOF {
+			err = nil
+		}
+		off += n
+		return
+	}
+	// Section lengths are 64 bit for some sections of PSB files
+	length := func(large bool) (int64, error) {
+		if large {
+			buf, err := read(8)
+			if err != nil {
+				return 0, err
+			}
+			n := binary.BigEndian.Uint64(buf)
+			if n > uint64(size) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return int64(n), nil
+		}
+		buf, err := read(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.BigEndian.Uint32(buf)), nil
+	}
+
+	buf, err := read(26)
+	if err != nil {
+		return
+	}
+	be := binary.BigEndian
+	if !bytes.HasPrefix(buf, []byte("8BPS")) {
+		err = errPSDHeader
+		return
+	}
+	maxDims := uint32(30000)
+	switch be.Uint16(buf[4:]) {
+	case 1:
+	case 2:
+		f.large = true
+		maxDims = 300000
+	default:
+		err = errPSDHeader
+		return
+	}
+	f.channels = int(be.Uint16(buf[12:]))
+	height := be.Uint32(buf[14:])
+	width := be.Uint32(buf[18:])
+	f.depth = int(be.Uint16(buf[22:]))
+	f.mode = int(be.Uint16(buf[24:]))
+	if f.channels < 1 || f.channels > 56 || width == 0 || height == 0 ||
+		width > maxDims || height > maxDims {
+		err = errPSDHeader
+		return
+	}
+	f.width = int(width)
+	f.height = int(height)
+
+	// Colour mode data
+	n, err := length(false)
+	if err != nil {
+		return
+	}
+	off += n
+
+	// Image resources
+	n, err = length(false)
+	if err != nil {
+		return
+	}
+	f.hasComposite = true
+	if n <= maxPSDResourcesSize {
+		buf, err = read(n)
+		if err != nil {
+			return
+		}
+		f.readResources(buf)
+	} else {
+		off += n
+	}
+
+	// Layer and mask information. A negative layer count denotes the first
+	// alpha channel contains the transparency of the merged image.
+	n, err = length(f.large)
+	if err != nil {
+		return
+	}
+	end := off + n
+	if n != 0 {
+		var layers int64
+		layers, err = length(f.large)
+		if err != nil {
+			return
+		}
+		if layers >= 2 {
+			buf, err = read(2)
+			if err != nil {
+				return
+			}
+			f.hasMergedAlpha = int16(be.Uint16(buf)) < 0
+		}
+	}
+	f.compositeOffset = end
+	return
+}
+
+// Extract the embedded JPEG thumbnail and whether the image data section
+// contains the actual merged image from the image resource blocks
+func (f *psdFile) readResources(buf []byte) {
+	be := binary.BigEndian
+	for len(buf) >= 12 && bytes.HasPrefix(buf, []byte("8BIM")) {
+		id := be.Uint16(buf[4:])
+
+		// Pascal string name padded to an even length
+		i := 6 + 1 + int(buf[6])
+		i += i & 1
+		if i+4 > len(buf) {
+			return
+		}
+		n := int(be.Uint32(buf[i:]))
+		i += 4
+		if n < 0 || n > len(buf)-i {
+			return
+		}
+		data := buf[i : i+n]
+		buf = buf[i+n:]
+		if n&1 != 0 && len(buf) != 0 {
+			buf = buf[1:]
+		}
+
+		switch id {
+		case psdResThumbnail:
+			// Header of the thumbnail resource followed by JFIF data
+			if len(data) > 28 && be.Uint32(data) == 1 {
+				f.thumbnail = data[28:]
+			}
+		case psdResVersionInfo:
+			// Files saved without maximized compatibility contain only a
+			// blank placeholder image
+			if len(data) >= 5 {
+				f.hasComposite = data[4] != 0
+			}
+		}
+	}
+}
+
+// Returns the number of colour channels of the colour mode or 0, if the
+// colour mode is not supported
+func (f *psdFile) colorChannels() int {
+	switch f.mode {
+	case psdGrayscale, psdDuotone:
+		// Duotone images are stored as grayscale
+		return 1
+	case psdRGB:
+		return 3
+	case psdCMYK:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// Returns, if the merged composite image can be decoded
+func (f *psdFile) canDecode() bool {
+	n := f.colorChannels()
+	return f.hasComposite && n != 0 && n <= f.channels &&
+		(f.depth == 8 || f.depth == 16) &&
+		int64(f.width)*int64(f.height) <= maxPSDPixels
+}
+
+// Decode the image data section, which stores each channel of the merged
+// image in sequence
+func (f *psdFile) decodeComposite(r io.Reader) (img *image.NRGBA, err error) {
+	br := bufio.NewReader(r)
+	var comp uint16
+	err = binary.Read(br, binary.BigEndian, &comp)
+	if err != nil {
+		return
+	}
+
+	colors := f.colorChannels()
+	channels := colors
+	if f.hasMergedAlpha && f.channels > colors {
+		channels++
+	}
+	countSize := 2
+	if f.large {
+		countSize = 4
+	}
+	var (
+		rowLen = f.width * f.depth / 8
+		row    = make([]byte, rowLen)
+		counts []byte
+		packed []byte
+	)
+	switch comp {
+	case 0: // Raw
+	case 1: // PackBits
+		// Byte counts of the rows of all channels precede the data
+		counts = make([]byte, channels*f.height*countSize)
+		_, err = io.ReadFull(br, counts)
+		if err != nil {
+			return
+		}
+		_, err = io.CopyN(ioutil.Discard, br,
+			int64((f.channels-channels)*f.height*countSize))
+		if err != nil {
+			return
+		}
+	default:
+		return nil, errPSDCompression
+	}
+
+	// Channels are decoded directly into the components of the image. Only
+	// the alpha channel of CMYK images needs to be stored separately.
+	img = image.NewNRGBA(image.Rect(0, 0, f.width, f.height))
+	var alpha []byte
+	if channels == 5 {
+		alpha = make([]byte, f.width*f.height)
+	}
+	for c := 0; c < channels; c++ {
+		for y := 0; y < f.height; y++ {
+			if counts == nil {
+				_, err = io.ReadFull(br, row)
+			} else {
+				i := (c*f.height + y) * countSize
+				var n int
+				if countSize == 2 {
+					n = int(binary.BigEndian.Uint16(counts[i:]))
+				} else {
+					n = int(binary.BigEndian.Uint32(counts[i:]))
+				}
+				if n < 0 || n > 2*rowLen+128 {
+					return nil, errPSDRLE
+				}
+				if cap(packed) < n {
+					packed = make([]byte, n)
+				}
+				packed = packed[:n]
+				_, err = io.ReadFull(br, packed)
+				if err == nil {
+					err = unpackBits(row, packed)
+				}
+			}
+			if err != nil {
+				return
+			}
+
+			// 16 bit samples are reduced to their most significant byte
+			step := f.depth / 8
+			if c == 4 {
+				dst := alpha[y*f.width : (y+1)*f.width]
+				for x := range dst {
+					dst[x] = row[x*step]
+				}
+			} else {
+				dst := img.Pix[y*img.Stride:]
+				slot := c
+				if c == colors {
+					slot = 3
+				}
+				for x := 0; x < f.width; x++ {
+					dst[4*x+slot] = row[x*step]
+				}
+			}
+		}
+	}
+
+	f.compose(img, channels > colors, alpha)
+	return
+}
+
+// Decompress PackBits run-length encoded data into dst, which must be filled
+// exactly
+func unpackBits(dst, src []byte) error {
+	var i int
+	for len(src) != 0 {
+		n := int(int8(src[0]))
+		src = src[1:]
+		switch {
+		case n >= 0:
+			n++
+			if n > len(src) || n > len(dst)-i {
+				return errPSDRLE
+			}
+			i += copy(dst[i:], src[:n])
+			src = src[n:]
+		case n != -128:
+			n = 1 - n
+			if len(src) == 0 || n > len(dst)-i {
+				return errPSDRLE
+			}
+			for end := i + n; i < end; i++ {
+				dst[i] = src[0]
+			}
+			src = src[1:]
+		}
+	}
+	if i != len(dst) {
+		return errPSDRLE
+	}
+	return nil
+}
+
+// Convert the decoded colour components of each pixel to RGBA in place. The
+// alpha channel of CMYK images is passed separately.
+func (f *psdFile) compose(img *image.NRGBA, hasAlpha bool, alpha []byte) {
+	for i := 0; i < f.width*f.height; i++ {
+		p := img.Pix[4*i : 4*i+4]
+		switch f.mode {
+		case psdCMYK:
+			// Ink coverage is stored inverted
+			p[0], p[1], p[2] = color.CMYKToRGB(^p[0], ^p[1], ^p[2], ^p[3])
+			if alpha != nil {
+				p[3] = alpha[i]
+			}
+		case psdGrayscale, psdDuotone:
+			p[1], p[2] = p[0], p[0]
+		}
+		if !hasAlpha {
+			p[3] = 0xff
+		} else if p[3] != 0 && p[3] != 0xff {
+			// Remove the white background translucent pixels of the merged
+			// image are blended with
+			a := int(p[3])
+			for j := 0; j < 3; j++ {
+				v := (int(p[j]) - (0xff - a)) * 0xff / a
+				if v < 0 {
+					v = 0
+				}
+				p[j] = uint8(v)
+			}
+		}
+	}
+}
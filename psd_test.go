@@ -0,0 +1,322 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// Build a PSD or, if large, PSB file from the passed header fields, image
+// resources, layer count and image data section
+func buildPSD(large bool, mode, depth, channels, w, h int, resources []byte,
+	layers int16, data []byte,
+) []byte {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	length := func(n int) {
+		if large {
+			write(uint64(n))
+		} else {
+			write(uint32(n))
+		}
+	}
+
+	buf.WriteString("8BPS")
+	if large {
+		write(uint16(2))
+	} else {
+		write(uint16(1))
+	}
+	buf.Write(make([]byte, 6))
+	write(uint16(channels))
+	write(uint32(h))
+	write(uint32(w))
+	write(uint16(depth))
+	write(uint16(mode))
+
+	write(uint32(0)) // Colour mode data
+	write(uint32(len(resources)))
+	buf.Write(resources)
+
+	if layers == 0 {
+		length(0)
+	} else {
+		// Layer info section with only the layer count
+		if large {
+			length(8 + 2)
+		} else {
+			length(4 + 2)
+		}
+		length(2)
+		write(layers)
+	}
+
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// Encode an image resource block
+func psdResource(id uint16, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("8BIM")
+	binary.Write(&buf, binary.BigEndian, id)
+	buf.Write([]byte{0, 0}) // Empty name
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// Build a raw image data section from channel planes
+func psdRaw(planes ...[]byte) []byte {
+	data := []byte{0, 0}
+	for _, p := range planes {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestUnpackBits(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name     string
+		src, std []byte
+		err      error
+	}{
+		{"literal", []byte{2, 1, 2, 3}, []byte{1, 2, 3}, nil},
+		{"run", []byte{0xfe, 7}, []byte{7, 7, 7}, nil},
+		{"no-op", []byte{0x80, 0xff, 9, 0, 1}, []byte{9, 9, 1}, nil},
+		{"overflow", []byte{0xfd, 7}, make([]byte, 3), errPSDRLE},
+		{"underflow", []byte{0, 1}, make([]byte, 3), errPSDRLE},
+		{"truncated", []byte{2, 1}, make([]byte, 3), errPSDRLE},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := make([]byte, len(c.std))
+			err := unpackBits(dst, c.src)
+			if err != c.err {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if err == nil && !bytes.Equal(dst, c.std) {
+				t.Fatalf("expected %v, got %v", c.std, dst)
+			}
+		})
+	}
+}
+
+func TestPSDComposite(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name string
+		file []byte
+		std  [2]color.NRGBA // First and last pixel
+	}{
+		{
+			name: "RGB",
+			file: buildPSD(false, psdRGB, 8, 3, 2, 1, nil, 0,
+				psdRaw([]byte{0xff, 0}, []byte{0, 0}, []byte{0, 0xff})),
+			std: [2]color.NRGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}},
+		},
+		{
+			name: "RLE grayscale",
+			file: buildPSD(false, psdGrayscale, 8, 1, 3, 2, nil, 0,
+				[]byte{0, 1, 0, 2, 0, 2, 0xfe, 0x40, 0xfe, 0x80}),
+			std: [2]color.NRGBA{
+				{0x40, 0x40, 0x40, 0xff},
+				{0x80, 0x80, 0x80, 0xff},
+			},
+		},
+		{
+			name: "PSB RLE grayscale",
+			file: buildPSD(true, psdGrayscale, 8, 1, 3, 1, nil, 0,
+				[]byte{0, 1, 0, 0, 0, 2, 0xfe, 0x40}),
+			std: [2]color.NRGBA{
+				{0x40, 0x40, 0x40, 0xff},
+				{0x40, 0x40, 0x40, 0xff},
+			},
+		},
+		{
+			name: "16 bit grayscale",
+			file: buildPSD(false, psdGrayscale, 16, 1, 2, 1, nil, 0,
+				psdRaw([]byte{0x12, 0x34, 0xab, 0xcd})),
+			std: [2]color.NRGBA{
+				{0x12, 0x12, 0x12, 0xff},
+				{0xab, 0xab, 0xab, 0xff},
+			},
+		},
+		{
+			name: "CMYK",
+			file: buildPSD(false, psdCMYK, 8, 4, 1, 1, nil, 0,
+				psdRaw([]byte{0xff}, []byte{0}, []byte{0}, []byte{0xff})),
+			std: [2]color.NRGBA{{0xff, 0, 0, 0xff}, {0xff, 0, 0, 0xff}},
+		},
+		{
+			name: "extra channel without merged alpha",
+			file: buildPSD(false, psdRGB, 8, 4, 1, 1, nil, 0,
+				psdRaw([]byte{1}, []byte{2}, []byte{3}, []byte{0})),
+			std: [2]color.NRGBA{{1, 2, 3, 0xff}, {1, 2, 3, 0xff}},
+		},
+		{
+			name: "merged alpha",
+			file: buildPSD(false, psdRGB, 8, 4, 2, 1, nil, -1,
+				psdRaw(
+					[]byte{0xff, 0xff},
+					[]byte{0, 0xff},
+					[]byte{0, 0xff},
+					[]byte{0xff, 0},
+				)),
+			std: [2]color.NRGBA{{0xff, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0}},
+		},
+		{
+			name: "CMYK merged alpha",
+			file: buildPSD(false, psdCMYK, 8, 5, 1, 1, nil, -1,
+				psdRaw(
+					[]byte{0xff}, []byte{0xff}, []byte{0xff}, []byte{0},
+					[]byte{0xff},
+				)),
+			std: [2]color.NRGBA{{0, 0, 0, 0xff}, {0, 0, 0, 0xff}},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := readPSD(bytes.NewReader(c.file), int64(len(c.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.canDecode() {
+				t.Fatal("can not decode composite")
+			}
+			img, err := f.decodeComposite(
+				bytes.NewReader(c.file[f.compositeOffset:]),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b := img.Bounds()
+			res := [2]color.NRGBA{
+				img.NRGBAAt(0, 0),
+				img.NRGBAAt(b.Max.X-1, b.Max.Y-1),
+			}
+			if res != c.std {
+				t.Fatalf("expected %v, got %v", c.std, res)
+			}
+		})
+	}
+}
+
+// Encode a JPEG image resource of a PSD file
+func psdThumbnail(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var w bytes.Buffer
+	if err := jpeg.Encode(&w, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 28)
+	binary.BigEndian.PutUint32(data, 1)
+	return psdResource(psdResThumbnail, append(data, w.Bytes()...))
+}
+
+func TestPSDNoComposite(t *testing.T) {
+	t.Parallel()
+
+	res := append(
+		psdResource(psdResVersionInfo, []byte{0, 0, 0, 1, 0}),
+		psdThumbnail(t)...,
+	)
+	buf := buildPSD(false, psdRGB, 8, 3, 1, 1, res, 0,
+		psdRaw([]byte{0xff}, []byte{0xff}, []byte{0xff}))
+
+	f, err := readPSD(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.canDecode() {
+		t.Fatal("blank composite not detected")
+	}
+	if !bytes.HasPrefix(f.thumbnail, []byte("\xFF\xD8\xFF")) {
+		t.Fatal("thumbnail not extracted")
+	}
+}
+
+func TestProcessPSD(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name  string
+		file  []byte
+		thumb Dims
+	}{
+		{
+			name: "composite",
+			file: buildPSD(false, psdRGB, 8, 3, 200, 100, nil, 0,
+				psdRaw(
+					make([]byte, 200*100),
+					make([]byte, 200*100),
+					make([]byte, 200*100),
+				)),
+			thumb: Dims{150, 75},
+		},
+		{
+			name: "thumbnail fallback",
+			file: buildPSD(false, psdRGB, 32, 3, 200, 100, psdThumbnail(t),
+				0, nil),
+			thumb: Dims{32, 16},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			src, thumb, err := Process(bytes.NewReader(c.file), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Mime != mimePSD {
+				t.Fatalf("unexpected MIME type: %s", src.Mime)
+			}
+			if src.Dims != (Dims{200, 100}) {
+				t.Fatalf("unexpected source dimensions: %v", src.Dims)
+			}
+			m := thumb.Bounds().Max
+			if uint(m.X) != c.thumb.Width || uint(m.Y) != c.thumb.Height {
+				t.Fatalf("unexpected thumbnail dimensions: %v", m)
+			}
+		})
+	}
+}
+
+func TestProcessPSDNoThumbnail(t *testing.T) {
+	t.Parallel()
+
+	buf := buildPSD(false, 2, 8, 1, 1, 1, nil, 0, psdRaw([]byte{0}))
+	_, _, err := Process(bytes.NewReader(buf), Options{})
+	if err != ErrCantThumbnail {
+		t.Fatalf("expected %v, got %v", ErrCantThumbnail, err)
+	}
+}
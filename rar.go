@@ -0,0 +1,414 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	rar4Signature = "Rar!\x1A\x07\x00"
+	rar5Signature = "Rar!\x1A\x07\x01\x00"
+
+	// Maximum size of a RAR header. Larger headers are invalid.
+	maxRarHeaderSize = 2 << 20
+
+	// RAR 4 block types
+	rar4Archive = 0x73
+	rar4File    = 0x74
+	rar4End     = 0x7B
+
+	// RAR 5 header types
+	rar5Archive    = 1
+	rar5File       = 2
+	rar5Encryption = 4
+	rar5End        = 5
+)
+
+var errRarHeader = ErrArchive{errors.New("invalid RAR header")}
+
+// File header of a RAR archive
+type rarFile struct {
+	name             string
+	packedSize, size int64
+	modified         time.Time
+	isDir, encrypted bool
+}
+
+// Headers of a RAR archive read without decompressing any content
+type rarArchive struct {
+	files []rarFile
+
+	// Headers following the archive header are encrypted and can not be read
+	// without a password
+	encrypted bool
+
+	// Archive is a volume of a multi-volume archive
+	volume bool
+}
+
+// Read the headers of the first maxFiles files of a RAR archive. Unlike
+// rardecode, headers of encrypted files are read without a password.
+func scanRar(ctx context.Context, rs io.ReadSeeker, maxFiles int,
+) (a rarArchive, err error) {
+	_, err = rs.Seek(0, 0)
+	if err != nil {
+		return
+	}
+	var sig [len(rar5Signature)]byte
+	_, err = io.ReadFull(rs, sig[:len(rar4Signature)])
+	if err != nil {
+		return
+	}
+	if string(sig[:len(rar4Signature)]) == rar4Signature {
+		err = scanRar4(ctx, rs, maxFiles, &a)
+		return
+	}
+	_, err = io.ReadFull(rs, sig[len(rar4Signature):])
+	if err != nil {
+		return
+	}
+	if string(sig[:]) != rar5Signature {
+		err = errRarHeader
+		return
+	}
+	err = scanRar5(ctx, rs, maxFiles, &a)
+	return
+}
+
+// Read the blocks of a RAR 4 archive following the signature
+func scanRar4(ctx context.Context, rs io.ReadSeeker, maxFiles int,
+	a *rarArchive,
+) (err error) {
+	for len(a.files) < maxFiles {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var base [7]byte
+		_, err = io.ReadFull(rs, base[:])
+		switch err {
+		case nil:
+		case io.EOF:
+			// Archives without an end block
+			return nil
+		default:
+			return
+		}
+		typ := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:])
+		headerSize := binary.LittleEndian.Uint16(base[5:])
+		if headerSize < uint16(len(base)) {
+			return errRarHeader
+		}
+		h := rarBuf{data: make([]byte, headerSize-uint16(len(base)))}
+		_, err = io.ReadFull(rs, h.data)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		var dataSize uint64
+		if flags&0x8000 != 0 || typ == rar4File {
+			dataSize = uint64(h.uint32())
+		}
+		switch typ {
+		case rar4Archive:
+			a.volume = flags&0x0001 != 0
+			if flags&0x0080 != 0 {
+				a.encrypted = true
+				return
+			}
+		case rar4File:
+			size := uint64(h.uint32())
+			h.bytes(5) // Host OS and CRC32
+			mtime := h.uint32()
+			h.bytes(2) // Version and method
+			nameSize := h.uint16()
+			h.uint32() // Attributes
+			if flags&0x0100 != 0 {
+				dataSize |= uint64(h.uint32()) << 32
+				size |= uint64(h.uint32()) << 32
+			}
+			name := h.bytes(int(nameSize))
+			if flags&0x0200 != 0 {
+				// Unicode names are appended to the name in the OEM code page
+				// of the archiver after a null byte
+				if i := bytes.IndexByte(name, 0); i != -1 {
+					name = name[:i]
+				}
+			}
+
+			// Skip continuations of files from previous volumes
+			if flags&0x0001 == 0 {
+				a.files = append(a.files, rarFile{
+					name: strings.Replace(
+						decodeName(string(name)), "\\", "/", -1,
+					),
+					packedSize: int64(dataSize),
+					size:       int64(size),
+					modified:   dosTime(mtime),
+					isDir:      flags&0x00E0 == 0x00E0,
+					encrypted:  flags&0x0004 != 0,
+				})
+			}
+		case rar4End:
+			return
+		}
+		if h.short {
+			return errRarHeader
+		}
+
+		err = skipRarData(rs, dataSize)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Read the headers of a RAR 5 archive following the signature
+func scanRar5(ctx context.Context, rs io.ReadSeeker, maxFiles int,
+	a *rarArchive,
+) (err error) {
+	for len(a.files) < maxFiles {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var h rarBuf
+		h, err = readRar5Header(rs)
+		switch err {
+		case nil:
+		case io.EOF:
+			// Archives without an end header
+			return nil
+		default:
+			return
+		}
+
+		typ := h.vint()
+		flags := h.vint()
+		var extraSize, dataSize uint64
+		if flags&0x0001 != 0 {
+			extraSize = h.vint()
+		}
+		if flags&0x0002 != 0 {
+			dataSize = h.vint()
+		}
+		if extraSize > uint64(len(h.data)) {
+			return errRarHeader
+		}
+		extra := rarBuf{data: h.data[len(h.data)-int(extraSize):]}
+		h.data = h.data[:len(h.data)-int(extraSize)]
+
+		switch typ {
+		case rar5Archive:
+			a.volume = h.vint()&0x0001 != 0
+		case rar5File:
+			f := parseRar5File(&h, &extra)
+			f.packedSize = int64(dataSize)
+
+			// Skip continuations of files from previous volumes
+			if flags&0x0008 == 0 {
+				a.files = append(a.files, f)
+			}
+		case rar5Encryption:
+			a.encrypted = true
+			return
+		case rar5End:
+			return
+		}
+		if h.short || extra.short {
+			return errRarHeader
+		}
+
+		err = skipRarData(rs, dataSize)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Read the next header of a RAR 5 archive. Returns io.EOF, if there are no
+// more headers.
+func readRar5Header(r io.Reader) (h rarBuf, err error) {
+	// Skip CRC32
+	var b [4]byte
+	_, err = io.ReadFull(r, b[:])
+	if err != nil {
+		return
+	}
+
+	// Header size is a variable length integer of at most 3 bytes
+	var size uint64
+	for i := uint(0); ; i += 7 {
+		if i == 21 {
+			err = errRarHeader
+			return
+		}
+		_, err = io.ReadFull(r, b[:1])
+		if err != nil {
+			err = unexpectedEOF(err)
+			return
+		}
+		size |= uint64(b[0]&0x7F) << i
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	if size == 0 || size > maxRarHeaderSize {
+		err = errRarHeader
+		return
+	}
+
+	h.data = make([]byte, size)
+	_, err = io.ReadFull(r, h.data)
+	if err != nil {
+		err = unexpectedEOF(err)
+	}
+	return
+}
+
+// Parse the RAR 5 file header following the common header fields
+func parseRar5File(h, extra *rarBuf) (f rarFile) {
+	flags := h.vint()
+	f.isDir = flags&0x0001 != 0
+	size := h.vint()
+	if flags&0x0008 == 0 {
+		f.size = int64(size)
+	}
+	h.vint() // Attributes
+	if flags&0x0002 != 0 {
+		f.modified = time.Unix(int64(h.uint32()), 0)
+	}
+	if flags&0x0004 != 0 {
+		h.uint32() // CRC32
+	}
+	h.vint() // Compression information
+	h.vint() // Host OS
+	f.name = string(h.bytes(int(h.vint())))
+
+	for len(extra.data) != 0 && !extra.short {
+		rec := rarBuf{data: extra.bytes(int(extra.vint()))}
+		switch rec.vint() {
+		case 1: // Encryption
+			f.encrypted = true
+		case 3: // High precision time
+			flags := rec.vint()
+			if flags&0x0002 == 0 {
+				break
+			}
+			if flags&0x0001 != 0 {
+				f.modified = time.Unix(int64(rec.uint32()), 0)
+			} else {
+				f.modified = windowsTime(rec.uint64())
+			}
+		}
+		extra.short = extra.short || rec.short
+	}
+	return
+}
+
+// Skip the data area following a RAR header
+func skipRarData(rs io.Seeker, size uint64) (err error) {
+	if size > 1<<62 {
+		return errRarHeader
+	}
+	_, err = rs.Seek(int64(size), io.SeekCurrent)
+	return
+}
+
+// Replace io.EOF inside a header with io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Convert an MS-DOS date and time
+func dosTime(t uint32) time.Time {
+	return time.Date(
+		int(t>>25)+1980,
+		time.Month(t>>21&0xF),
+		int(t>>16&0x1F),
+		int(t>>11&0x1F),
+		int(t>>5&0x3F),
+		int(t&0x1F)*2,
+		0,
+		time.UTC,
+	)
+}
+
+// Convert a Windows FILETIME of 100 nanosecond intervals since 1601
+func windowsTime(t uint64) time.Time {
+	const unixEpoch = 116444736000000000
+	if t < unixEpoch {
+		return time.Time{}
+	}
+	t -= unixEpoch
+	return time.Unix(int64(t/1e7), int64(t%1e7)*100)
+}
+
+// Reader of RAR header fields. Reading past the end of the header yields
+// zero values and sets short.
+type rarBuf struct {
+	data  []byte
+	short bool
+}
+
+func (b *rarBuf) bytes(n int) []byte {
+	if n < 0 || n > len(b.data) {
+		b.data = nil
+		b.short = true
+		return nil
+	}
+	s := b.data[:n]
+	b.data = b.data[n:]
+	return s
+}
+
+func (b *rarBuf) uint16() uint16 {
+	s := b.bytes(2)
+	if s == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(s)
+}
+
+func (b *rarBuf) uint32() uint32 {
+	s := b.bytes(4)
+	if s == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(s)
+}
+
+func (b *rarBuf) uint64() uint64 {
+	s := b.bytes(8)
+	if s == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(s)
+}
+
+// Read a variable length integer of RAR 5 headers
+func (b *rarBuf) vint() (n uint64) {
+	for i := uint(0); i < 64; i += 7 {
+		s := b.bytes(1)
+		if s == nil {
+			return 0
+		}
+		n |= uint64(s[0]&0x7F) << i
+		if s[0]&0x80 == 0 {
+			return
+		}
+	}
+	b.short = true
+	return 0
+}
@@ -0,0 +1,316 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// Encode a variable length integer of RAR 5 headers
+func rarVint(n uint64) []byte {
+	var b []byte
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+// Build a RAR 5 header with the passed type specific fields, extra area and
+// data
+func buildRar5Header(typ, flags uint64, fields, extra, data []byte) []byte {
+	if len(extra) != 0 {
+		flags |= 0x0001
+	}
+	if len(data) != 0 {
+		flags |= 0x0002
+	}
+	h := append(rarVint(typ), rarVint(flags)...)
+	if len(extra) != 0 {
+		h = append(h, rarVint(uint64(len(extra)))...)
+	}
+	if len(data) != 0 {
+		h = append(h, rarVint(uint64(len(data)))...)
+	}
+	h = append(h, fields...)
+	h = append(h, extra...)
+
+	h = append(rarVint(uint64(len(h))), h...)
+	b := make([]byte, 4, 4+len(h)+len(data))
+	binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(h))
+	b = append(b, h...)
+	return append(b, data...)
+}
+
+// Build a RAR 5 file header with a modification time and optional extra area
+func buildRar5File(name string, fileFlags, flags uint64, mtime uint32,
+	extra []byte, data string,
+) []byte {
+	f := rarVint(fileFlags | 0x0002)
+	f = append(f, rarVint(uint64(len(data)))...)
+	f = append(f, 0) // Attributes
+	var t [4]byte
+	binary.LittleEndian.PutUint32(t[:], mtime)
+	f = append(f, t[:]...)
+	f = append(f, 0, 0) // Compression information and host OS
+	f = append(f, rarVint(uint64(len(name)))...)
+	f = append(f, name...)
+	return buildRar5Header(rar5File, flags, f, extra, []byte(data))
+}
+
+// Build a RAR 5 extra area record
+func buildRar5Record(typ uint64, data []byte) []byte {
+	rec := append(rarVint(typ), data...)
+	return append(rarVint(uint64(len(rec))), rec...)
+}
+
+// Build a RAR 4 file block
+func buildRar4File(name string, flags uint16, mtime uint32, data string) []byte {
+	f := make([]byte, 25)
+	binary.LittleEndian.PutUint32(f[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(f[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(f[9:], crc32.ChecksumIEEE([]byte(data)))
+	binary.LittleEndian.PutUint32(f[13:], mtime)
+	binary.LittleEndian.PutUint16(f[19:], uint16(len(name)))
+	f = append(f, name...)
+	return append(buildRar4Block(rar4File, flags|0x8000, f), data...)
+}
+
+// Build a RAR 4 block without data
+func buildRar4Block(typ byte, flags uint16, fields []byte) []byte {
+	b := make([]byte, 7, 7+len(fields))
+	b[2] = typ
+	binary.LittleEndian.PutUint16(b[3:], flags)
+	binary.LittleEndian.PutUint16(b[5:], uint16(7+len(fields)))
+	b = append(b, fields...)
+	binary.LittleEndian.PutUint16(b, uint16(crc32.ChecksumIEEE(b[2:])))
+	return b
+}
+
+func TestScanRar(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtime = time.Date(2019, 2, 1, 12, 30, 0, 0, time.UTC)
+		// 2019-02-01 12:30:00 in MS-DOS format
+		dosMtime = uint32(39<<25 | 2<<21 | 1<<16 | 12<<11 | 30<<5)
+		// 2019-02-01 12:30:00 as a Windows FILETIME
+		fileTime = uint64(mtime.Unix())*1e7 + 116444736000000000
+	)
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	htime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(htime, fileTime)
+
+	cases := [...]struct {
+		name              string
+		archive           []byte
+		encrypted, volume bool
+		files             []rarFile
+	}{
+		{
+			name:    "sample",
+			archive: readSample(t, "sample.rar"),
+			files: []rarFile{
+				{
+					name:       "sample.png",
+					packedSize: 617084,
+					size:       634670,
+					modified: time.Date(2019, 2, 1, 22, 3, 51, 0,
+						time.UTC),
+				},
+			},
+		},
+		{
+			name: "RAR 5",
+			archive: join(
+				[]byte(rar5Signature),
+				buildRar5Header(rar5Archive, 0, []byte{0}, nil, nil),
+				buildRar5File("pages", 0x0001, 0, uint32(mtime.Unix()), nil, ""),
+				buildRar5File("pages/01.png", 0, 0, uint32(mtime.Unix()), nil,
+					"page"),
+				buildRar5File("pages/02.png", 0, 0, 0,
+					join(
+						buildRar5Record(1, make([]byte, 8)),
+						buildRar5Record(3, append(rarVint(0x0002), htime...)),
+					),
+					"encrypted page",
+				),
+				// Continuation of a file from a previous volume
+				buildRar5File("pages/03.png", 0, 0x0008, 0, nil, "page"),
+				buildRar5Header(rar5End, 0, []byte{0}, nil, nil),
+				[]byte("trailing data"),
+			),
+			files: []rarFile{
+				{
+					name:     "pages",
+					modified: mtime,
+					isDir:    true,
+				},
+				{
+					name:       "pages/01.png",
+					packedSize: 4,
+					size:       4,
+					modified:   mtime,
+				},
+				{
+					name:       "pages/02.png",
+					packedSize: 14,
+					size:       14,
+					modified:   mtime,
+					encrypted:  true,
+				},
+			},
+		},
+		{
+			name: "RAR 5 encrypted headers",
+			archive: join(
+				[]byte(rar5Signature),
+				buildRar5Header(rar5Encryption, 0, make([]byte, 18), nil, nil),
+				[]byte("encrypted headers"),
+			),
+			encrypted: true,
+		},
+		{
+			name: "RAR 4",
+			archive: join(
+				[]byte(rar4Signature),
+				buildRar4Block(rar4Archive, 0, make([]byte, 6)),
+				buildRar4File("pages", 0x00E0, dosMtime, ""),
+				buildRar4File("pages\\01.png", 0, dosMtime, "page"),
+				buildRar4File(
+					"pages\\\x95\x5c\x8e\x86.png\x00\x00\x00",
+					0x0200|0x0004,
+					dosMtime,
+					"encrypted page",
+				),
+				// Continuation of a file from a previous volume
+				buildRar4File("pages\\03.png", 0x0001, dosMtime, "page"),
+				buildRar4Block(rar4End, 0, nil),
+			),
+			files: []rarFile{
+				{
+					name:     "pages",
+					modified: mtime,
+					isDir:    true,
+				},
+				{
+					name:       "pages/01.png",
+					packedSize: 4,
+					size:       4,
+					modified:   mtime,
+				},
+				{
+					name:       "pages/表紙.png",
+					packedSize: 14,
+					size:       14,
+					modified:   mtime,
+					encrypted:  true,
+				},
+			},
+		},
+		{
+			name: "RAR 5 volume",
+			archive: join(
+				[]byte(rar5Signature),
+				buildRar5Header(rar5Archive, 0, []byte{0x1}, nil, nil),
+				buildRar5File("01.png", 0, 0x0010, 0, nil, "page"),
+			),
+			volume: true,
+			files: []rarFile{
+				{
+					name:       "01.png",
+					packedSize: 4,
+					size:       4,
+					modified:   time.Unix(0, 0),
+				},
+			},
+		},
+		{
+			name: "RAR 4 volume",
+			archive: join(
+				[]byte(rar4Signature),
+				buildRar4Block(rar4Archive, 0x0001, make([]byte, 6)),
+			),
+			volume: true,
+		},
+		{
+			name: "RAR 4 encrypted headers",
+			archive: join(
+				[]byte(rar4Signature),
+				buildRar4Block(rar4Archive, 0x0080, make([]byte, 6)),
+				[]byte("encrypted headers"),
+			),
+			encrypted: true,
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := scanRar(
+				context.Background(),
+				bytes.NewReader(c.archive),
+				maxArchiveEntries,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.encrypted != c.encrypted {
+				t.Fatalf("expected encrypted %v, got %v", c.encrypted,
+					a.encrypted)
+			}
+			if a.volume != c.volume {
+				t.Fatalf("expected volume %v, got %v", c.volume, a.volume)
+			}
+			if len(a.files) != len(c.files) {
+				t.Fatalf("expected %d files, got %d", len(c.files),
+					len(a.files))
+			}
+			for i, f := range a.files {
+				std := c.files[i]
+				if !f.modified.Equal(std.modified) {
+					t.Fatalf("file %d: expected mtime %v, got %v", i,
+						std.modified, f.modified)
+				}
+				f.modified, std.modified = time.Time{}, time.Time{}
+				if f != std {
+					t.Fatalf("file %d: expected %+v, got %+v", i, std, f)
+				}
+			}
+		})
+	}
+}
+
+func TestScanRarInvalid(t *testing.T) {
+	t.Parallel()
+
+	for name, archive := range map[string][]byte{
+		"name past header": append(
+			[]byte(rar5Signature),
+			buildRar5Header(rar5File, 0, []byte{0, 0, 0, 0, 0, 9, 'a'}, nil,
+				nil)...,
+		),
+		"truncated header": append(
+			[]byte(rar4Signature),
+			buildRar4Block(rar4Archive, 0, make([]byte, 6))[:10]...,
+		),
+		"not RAR": []byte("Rar!\x1A\x07\x02\x00"),
+	} {
+		_, err := scanRar(
+			context.Background(),
+			bytes.NewReader(archive),
+			maxArchiveEntries,
+		)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package thumbnailer
+
+// #include "thumbnailer.h"
+import "C"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// StoryboardOptions are supplied to Storyboard
+type StoryboardOptions struct {
+	// Amount of frames to sample evenly across the length of the video.
+	//
+	// Defaults to 100.
+	Frames int
+
+	// Maximum amount of tile columns and rows in each sprite image. Frames
+	// exceeding the capacity of a sprite are placed into additional sprites.
+	//
+	// Both default to 10.
+	Columns, Rows int
+
+	// Maximum dimensions of each tile. Frames are scaled down to fit inside
+	// this bounding box the same way thumbnails are.
+	//
+	// Defaults to 160x90.
+	TileDims Dims
+
+	// Algorithm used to scale tiles
+	ScaleFilter ScaleFilter
+
+	// Maximum source video dimensions. Same as Options.MaxSourceDims.
+	MaxSourceDims Dims
+
+	// MIME types to accept. Same as Options.AcceptedMimeTypes.
+	AcceptedMimeTypes map[string]bool
+
+	// Returns the URL of the n-th sprite image to reference in the WebVTT
+	// index.
+	//
+	// Defaults to "storyboard_<n>.jpg".
+	SpriteURL func(n int) string
+}
+
+// SpriteSheet is a storyboard of evenly spaced video frames tiled into
+// sprite images with a WebVTT index for video player scrubbers
+type SpriteSheet struct {
+	// Tiled frames. Tiles are laid out left to right, top to bottom.
+	Sprites []*image.RGBA
+
+	// Dimensions of each tile
+	Tile Dims
+
+	// Time span each tile represents
+	Interval time.Duration
+
+	// WebVTT file mapping time ranges to sprite regions using media fragment
+	// URLs of the form "<sprite URL>#xywh=<x>,<y>,<w>,<h>"
+	VTT []byte
+}
+
+func (o *StoryboardOptions) setDefaults() {
+	if o.Frames <= 0 {
+		o.Frames = 100
+	}
+	if o.Columns <= 0 {
+		o.Columns = 10
+	}
+	if o.Rows <= 0 {
+		o.Rows = 10
+	}
+	if o.TileDims.Width == 0 {
+		o.TileDims.Width = 160
+	}
+	if o.TileDims.Height == 0 {
+		o.TileDims.Height = 90
+	}
+	if o.SpriteURL == nil {
+		o.SpriteURL = func(n int) string {
+			return fmt.Sprintf("storyboard_%d.jpg", n)
+		}
+	}
+}
+
+// Storyboard generates a storyboard from a video file
+func Storyboard(rs io.ReadSeeker, opts StoryboardOptions,
+) (*SpriteSheet, error) {
+	return StoryboardContext(context.Background(), rs, opts)
+}
+
+// StoryboardContext is like Storyboard, but aborts processing, once ctx is
+// done. The returned error then is ctx.Err().
+func StoryboardContext(
+	ctx context.Context,
+	rs io.ReadSeeker,
+	opts StoryboardOptions,
+) (
+	sb *SpriteSheet, err error,
+) {
+	opts.setDefaults()
+
+	mime, _, err := DetectMIME(rs, opts.AcceptedMimeTypes)
+	if err != nil {
+		return
+	}
+	if !mediaMIMEs[mime] {
+		err = ErrUnsupportedMIME(mime)
+		return
+	}
+	_, err = rs.Seek(0, 0)
+	if err != nil {
+		return
+	}
+
+	c, err := newFFContextWithFormat(ctx, rs, mime)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	c.ScaleFilter = opts.ScaleFilter
+
+	hasVideo, err := c.HasStream(FFVideo)
+	if err != nil {
+		return
+	}
+	if hasVideo {
+		var coverArt bool
+		coverArt, err = c.videoIsCoverArt()
+		if err != nil {
+			return
+		}
+		hasVideo = !coverArt
+	}
+	length := c.Length()
+	if !hasVideo || length <= 0 {
+		err = ErrCantThumbnail
+		return
+	}
+	dims, err := c.Dims()
+	if err != nil {
+		return
+	}
+	max := opts.MaxSourceDims
+	if max.Width != 0 && dims.Width > max.Width {
+		err = ErrTooWide
+		return
+	}
+	if max.Height != 0 && dims.Height > max.Height {
+		err = ErrTooTall
+		return
+	}
+
+	sb = &SpriteSheet{
+		Interval: length / time.Duration(opts.Frames),
+	}
+	// Frames that can not be extracted are replaced with the preceding frame
+	// or the first extracted frame, if at the start
+	tiles := make([]*image.RGBA, opts.Frames)
+	var (
+		firstErr error
+		first    *image.RGBA
+	)
+	for i := range tiles {
+		// Sample the middle of each interval to avoid fade-ins at the start of
+		// the video
+		tiles[i], err = c.ThumbnailAt(
+			time.Duration(i)*sb.Interval+sb.Interval/2,
+			opts.TileDims,
+		)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if first == nil {
+			first = tiles[i]
+		}
+	}
+	if first == nil {
+		return nil, firstErr
+	}
+	err = nil
+	prev := first
+	for i, t := range tiles {
+		if t == nil {
+			tiles[i] = prev
+		} else {
+			prev = t
+		}
+	}
+
+	sb.tile(tiles, opts)
+	return
+}
+
+// Lay out tiles into sprites and generate the WebVTT index
+func (sb *SpriteSheet) tile(tiles []*image.RGBA, opts StoryboardOptions) {
+	// Frames of streams changing resolution are scaled to different
+	// dimensions. Size tiles to fit the largest frame and center the smaller
+	// ones.
+	var w, h int
+	for _, t := range tiles {
+		b := t.Bounds()
+		if b.Dx() > w {
+			w = b.Dx()
+		}
+		if b.Dy() > h {
+			h = b.Dy()
+		}
+	}
+	sb.Tile = Dims{
+		Width:  uint(w),
+		Height: uint(h),
+	}
+
+	var vtt bytes.Buffer
+	vtt.WriteString("WEBVTT\n")
+
+	perSprite := opts.Columns * opts.Rows
+	for i, t := range tiles {
+		n := i / perSprite
+		pos := i % perSprite
+		if pos == 0 {
+			left := len(tiles) - i
+			if left > perSprite {
+				left = perSprite
+			}
+			cols := opts.Columns
+			if left < cols {
+				cols = left
+			}
+			rows := (left + opts.Columns - 1) / opts.Columns
+			sb.Sprites = append(
+				sb.Sprites,
+				image.NewRGBA(image.Rect(0, 0, cols*w, rows*h)),
+			)
+		}
+
+		x := (pos % opts.Columns) * w
+		y := (pos / opts.Columns) * h
+		b := t.Bounds()
+		off := image.Pt(x+(w-b.Dx())/2, y+(h-b.Dy())/2)
+		draw.Draw(
+			sb.Sprites[n],
+			b.Sub(b.Min).Add(off),
+			t,
+			b.Min,
+			draw.Src,
+		)
+
+		start := time.Duration(i) * sb.Interval
+		fmt.Fprintf(
+			&vtt,
+			"\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(start),
+			formatVTTTimestamp(start+sb.Interval),
+			opts.SpriteURL(n),
+			x, y, w, h,
+		)
+	}
+
+	sb.VTT = vtt.Bytes()
+}
+
+// Format duration as a WebVTT timestamp
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d / time.Millisecond
+	return fmt.Sprintf(
+		"%02d:%02d:%02d.%03d",
+		ms/3600000,
+		ms/60000%60,
+		ms/1000%60,
+		ms%1000,
+	)
+}
+
+// ThumbnailAt generates a thumbnail from the first frame of the best video
+// stream at or after ts.
+//
+// Unlike Thumbnail no representative frame selection is performed.
+func (c *FFContext) ThumbnailAt(ts time.Duration, dims Dims,
+) (thumb *image.RGBA, err error) {
+	ci, err := c.codecContext(FFVideo)
+	if err != nil {
+		return
+	}
+
+	var img C.struct_Buffer
+	defer func() {
+		if img.data != nil {
+			C.free(unsafe.Pointer(img.data))
+		}
+	}()
+	ret := C.extract_frame(&img, c.avFormatCtx, ci.ctx, ci.stream,
+		C.int64_t(ts/time.Microsecond),
+		C.struct_Dims{
+			width:  C.uint64_t(dims.Width),
+			height: C.uint64_t(dims.Height),
+		},
+		c.scaleOptions())
+	switch {
+	case ret != 0:
+		err = c.castError(ret)
+	case img.data == nil:
+		err = ErrGetFrame
+	default:
+		thumb = rgbaImage(img)
+	}
+	return
+}
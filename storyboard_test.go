@@ -0,0 +1,170 @@
+package thumbnailer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoryboard(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "with_sound.mp4")
+	defer f.Close()
+
+	sb, err := Storyboard(f, StoryboardOptions{
+		Frames:  12,
+		Columns: 4,
+		Rows:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sb.Sprites) != 2 {
+		t.Fatalf("unexpected sprite count: %d", len(sb.Sprites))
+	}
+	if !strings.HasPrefix(string(sb.VTT), "WEBVTT\n") {
+		t.Fatal("invalid WebVTT header")
+	}
+	for i, s := range sb.Sprites {
+		writeSample(t, fmt.Sprintf("with_sound.mp4_storyboard_%d.png", i), s)
+	}
+}
+
+func TestStoryboardTiling(t *testing.T) {
+	t.Parallel()
+
+	tiles := make([]*image.RGBA, 5)
+	for i := range tiles {
+		tiles[i] = image.NewRGBA(image.Rect(0, 0, 16, 9))
+	}
+	opts := StoryboardOptions{
+		Frames:  len(tiles),
+		Columns: 2,
+		Rows:    2,
+	}
+	opts.setDefaults()
+	sb := SpriteSheet{
+		Interval: 1500 * time.Millisecond,
+	}
+	sb.tile(tiles, opts)
+
+	if sb.Tile != (Dims{16, 9}) {
+		t.Fatalf("unexpected tile dimensions: %v", sb.Tile)
+	}
+	sizes := [...]image.Point{{32, 18}, {16, 9}}
+	if len(sb.Sprites) != len(sizes) {
+		t.Fatalf("unexpected sprite count: %d", len(sb.Sprites))
+	}
+	for i, s := range sizes {
+		if sb.Sprites[i].Bounds().Size() != s {
+			t.Fatalf(
+				"unexpected sprite %d size: %v : %v",
+				i, s, sb.Sprites[i].Bounds().Size(),
+			)
+		}
+	}
+
+	const vtt = `WEBVTT
+
+00:00:00.000 --> 00:00:01.500
+storyboard_0.jpg#xywh=0,0,16,9
+
+00:00:01.500 --> 00:00:03.000
+storyboard_0.jpg#xywh=16,0,16,9
+
+00:00:03.000 --> 00:00:04.500
+storyboard_0.jpg#xywh=0,9,16,9
+
+00:00:04.500 --> 00:00:06.000
+storyboard_0.jpg#xywh=16,9,16,9
+
+00:00:06.000 --> 00:00:07.500
+storyboard_1.jpg#xywh=0,0,16,9
+`
+	if string(sb.VTT) != vtt {
+		t.Fatalf("unexpected WebVTT:\n%s", sb.VTT)
+	}
+}
+
+func TestStoryboardTilingDims(t *testing.T) {
+	t.Parallel()
+
+	colors := [...]color.RGBA{
+		{0xff, 0, 0, 0xff},
+		{0, 0xff, 0, 0xff},
+		{0, 0, 0xff, 0xff},
+	}
+	var tiles []*image.RGBA
+	for i, r := range [...]image.Rectangle{
+		image.Rect(0, 0, 16, 9),
+		image.Rect(0, 0, 12, 9),
+		image.Rect(0, 0, 16, 12),
+	} {
+		img := image.NewRGBA(r)
+		draw.Draw(img, r, image.NewUniform(colors[i]), image.Point{},
+			draw.Src)
+		tiles = append(tiles, img)
+	}
+	opts := StoryboardOptions{
+		Frames:  len(tiles),
+		Columns: 3,
+		Rows:    1,
+	}
+	opts.setDefaults()
+	sb := SpriteSheet{
+		Interval: time.Second,
+	}
+	sb.tile(tiles, opts)
+
+	if sb.Tile != (Dims{16, 12}) {
+		t.Fatalf("unexpected tile dimensions: %v", sb.Tile)
+	}
+	sprite := sb.Sprites[0]
+	if s := sprite.Bounds().Size(); s != image.Pt(48, 12) {
+		t.Fatalf("unexpected sprite size: %v", s)
+	}
+
+	// Smaller tiles are centered inside their cell
+	for _, c := range [...]struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, color.RGBA{}},
+		{0, 1, colors[0]},
+		{17, 1, color.RGBA{}},
+		{18, 1, colors[1]},
+		{29, 9, colors[1]},
+		{30, 9, color.RGBA{}},
+		{32, 0, colors[2]},
+	} {
+		if px := sprite.RGBAAt(c.x, c.y); px != c.c {
+			t.Fatalf("unexpected pixel at %d,%d: %v", c.x, c.y, px)
+		}
+	}
+}
+
+func TestStoryboardCoverArt(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "with_cover.mp3")
+	defer f.Close()
+
+	_, err := Storyboard(f, StoryboardOptions{})
+	if err != ErrCantThumbnail {
+		t.Fatalf("expected %v, got %v", ErrCantThumbnail, err)
+	}
+}
+
+func TestFormatVTTTimestamp(t *testing.T) {
+	t.Parallel()
+
+	d := time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond
+	if s := formatVTTTimestamp(d); s != "01:02:03.045" {
+		t.Fatalf("unexpected timestamp: %s", s)
+	}
+}
@@ -0,0 +1,318 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	mimeSVG = "image/svg+xml"
+
+	// Maximum size of SVG files to process
+	maxSVGSize = 16 << 20
+
+	// Rendered SVG thumbnail dimensions are capped to this value, so
+	// unbounded thumbnail dimensions can be used
+	maxSVGDims = 4096
+)
+
+// Dimensions of SVG images without specified dimensions or viewBox as for
+// replaced HTML elements
+const (
+	defaultSVGWidth  = 300
+	defaultSVGHeight = 150
+)
+
+// Pixels per SVG length unit
+var svgUnits = map[string]float64{
+	"px": 1,
+	"pt": 4.0 / 3,
+	"pc": 16,
+	"mm": 96 / 25.4,
+	"cm": 96 / 2.54,
+	"in": 96,
+	"em": 16,
+	"ex": 8,
+}
+
+// Render an SVG image directly at the thumbnail dimensions
+func processSVG(rs io.ReadSeeker, src *Source, opts Options,
+) (thumb image.Image, err error) {
+	// Guard against panics in the third party rasteriser on malformed input
+	defer func() {
+		if e := recover(); e != nil {
+			err = ErrInvalidImage(fmt.Sprint(e))
+		}
+	}()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(
+		contextReader{opts.Context(), rs},
+		maxSVGSize+1,
+	))
+	if err != nil {
+		return
+	}
+	if len(buf) > maxSVGSize {
+		err = ErrInvalidImage("SVG too big")
+		return
+	}
+
+	w, h, err := inspectSVG(buf)
+	if err != nil {
+		return
+	}
+	src.Width = uint(math.Round(w))
+	src.Height = uint(math.Round(h))
+	if max := opts.MaxSourceDims.Width; max != 0 && src.Width > max {
+		err = ErrTooWide
+		return
+	}
+	if max := opts.MaxSourceDims.Height; max != 0 && src.Height > max {
+		err = ErrTooTall
+		return
+	}
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(buf),
+		oksvg.IgnoreErrorMode)
+	if err != nil {
+		err = ErrInvalidImage(err.Error())
+		return
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		icon.ViewBox.X = 0
+		icon.ViewBox.Y = 0
+		icon.ViewBox.W = w
+		icon.ViewBox.H = h
+	}
+
+	sizes := opts.ThumbSizes
+	if len(sizes) == 0 {
+		sizes = []Dims{opts.ThumbDims}
+	}
+	thumbs := make(thumbnailSet, len(sizes))
+	for i, d := range sizes {
+		err = opts.Context().Err()
+		if err != nil {
+			return
+		}
+		thumbs[i] = renderSVG(icon, w, h, d, opts)
+	}
+	if len(thumbs) == 1 {
+		thumb = thumbs[0]
+	} else {
+		thumb = thumbs
+	}
+	return
+}
+
+// Validate the SVG document does not reference any external resources and
+// return its intrinsic dimensions
+func inspectSVG(buf []byte) (w, h float64, err error) {
+	dec := xml.NewDecoder(bytes.NewReader(buf))
+	dec.CharsetReader = charset.NewReaderLabel
+	var (
+		root    = true
+		inStyle bool
+	)
+	for {
+		tok, err := dec.Token()
+		switch err {
+		case nil:
+		case io.EOF:
+			if root {
+				return 0, 0, ErrInvalidImage("no svg element")
+			}
+			return w, h, nil
+		default:
+			return 0, 0, ErrInvalidImage(err.Error())
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if root {
+				if tok.Name.Local != "svg" {
+					return 0, 0, ErrInvalidImage("no svg element")
+				}
+				w, h = svgDims(tok.Attr)
+				root = false
+			}
+			inStyle = tok.Name.Local == "style"
+			for _, a := range tok.Attr {
+				if isExternalSVGAttr(a) {
+					return 0, 0, ErrExternalReference
+				}
+			}
+		case xml.EndElement:
+			inStyle = false
+		case xml.CharData:
+			if inStyle && isExternalCSS(string(tok)) {
+				return 0, 0, ErrExternalReference
+			}
+		case xml.Directive:
+			// External entities
+			d := string(tok)
+			if strings.Contains(d, "ENTITY") &&
+				(strings.Contains(d, "SYSTEM") || strings.Contains(d, "PUBLIC")) {
+				return 0, 0, ErrExternalReference
+			}
+		}
+	}
+}
+
+// Returns, if the attribute references a resource outside of the document
+func isExternalSVGAttr(a xml.Attr) bool {
+	if a.Name.Local == "href" || a.Name.Local == "src" {
+		v := strings.TrimSpace(a.Value)
+		return v != "" && !strings.HasPrefix(v, "#") &&
+			!strings.HasPrefix(strings.ToLower(v), "data:")
+	}
+	return isExternalCSS(a.Value)
+}
+
+// Returns, if CSS or presentation attribute text references a resource
+// outside of the document
+func isExternalCSS(s string) bool {
+	s = strings.ToLower(s)
+	if strings.Contains(s, "@import") {
+		return true
+	}
+	for {
+		i := strings.Index(s, "url(")
+		if i == -1 {
+			return false
+		}
+		s = strings.TrimLeft(s[i+4:], " \t\r\n'\"")
+		if !strings.HasPrefix(s, "#") && !strings.HasPrefix(s, "data:") {
+			return true
+		}
+	}
+}
+
+// Resolve the intrinsic dimensions of an SVG image from the attributes of its
+// root element
+func svgDims(attrs []xml.Attr) (w, h float64) {
+	var viewBox []float64
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "width":
+			w = svgLength(a.Value)
+		case "height":
+			h = svgLength(a.Value)
+		case "viewBox":
+			for _, f := range strings.FieldsFunc(a.Value, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t' || r == '\r' ||
+					r == '\n'
+			}) {
+				v, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					break
+				}
+				viewBox = append(viewBox, v)
+			}
+			if len(viewBox) != 4 || viewBox[2] <= 0 || viewBox[3] <= 0 {
+				viewBox = nil
+			}
+		}
+	}
+
+	switch {
+	case w > 0 && h > 0:
+	case viewBox == nil:
+		if w <= 0 {
+			w = defaultSVGWidth
+		}
+		if h <= 0 {
+			h = defaultSVGHeight
+		}
+	case w > 0:
+		h = w * viewBox[3] / viewBox[2]
+	case h > 0:
+		w = h * viewBox[2] / viewBox[3]
+	default:
+		w, h = viewBox[2], viewBox[3]
+	}
+	return
+}
+
+// Parse an absolute SVG length to pixels. Returns 0 for relative or invalid
+// lengths.
+func svgLength(s string) float64 {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if len(s) > 2 {
+		if u, ok := svgUnits[s[len(s)-2:]]; ok {
+			scale = u
+			s = s[:len(s)-2]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0
+	}
+	return v * scale
+}
+
+// Render SVG icon with the intrinsic dimensions w and h fitted into the
+// bounding box
+func renderSVG(icon *oksvg.SvgIcon, w, h float64, box Dims, opts Options,
+) *image.RGBA {
+	bw := float64(box.Width)
+	if bw > maxSVGDims {
+		bw = maxSVGDims
+	}
+	bh := float64(box.Height)
+	if bh > maxSVGDims {
+		bh = maxSVGDims
+	}
+
+	// Size of the rendered image viewport and the canvas it is placed on
+	var scale float64
+	switch opts.Fit {
+	case FitCover, FitSmartCrop:
+		scale = math.Max(bw/w, bh/h)
+	default:
+		scale = math.Min(bw/w, bh/h)
+	}
+	vw, vh := w*scale, h*scale
+	cw, ch := vw, vh
+	if opts.Fit != FitContain {
+		// Crop or pad to the bounding box
+		cw, ch = bw, bh
+	}
+	r := image.Rect(
+		0, 0,
+		maxInt(1, int(math.Round(cw))), maxInt(1, int(math.Round(ch))),
+	)
+
+	img := image.NewRGBA(r)
+	if opts.Fit == FitPad && opts.Background != nil {
+		draw.Draw(img, r, image.NewUniform(opts.Background), image.Point{},
+			draw.Src)
+	}
+
+	// Centre the viewport on the canvas and the viewBox in the viewport
+	vb := icon.ViewBox
+	s := math.Min(vw/vb.W, vh/vb.H)
+	icon.Transform = rasterx.Identity.
+		Translate(
+			(float64(r.Dx())-vw)/2+(vw-vb.W*s)/2,
+			(float64(r.Dy())-vh)/2+(vh-vb.H*s)/2,
+		).
+		Scale(s, s).
+		Translate(-vb.X, -vb.Y)
+	scanner := rasterx.NewScannerGV(r.Dx(), r.Dy(), img, r)
+	icon.Draw(rasterx.NewDasher(r.Dx(), r.Dy(), scanner), 1)
+	return img
+}
@@ -0,0 +1,215 @@
+package thumbnailer
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestMatchSVG(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, in string
+		match    bool
+	}{
+		{"bare", `<svg xmlns="http://www.w3.org/2000/svg"/>`, true},
+		{"BOM", "\xEF\xBB\xBF<svg>", true},
+		{
+			"prolog",
+			`<?xml version="1.0"?>
+<!-- <html> -->
+<!DOCTYPE svg [<!ENTITY a "b">]>
+<svg>`,
+			true,
+		},
+		{"html", `<html><svg></svg></html>`, false},
+		{"prefix", `<svgfoo>`, false},
+		{"unterminated comment", `<!-- <svg>`, false},
+		{"text", `svg`, false},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			mime, _ := matchSVG([]byte(c.in))
+			if (mime == mimeSVG) != c.match {
+				t.Fatalf("expected match=%t, got %q", c.match, mime)
+			}
+		})
+	}
+}
+
+func TestInspectSVG(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, in string
+		w, h     float64
+		err      error
+	}{
+		{
+			name: "dimensions",
+			in:   `<svg width="2in" height="72pt"/>`,
+			w:    192,
+			h:    96,
+		},
+		{
+			name: "viewBox",
+			in:   `<svg viewBox="0,0 40 30"/>`,
+			w:    40,
+			h:    30,
+		},
+		{
+			name: "viewBox aspect ratio",
+			in:   `<svg width="80" height="100%" viewBox="0 0 40 30"/>`,
+			w:    80,
+			h:    60,
+		},
+		{
+			name: "default",
+			in:   `<svg/>`,
+			w:    300,
+			h:    150,
+		},
+		{
+			name: "internal references",
+			in: `<svg xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<use xlink:href="#a"/><rect fill="url('#g')"/></svg>`,
+			w: 300,
+			h: 150,
+		},
+		{
+			name: "xlink:href",
+			in: `<svg xmlns:xlink="http://www.w3.org/1999/xlink">` +
+				`<image xlink:href="file:///etc/passwd"/></svg>`,
+			err: ErrExternalReference,
+		},
+		{
+			name: "href",
+			in:   `<svg><use href="http://example.com/a.svg#b"/></svg>`,
+			err:  ErrExternalReference,
+		},
+		{
+			name: "url",
+			in:   `<svg><rect style="fill: url(http://example.com)"/></svg>`,
+			err:  ErrExternalReference,
+		},
+		{
+			name: "stylesheet",
+			in:   `<svg><style>@import "a.css";</style></svg>`,
+			err:  ErrExternalReference,
+		},
+		{
+			name: "external entity",
+			in: `<!DOCTYPE svg [<!ENTITY e SYSTEM "/etc/passwd">]>` +
+				`<svg/>`,
+			err: ErrExternalReference,
+		},
+		{
+			name: "not SVG",
+			in:   `<html/>`,
+			err:  ErrInvalidImage("no svg element"),
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			w, h, err := inspectSVG([]byte(c.in))
+			if err != c.err {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if math.Abs(w-c.w) > 1e-9 || math.Abs(h-c.h) > 1e-9 {
+				t.Fatalf("expected %fx%f, got %fx%f", c.w, c.h, w, h)
+			}
+		})
+	}
+}
+
+func TestProcessSVG(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name string
+		opts Options
+		dims Dims
+	}{
+		{
+			name: "upscale",
+			opts: Options{ThumbDims: Dims{400, 400}},
+			dims: Dims{400, 200},
+		},
+		{
+			name: "downscale",
+			opts: Options{ThumbDims: Dims{100, 100}},
+			dims: Dims{100, 50},
+		},
+		{
+			name: "pad",
+			opts: Options{
+				ThumbDims:  Dims{100, 100},
+				Fit:        FitPad,
+				Background: color.Black,
+			},
+			dims: Dims{100, 100},
+		},
+		{
+			name: "cover",
+			opts: Options{
+				ThumbDims: Dims{100, 100},
+				Fit:       FitCover,
+			},
+			dims: Dims{100, 100},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, "sample.svg")
+			defer f.Close()
+
+			src, thumb, err := Process(f, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Mime != mimeSVG || src.Extension != "svg" {
+				t.Fatalf("unexpected type: %s %s", src.Mime, src.Extension)
+			}
+			if src.Dims != (Dims{200, 100}) {
+				t.Fatalf("unexpected source dimensions: %v", src.Dims)
+			}
+			b := thumb.Bounds()
+			if b.Dx() != int(c.dims.Width) || b.Dy() != int(c.dims.Height) {
+				t.Fatalf("expected %v, got %v", c.dims, b)
+			}
+
+			// White circle in the centre on a red to blue gradient
+			centre := color.RGBAModel.Convert(thumb.At(b.Dx()/2, b.Dy()/2))
+			if centre != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+				t.Fatalf("unexpected centre colour: %v", centre)
+			}
+			writeSample(t, "sample_svg_"+c.name+".png", thumb)
+		})
+	}
+}
+
+func TestProcessSVGExternalReference(t *testing.T) {
+	t.Parallel()
+
+	const svg = `<svg xmlns="http://www.w3.org/2000/svg"` +
+		` xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<image xlink:href="http://example.com/a.png"/></svg>`
+	_, _, err := Process(bytes.NewReader([]byte(svg)), Options{})
+	if err != ErrExternalReference {
+		t.Fatalf("expected %v, got %v", ErrExternalReference, err)
+	}
+}
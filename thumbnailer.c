@@ -1,6 +1,7 @@
 #include "thumbnailer.h"
 #include <float.h>
 #include <libavutil/imgutils.h>
+#include <math.h>
 #include <libswscale/swscale.h>
 
 /**
@@ -17,6 +18,20 @@
 #define HIST_CHANNELS 3
 #define MAX_FRAMES 10
 
+// Limits the amount of frames decoded, when generating animated thumbnails,
+// regardless of how many are skipped for frame rate capping
+#define MAX_ANIMATION_DECODED_FRAMES 3000
+
+// Limits the amount of frames decoded after seeking to reach the target
+// timestamp
+#define MAX_SEEK_DECODED_FRAMES 1000
+
+// Width and height of frame samples used for image analysis
+#define ANALYSIS_SAMPLE_SIZE 64
+
+// Maximum width and height, that FIT_PAD letterboxes thumbnails to
+#define MAX_PAD_SIZE 16384
+
 // Compute sum-square deviation to estimate "closeness"
 static double compute_error(const unsigned hist[HIST_SIZE][HIST_CHANNELS],
     const double average[HIST_SIZE][HIST_CHANNELS])
@@ -86,19 +101,48 @@ static AVFrame* select_best_frame(AVFrame* frames[], int size)
 }
 
 // Calculate size and allocate buffer
-static void alloc_buffer(struct Buffer* dst)
+static int alloc_buffer(struct Buffer* dst)
 {
-    dst->size
+    const int size
         = av_image_get_buffer_size(AV_PIX_FMT_RGBA, dst->width, dst->height, 1);
+    if (size < 0) {
+        return size;
+    }
+    dst->size = size;
     dst->data = malloc(dst->size);
+    if (!dst->data) {
+        return AVERROR(ENOMEM);
+    }
+    return 0;
 }
 
-// Use point subsampling to scale image up to target size and convert to RGBA
-static int resample(struct Buffer* dst, const AVFrame const* frame)
+// Map ScaleFilter to swscale flags
+static int sws_flags(const enum ScaleFilter filter)
+{
+    switch (filter) {
+    case SCALE_BILINEAR:
+        return SWS_BILINEAR;
+    case SCALE_BICUBIC:
+        return SWS_BICUBIC;
+    case SCALE_AREA:
+        return SWS_AREA;
+    case SCALE_LANCZOS:
+        return SWS_LANCZOS;
+    case SCALE_SPLINE:
+        return SWS_SPLINE;
+    default:
+        return SWS_POINT;
+    }
+}
+
+// Scale image to target size with the passed swscale flags and convert to
+// RGBA
+static int resample(
+    struct Buffer* dst, const AVFrame const* frame, const int flags)
 {
     struct SwsContext* ctx
         = sws_getContext(frame->width, frame->height, frame->format, dst->width,
-            dst->height, AV_PIX_FMT_RGBA, SWS_POINT, NULL, NULL, NULL);
+            dst->height, AV_PIX_FMT_RGBA, flags, NULL, NULL, NULL);
     if (!ctx) {
         return AVERROR(ENOMEM);
     }
@@ -334,99 +378,347 @@ static void scale_dims(struct Buffer* img, uint64_t max, uint64_t val)
     }
 }
 
-// Encode and scale frame to RGBA image
-static int encode_frame(
-    struct Buffer* img, AVFrame* frame, const struct Dims box, int orientation)
+// Convert frame to RGBA without scaling
+static int convert_frame(struct Buffer* img, const AVFrame* frame)
 {
-    int err;
-
-    if (frame->metadata) {
-        AVDictionaryEntry* e
-            = av_dict_get(frame->metadata, "Orientation", NULL, 0);
-        if (e) {
-            orientation = atol(e->value);
-        }
-    }
-
     img->width = frame->width;
     img->height = frame->height;
+    int err = alloc_buffer(img);
+    if (err) {
+        return err;
+    }
+    err = resample(img, frame, SWS_POINT);
+    if (err) {
+        return err;
+    }
+    compensate_alpha(img);
+    return 0;
+}
 
-    // If image fits inside thumbnail, simply convert to RGBA.
-    //
-    // scale_dims() does not work, if image size is exactly that of the target
-    // thumbnail size. Perhaps a peculiarity of sws_scale().
-    if (img->width < box.width && img->height < box.height) {
-        alloc_buffer(img);
-        err = resample(img, frame);
+// Scale frame to the preset dimensions of img and convert to RGBA
+static int scale_frame(
+    struct Buffer* img, const AVFrame* frame, const enum ScaleFilter filter)
+{
+    int err;
+
+    if (filter != SCALE_POINT_BOX) {
+        // Interpolating filters scale in a single pass
+        err = alloc_buffer(img);
+        if (err) {
+            return err;
+        }
+        err = resample(img, frame, sws_flags(filter));
         if (err) {
             return err;
         }
         compensate_alpha(img);
-        adjust_orientation(img, orientation);
         return 0;
     }
 
-    scale_dims(img, box.width, img->width);
-    scale_dims(img, box.height, img->height);
-
     // Subsample to 4 times the thumbnail size and then Box subsample that.
     // A decent enough compromise between quality and performance for images
     // around the thumbnail size and much bigger ones.
     struct Buffer enlarged
         = { .width = img->width * 4, .height = img->height * 4 };
-    alloc_buffer(&enlarged);
-    err = resample(&enlarged, frame);
+    err = alloc_buffer(&enlarged);
+    if (err) {
+        return err;
+    }
+    err = resample(&enlarged, frame, SWS_POINT);
+    if (!err) {
+        err = alloc_buffer(img);
+    }
     if (err) {
         free(enlarged.data);
         return err;
     }
-    alloc_buffer(img);
     downscale(img, &enlarged);
     free(enlarged.data);
-    adjust_orientation(img, orientation);
-    return err;
+    return 0;
 }
 
-// Read from stream until a full frame is read
-static int read_frame(AVFormatContext* avfc, AVCodecContext* avcc,
-    AVFrame* frame, const int stream)
+// Scale frame down to fit inside box
+static int fit_contain(struct Buffer* img, const AVFrame* frame,
+    const struct Dims box, const enum ScaleFilter filter)
 {
-    int err = 0;
-    AVPacket pkt;
+    // If image fits inside thumbnail, simply convert to RGBA.
+    //
+    // scale_dims() does not work, if image size is exactly that of the target
+    // thumbnail size. Perhaps a peculiarity of sws_scale().
+    if (frame->width < box.width && frame->height < box.height) {
+        return convert_frame(img, frame);
+    }
 
-    // Continue until frame read
-    while (1) {
-        err = av_read_frame(avfc, &pkt);
-        if (err) {
-            goto end;
+    img->width = frame->width;
+    img->height = frame->height;
+    scale_dims(img, box.width, img->width);
+    scale_dims(img, box.height, img->height);
+    return scale_frame(img, frame, filter);
+}
+
+// Replace img with the w*h region starting at x0,y0
+static int crop(struct Buffer* img, const uint64_t x0, const uint64_t y0,
+    const uint64_t w, const uint64_t h)
+{
+    struct Buffer out = { .width = w, .height = h };
+    const int err = alloc_buffer(&out);
+    if (err) {
+        return err;
+    }
+    for (uint64_t y = 0; y < h; y++) {
+        memcpy(out.data + y * w * 4,
+            img->data + ((y0 + y) * img->width + x0) * 4, w * 4);
+    }
+    free(img->data);
+    *img = out;
+    return 0;
+}
+
+// Luminance of the RGBA pixel at i
+static inline int luma(const uint8_t* p)
+{
+    return (p[0] * 299 + p[1] * 587 + p[2] * 114) / 1000;
+}
+
+// Find the offset of the window of size len along the energy profile with
+// the highest sum. Prefers windows closer to the centre on ties.
+static uint64_t max_energy_window(
+    const uint64_t* energy, const uint64_t size, const uint64_t len)
+{
+    uint64_t sum = 0;
+    for (uint64_t i = 0; i < len; i++) {
+        sum += energy[i];
+    }
+
+    const uint64_t centre = (size - len) / 2;
+    uint64_t best = 0, best_sum = sum;
+    for (uint64_t i = 1; i + len <= size; i++) {
+        sum += energy[i + len - 1];
+        sum -= energy[i - 1];
+        const uint64_t dist = i > centre ? i - centre : centre - i;
+        const uint64_t best_dist = best > centre ? best - centre : centre - best;
+        if (sum > best_sum || (sum == best_sum && dist < best_dist)) {
+            best = i;
+            best_sum = sum;
         }
+    }
+    return best;
+}
 
-        if (pkt.stream_index == stream) {
-            err = avcodec_send_packet(avcc, &pkt);
-            if (err < 0) {
-                goto end;
-            }
+// Select the crop window offset with the highest edge energy. Only one
+// dimension of the window is ever smaller than the image.
+static void smart_crop_offset(const struct Buffer* img, const uint64_t w,
+    const uint64_t h, uint64_t* x0, uint64_t* y0)
+{
+    const int horizontal = img->width > w;
+    const uint64_t size = horizontal ? img->width : img->height;
+    uint64_t* energy = calloc(size, sizeof(uint64_t));
+
+    // Sum of absolute luminance gradients per column or row
+    for (uint64_t y = 1; y < img->height; y++) {
+        for (uint64_t x = 1; x < img->width; x++) {
+            const uint8_t* p = img->data + (y * img->width + x) * 4;
+            const int l = luma(p);
+            const int dx = abs(l - luma(p - 4));
+            const int dy = abs(l - luma(p - img->width * 4));
+            energy[horizontal ? x : y] += dx + dy;
+        }
+    }
 
-            err = avcodec_receive_frame(avcc, frame);
-            switch (err) {
-            case 0:
-                goto end;
-            case AVERROR(EAGAIN):
-                av_packet_unref(&pkt);
-                break;
-            default:
-                goto end;
+    if (horizontal) {
+        *x0 = max_energy_window(energy, size, w);
+        *y0 = 0;
+    } else {
+        *x0 = 0;
+        *y0 = max_energy_window(energy, size, h);
+    }
+    free(energy);
+}
+
+// Scale frame to fill box and crop the excess. Does not upscale, in which
+// case the frame is only cropped to the aspect ratio of box.
+static int fit_cover(struct Buffer* img, const AVFrame* frame,
+    const struct Dims box, const struct ScaleOptions scale)
+{
+    int err;
+    const double f = FFMIN(1,
+        FFMAX((double)box.width / frame->width,
+            (double)box.height / frame->height));
+    img->width = FFMAX(1, (uint64_t)ceil(frame->width * f));
+    img->height = FFMAX(1, (uint64_t)ceil(frame->height * f));
+    if (img->width == frame->width && img->height == frame->height) {
+        err = convert_frame(img, frame);
+    } else {
+        err = scale_frame(img, frame, scale.filter);
+    }
+    if (err) {
+        return err;
+    }
+
+    // Crop window with the aspect ratio of box
+    uint64_t w = FFMIN(img->width, box.width);
+    uint64_t h = FFMIN(img->height, box.height);
+    if (f == 1) {
+        w = FFMIN(img->width,
+            FFMAX(1, (uint64_t)((double)h * box.width / box.height)));
+        h = FFMIN(img->height,
+            FFMAX(1, (uint64_t)((double)w * box.height / box.width)));
+    }
+    if (w == img->width && h == img->height) {
+        return 0;
+    }
+
+    uint64_t x0, y0;
+    if (scale.fit == FIT_SMART_CROP) {
+        smart_crop_offset(img, w, h, &x0, &y0);
+    } else {
+        x0 = (img->width - w) / 2;
+        y0 = (img->height - h) / 2;
+    }
+    return crop(img, x0, y0, w, h);
+}
+
+// Scale frame down to fit inside box and letterbox it to the exact size of
+// box with the background colour
+static int fit_pad(struct Buffer* img, const AVFrame* frame,
+    const struct Dims box, const struct ScaleOptions scale)
+{
+    struct Buffer content = { 0 };
+    int err = fit_contain(&content, frame, box, scale.filter);
+    if (err) {
+        free(content.data);
+        return err;
+    }
+
+    // Only letterbox up to MAX_PAD_SIZE. Larger bounding boxes, like those
+    // unbounded in one dimension, would allocate huge mostly empty buffers.
+    img->width = FFMAX(content.width, FFMIN(box.width, MAX_PAD_SIZE));
+    img->height = FFMAX(content.height, FFMIN(box.height, MAX_PAD_SIZE));
+    err = alloc_buffer(img);
+    if (err) {
+        free(content.data);
+        return err;
+    }
+    for (size_t i = 0; i < img->size; i += 4) {
+        memcpy(img->data + i, scale.background, 4);
+    }
+
+    // Composite premultiplied content over background
+    const uint64_t x0 = (img->width - content.width) / 2;
+    const uint64_t y0 = (img->height - content.height) / 2;
+    for (uint64_t y = 0; y < content.height; y++) {
+        const uint8_t* src = content.data + y * content.width * 4;
+        uint8_t* dst = img->data + ((y0 + y) * img->width + x0) * 4;
+        for (uint64_t x = 0; x < content.width; x++) {
+            const int inv_alpha = 255 - src[3];
+            for (int j = 0; j < 4; j++) {
+                dst[j] = FFMIN(255, src[j] + dst[j] * inv_alpha / 255);
             }
+            src += 4;
+            dst += 4;
         }
     }
 
-end:
-    av_packet_unref(&pkt);
-    return err;
+    free(content.data);
+    return 0;
+}
+
+// Exif orientation of the frame, if set, or the passed stream orientation
+static int frame_orientation(const AVFrame* frame, const int orientation)
+{
+    if (frame->metadata) {
+        AVDictionaryEntry* e
+            = av_dict_get(frame->metadata, "Orientation", NULL, 0);
+        if (e) {
+            return atol(e->value);
+        }
+    }
+    return orientation;
+}
+
+// Scale frame to a fixed size RGBA sample for image analysis, disregarding
+// aspect ratio
+static int sample_frame(
+    struct Buffer* img, const AVFrame* frame, const int orientation)
+{
+    img->width = ANALYSIS_SAMPLE_SIZE;
+    img->height = ANALYSIS_SAMPLE_SIZE;
+    int err = alloc_buffer(img);
+    if (err) {
+        return err;
+    }
+    err = resample(img, frame, SWS_AREA);
+    if (err) {
+        return err;
+    }
+    compensate_alpha(img);
+    adjust_orientation(img, frame_orientation(frame, orientation));
+    return 0;
+}
+
+// Encode and scale frame to RGBA image
+static int encode_frame(struct Buffer* img, AVFrame* frame, struct Dims box,
+    const struct ScaleOptions scale, int orientation)
+{
+    int err;
+
+    orientation = frame_orientation(frame, orientation);
+
+    // Box is relative to the displayed orientation. Orientations from 5 to 8
+    // rotate by 90 or 270 degrees.
+    if (orientation >= 5 && orientation <= 8) {
+        const uint64_t tmp = box.width;
+        box.width = box.height;
+        box.height = tmp;
+    }
+
+    // Filling the box is impossible, if it is unbounded in any dimension
+    enum Fit fit = scale.fit;
+    if (box.width >= UINT32_MAX || box.height >= UINT32_MAX) {
+        fit = FIT_CONTAIN;
+    }
+
+    switch (fit) {
+    case FIT_COVER:
+    case FIT_SMART_CROP:
+        err = fit_cover(img, frame, box, scale);
+        break;
+    case FIT_PAD:
+        err = fit_pad(img, frame, box, scale);
+        break;
+    default:
+        err = fit_contain(img, frame, box, scale.filter);
+    }
+    if (err) {
+        return err;
+    }
+
+    adjust_orientation(img, orientation);
+    return 0;
+}
+
+// Convert stream rotation metadata to the equivalent exif orientation
+static int stream_orientation(AVFormatContext* avfc, const int stream)
+{
+    AVDictionaryEntry* e
+        = av_dict_get(avfc->streams[stream]->metadata, "rotate", NULL, 0);
+    if (e) {
+        switch (atol(e->value)) {
+        case 90:
+            return 6;
+        case 180:
+            return 3;
+        case 270:
+            return 8;
+        }
+    }
+    return 0;
 }
 
-int generate_thumbnail(struct Buffer* img, AVFormatContext* avfc,
-    AVCodecContext* avcc, const int stream, const struct Dims thumb_dims)
+int generate_thumbnail(struct Buffer* imgs, struct Buffer* sample,
+    AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const struct Dims* thumb_dims, const int dims_len,
+    const struct ScaleOptions scale)
 {
     int err = 0;
     int size = 0;
@@ -455,27 +747,20 @@ int generate_thumbnail(struct Buffer* img, AVFormatContext* avfc,
     }
 
 end:
-    if (size) {
-        int orientation = 0;
-        AVDictionaryEntry* e
-            = av_dict_get(avfc->streams[stream]->metadata, "rotate", NULL, 0);
-        if (e) {
-            switch (atol(e->value)) {
-            case 90:
-                orientation = 6;
-                break;
-            case 180:
-                orientation = 3;
-                break;
-            case 270:
-                orientation = 8;
+    // Ignore all read errors, if at least one frame read, unless aborted
+    if (size && err != AVERROR_EXIT) {
+        AVFrame* best = select_best_frame(frames, size);
+        const int orientation = stream_orientation(avfc, stream);
+        for (int i = 0; i < dims_len; i++) {
+            err = encode_frame(
+                &imgs[i], best, thumb_dims[i], scale, orientation);
+            if (err) {
                 break;
             }
         }
-
-        // Ignore all read errors, if at least one frame read
-        err = encode_frame(
-            img, select_best_frame(frames, size), thumb_dims, orientation);
+        if (!err && sample) {
+            err = sample_frame(sample, best, orientation);
+        }
     }
 
     for (int i = 0; i < size; i++) {
@@ -487,3 +772,182 @@ end:
 
     return err;
 }
+
+// Presentation timestamp of frame in AV_TIME_BASE units or AV_NOPTS_VALUE, if
+// unknown
+static int64_t frame_timestamp(
+    AVFormatContext* avfc, const int stream, const AVFrame* frame)
+{
+    const int64_t ts = frame->best_effort_timestamp;
+    if (ts == AV_NOPTS_VALUE) {
+        return ts;
+    }
+    return av_rescale_q(ts, avfc->streams[stream]->time_base, AV_TIME_BASE_Q);
+}
+
+int generate_animation(struct Animation* anim, struct Buffer* sample,
+    AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const struct Dims thumb_dims, const struct ScaleOptions scale,
+    const struct AnimationOptions opts)
+{
+    int err = 0;
+    int decoded = 0;
+    int64_t first_ts = AV_NOPTS_VALUE;
+    int64_t prev_ts = 0;
+    int64_t* timestamps = NULL;
+    AVFrame* frame = NULL;
+
+    // Used to extrapolate timestamps of frames without one
+    int64_t frame_interval = 0;
+    const AVRational fr
+        = av_guess_frame_rate(avfc, avfc->streams[stream], NULL);
+    if (fr.num > 0 && fr.den > 0) {
+        frame_interval = av_rescale(AV_TIME_BASE, fr.den, fr.num);
+    } else {
+        frame_interval = AV_TIME_BASE / 25;
+    }
+
+    // Minimum distance between 2 consecutive output frames
+    int64_t min_interval = 0;
+    if (opts.frame_rate > 0) {
+        min_interval = (int64_t)((double)AV_TIME_BASE / opts.frame_rate);
+    }
+
+    anim->size = 0;
+    anim->frames = calloc(opts.max_frames, sizeof(struct Buffer));
+    anim->delays = calloc(opts.max_frames, sizeof(int64_t));
+    timestamps = calloc(opts.max_frames, sizeof(int64_t));
+    frame = av_frame_alloc();
+    if (!anim->frames || !anim->delays || !timestamps || !frame) {
+        err = AVERROR(ENOMEM);
+        goto end;
+    }
+    const int orientation = stream_orientation(avfc, stream);
+
+    while (anim->size < opts.max_frames
+        && decoded++ < MAX_ANIMATION_DECODED_FRAMES) {
+        av_frame_unref(frame);
+        err = read_frame(avfc, avcc, frame, stream);
+        if (err) {
+            break;
+        }
+
+        int64_t ts = frame_timestamp(avfc, stream, frame);
+        if (ts == AV_NOPTS_VALUE) {
+            ts = first_ts == AV_NOPTS_VALUE ? 0 : prev_ts + frame_interval;
+        }
+        if (first_ts == AV_NOPTS_VALUE) {
+            first_ts = ts;
+        }
+        ts -= first_ts;
+        if (ts < prev_ts) {
+            // Non-monotonic timestamps. Preserve frame order.
+            ts = prev_ts;
+        }
+        prev_ts = ts;
+
+        if (ts > opts.max_duration) {
+            break;
+        }
+        if (anim->size
+            && ts - timestamps[anim->size - 1] < min_interval) {
+            continue;
+        }
+
+        err = encode_frame(&anim->frames[anim->size], frame, thumb_dims, scale,
+            orientation);
+        if (err) {
+            goto end;
+        }
+        if (!anim->size && sample) {
+            err = sample_frame(sample, frame, orientation);
+            if (err) {
+                goto end;
+            }
+        }
+        timestamps[anim->size++] = ts;
+    }
+
+    // Ignore all read errors, if at least one frame read, unless aborted
+    if (anim->size && err != AVERROR_EXIT) {
+        err = 0;
+    }
+    if (err) {
+        goto end;
+    }
+
+    for (int i = 0; i < anim->size - 1; i++) {
+        anim->delays[i] = timestamps[i + 1] - timestamps[i];
+    }
+    if (anim->size > 1) {
+        // The last frame has no successor to compute the delay from
+        anim->delays[anim->size - 1] = anim->delays[anim->size - 2];
+    }
+
+end:
+    free(timestamps);
+    if (frame) {
+        av_frame_free(&frame);
+    }
+    return err;
+}
+
+int extract_frame(struct Buffer* img, AVFormatContext* avfc,
+    AVCodecContext* avcc, const int stream, const int64_t ts,
+    const struct Dims thumb_dims, const struct ScaleOptions scale)
+{
+    int err = 0;
+    int have_frame = 0;
+    AVFrame* frame = av_frame_alloc();
+    AVFrame* next = av_frame_alloc();
+    if (!frame || !next) {
+        err = AVERROR(ENOMEM);
+        goto end;
+    }
+
+    // Frame timestamps include the stream start offset
+    int64_t target = ts;
+    const AVStream* st = avfc->streams[stream];
+    if (st->start_time != AV_NOPTS_VALUE) {
+        target += av_rescale_q(st->start_time, st->time_base, AV_TIME_BASE_Q);
+    }
+
+    // Failure rewinds to the start of the stream, so the target can still be
+    // reached by decoding
+    seek_stream(avfc, avcc, stream, ts);
+
+    for (int i = 0; i < MAX_SEEK_DECODED_FRAMES; i++) {
+        err = read_frame(avfc, avcc, next, stream);
+        if (err) {
+            break;
+        }
+
+        // Retain the last decoded frame in case the target is past the end of
+        // the stream
+        AVFrame* tmp = frame;
+        frame = next;
+        next = tmp;
+        av_frame_unref(next);
+        have_frame = 1;
+
+        const int64_t frame_ts = frame_timestamp(avfc, stream, frame);
+        if (frame_ts == AV_NOPTS_VALUE || frame_ts >= target) {
+            break;
+        }
+    }
+
+    // Ignore all read errors, if at least one frame read, unless aborted
+    if (have_frame && err != AVERROR_EXIT) {
+        err = encode_frame(
+            img, frame, thumb_dims, scale, stream_orientation(avfc, stream));
+    }
+
+end:
+    if (frame) {
+        av_frame_free(&frame);
+    }
+    if (next) {
+        av_frame_free(&next);
+    }
+    return err;
+}
@@ -5,7 +5,9 @@ package thumbnailer
 import "C"
 import (
 	"image"
+	"image/color"
 	"io"
+	"time"
 	"unsafe"
 )
 
@@ -13,41 +15,140 @@ func init() {
 	C.av_log_set_level(C.AV_LOG_ERROR)
 }
 
+// ScaleFilter is the algorithm used for scaling thumbnails
+type ScaleFilter uint8
+
+// Available scaling algorithms
+const (
+	// Point subsample to 4 times the thumbnail size and then box subsample
+	// that. A decent compromise between quality and performance, but aliases
+	// on fine patterns, line art and text.
+	ScalePointBox ScaleFilter = iota
+
+	// Single pass scaling with the respective swscale filters
+	ScaleBilinear
+	ScaleBicubic
+	ScaleArea
+	ScaleLanczos
+	ScaleSpline
+)
+
+// Fit specifies how thumbnails are fitted into the thumbnail bounding box
+type Fit uint8
+
+// Available fitting modes. All modes respect exif orientation and never
+// upscale the source.
+const (
+	// Scale down to fit inside the bounding box
+	FitContain Fit = iota
+
+	// Scale down to fill the bounding box and crop the excess around the
+	// centre. Sources smaller than the bounding box are only cropped to its
+	// aspect ratio.
+	FitCover
+
+	// Scale down to fit inside the bounding box and letterbox to its exact
+	// size with the background colour. Bounding box dimensions over 16384
+	// are not padded beyond that or the scaled source.
+	FitPad
+
+	// Like FitCover, but crop the region with the most edges instead of the
+	// centre
+	FitSmartCrop
+)
+
+// Scaling parameters of thumbnails generated from c
+func (c *FFContext) scaleOptions() C.struct_ScaleOptions {
+	o := C.struct_ScaleOptions{
+		filter: C.enum_ScaleFilter(c.ScaleFilter),
+		fit:    C.enum_Fit(c.Fit),
+	}
+	if c.Background != nil {
+		bg := color.RGBAModel.Convert(c.Background).(color.RGBA)
+		o.background = [4]C.uint8_t{
+			C.uint8_t(bg.R),
+			C.uint8_t(bg.G),
+			C.uint8_t(bg.B),
+			C.uint8_t(bg.A),
+		}
+	}
+	return o
+}
+
+// Returns a buffer to write the frame sample to, if c.sampleFrames is set, and
+// a function to free it with
+func (c *FFContext) sampleBuffer() (*C.struct_Buffer, func()) {
+	if !c.sampleFrames {
+		return nil, func() {}
+	}
+	var buf C.struct_Buffer
+	return &buf, func() {
+		if buf.data != nil {
+			C.free(unsafe.Pointer(buf.data))
+		}
+	}
+}
+
+// Store the frame sample written to buf, if any
+func (c *FFContext) storeSample(buf *C.struct_Buffer) {
+	if buf != nil && buf.data != nil {
+		c.sample = rgbaImage(*buf)
+	}
+}
+
 // Thumbnail generates a thumbnail from a representative frame of the media.
 // Images count as one frame media.
 func (c *FFContext) Thumbnail(dims Dims) (thumb image.Image, err error) {
+	thumbs, err := c.Thumbnails([]Dims{dims})
+	if err != nil {
+		return
+	}
+	thumb = thumbs[0]
+	return
+}
+
+// Thumbnails is like Thumbnail, but generates one thumbnail per passed
+// bounding box. The representative frame is selected and decoded only once.
+// Returns no thumbnails, if dims is empty.
+func (c *FFContext) Thumbnails(dims []Dims) (thumbs []image.Image, err error) {
+	if len(dims) == 0 {
+		return
+	}
 	ci, err := c.codecContext(FFVideo)
 	if err != nil {
 		return
 	}
 
-	var img C.struct_Buffer
+	cDims := make([]C.struct_Dims, len(dims))
+	for i, d := range dims {
+		cDims[i] = C.struct_Dims{
+			width:  C.uint64_t(d.Width),
+			height: C.uint64_t(d.Height),
+		}
+	}
+	imgs := make([]C.struct_Buffer, len(dims))
 	defer func() {
-		if img.data != nil {
-			C.free(unsafe.Pointer(img.data))
+		for _, img := range imgs {
+			if img.data != nil {
+				C.free(unsafe.Pointer(img.data))
+			}
 		}
 	}()
-	ret := C.generate_thumbnail(&img, c.avFormatCtx, ci.ctx, ci.stream,
-		C.struct_Dims{
-			width:  C.uint64_t(dims.Width),
-			height: C.uint64_t(dims.Height),
-		})
-	switch {
-	case ret != 0:
-		err = castError(ret)
-	case img.data == nil:
-		err = ErrGetFrame
-	default:
-		thumb = &image.RGBA{
-			Pix:    copyCBuffer(img),
-			Stride: 4 * int(img.width),
-			Rect: image.Rectangle{
-				Max: image.Point{
-					X: int(img.width),
-					Y: int(img.height),
-				},
-			},
+	sample, freeSample := c.sampleBuffer()
+	defer freeSample()
+	ret := C.generate_thumbnail(&imgs[0], sample, c.avFormatCtx, ci.ctx,
+		ci.stream, &cDims[0], C.int(len(cDims)), c.scaleOptions())
+	if ret != 0 {
+		err = c.castError(ret)
+		return
+	}
+	c.storeSample(sample)
+	thumbs = make([]image.Image, len(imgs))
+	for i, img := range imgs {
+		if img.data == nil {
+			return nil, ErrGetFrame
 		}
+		thumbs[i] = rgbaImage(img)
 	}
 	return
 }
@@ -56,11 +157,15 @@ func processMedia(rs io.ReadSeeker, src *Source, opts Options,
 ) (
 	thumb image.Image, err error,
 ) {
-	c, err := newFFContextWithFormat(rs, inputFormats[src.Mime])
+	c, err := newFFContextWithFormat(opts.Context(), rs, src.Mime)
 	if err != nil {
 		return
 	}
 	defer c.Close()
+	c.ScaleFilter = opts.ScaleFilter
+	c.Fit = opts.Fit
+	c.Background = opts.Background
+	c.sampleFrames = true
 
 	src.Length = c.Length()
 	src.Meta = c.Meta()
@@ -80,7 +185,7 @@ func processMedia(rs io.ReadSeeker, src *Source, opts Options,
 	}
 
 	if c.HasCoverArt() {
-		thumb, err = processCoverArt(c.CoverArt(), opts)
+		thumb, err = processCoverArt(c.CoverArt(), src, opts)
 		switch err {
 		case nil:
 			return
@@ -106,9 +211,69 @@ func processMedia(rs io.ReadSeeker, src *Source, opts Options,
 		if err != nil {
 			return
 		}
-		thumb, err = c.Thumbnail(opts.ThumbDims)
+		if seek := seekTarget(src.Length, opts); seek > 0 {
+			// Falls back to the start of the stream on failure
+			c.Seek(seek)
+		}
+		if opts.Animated {
+			thumb, err = animatedThumbnail(c, opts)
+		} else {
+			thumb, err = thumbnails(c, opts)
+		}
+		src.sample = c.sample
+	} else if src.HasAudio &&
+		opts.AudioVisualization != AudioVisualizationNone {
+		thumb, err = visualizeAudio(c, src.Length, opts)
 	} else {
 		err = ErrCantThumbnail
 	}
 	return
 }
+
+// Resolve the position to seek to before thumbnailing from the media length.
+// Returns 0, if no seeking should be performed.
+func seekTarget(length time.Duration, opts Options) (seek time.Duration) {
+	if length <= 0 {
+		return
+	}
+	switch {
+	case opts.SeekTo > 0:
+		seek = opts.SeekTo
+	case opts.SeekPercent > 0:
+		seek = time.Duration(float64(length) * opts.SeekPercent / 100)
+	}
+	if seek > length {
+		seek = length
+	}
+	return
+}
+
+// Generate a thumbnail for each of opts.ThumbSizes or a single thumbnail, if
+// not set
+func thumbnails(c *FFContext, opts Options) (thumb image.Image, err error) {
+	if len(opts.ThumbSizes) <= 1 {
+		return c.Thumbnail(opts.ThumbDims)
+	}
+	thumbs, err := c.Thumbnails(opts.ThumbSizes)
+	if err != nil {
+		return
+	}
+	thumb = thumbnailSet(thumbs)
+	return
+}
+
+// Generate an animated thumbnail or a still one, if the media has only one
+// frame
+func animatedThumbnail(c *FFContext, opts Options,
+) (thumb image.Image, err error) {
+	anim, err := c.ThumbnailAnimation(opts.ThumbDims, opts.Animation)
+	if err != nil {
+		return
+	}
+	if len(anim.Frames) == 1 {
+		thumb = anim.Frames[0]
+	} else {
+		thumb = anim
+	}
+	return
+}
@@ -11,6 +11,63 @@ struct Dims {
     uint64_t width, height;
 };
 
-// Writes RGBA thumbnail buffer to img
-int generate_thumbnail(struct Buffer* img, AVFormatContext* avfc,
-    AVCodecContext* avcc, const int stream, const struct Dims thumb_dims);
+// Corresponds to ScaleFilter in Go
+enum ScaleFilter {
+    SCALE_POINT_BOX,
+    SCALE_BILINEAR,
+    SCALE_BICUBIC,
+    SCALE_AREA,
+    SCALE_LANCZOS,
+    SCALE_SPLINE,
+};
+
+// Corresponds to Fit in Go
+enum Fit {
+    FIT_CONTAIN,
+    FIT_COVER,
+    FIT_PAD,
+    FIT_SMART_CROP,
+};
+
+struct ScaleOptions {
+    enum ScaleFilter filter;
+    enum Fit fit;
+    uint8_t background[4]; // Premultiplied RGBA
+};
+
+struct AnimationOptions {
+    int max_frames;
+    int64_t max_duration; // In AV_TIME_BASE units
+    double frame_rate; // Unlimited, if 0
+};
+
+struct Animation {
+    struct Buffer* frames;
+    int64_t* delays; // In AV_TIME_BASE units
+    int size;
+};
+
+// Writes a RGBA thumbnail buffer for each of the dims_len thumb_dims bounding
+// boxes to imgs. All thumbnails are generated from the same frame.
+// If sample is not NULL, also writes a fixed size RGBA sample of the frame
+// for image analysis to it.
+int generate_thumbnail(struct Buffer* imgs, struct Buffer* sample,
+    AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const struct Dims* thumb_dims, const int dims_len,
+    const struct ScaleOptions scale);
+
+// Writes up to opts.max_frames scaled RGBA frames and their display durations
+// to anim. It is the responsibility of the caller to free anim->frames,
+// the data of each frame and anim->delays, even on error.
+// If sample is not NULL, also writes a sample of the first frame like
+// generate_thumbnail.
+int generate_animation(struct Animation* anim, struct Buffer* sample,
+    AVFormatContext* avfc, AVCodecContext* avcc, const int stream,
+    const struct Dims thumb_dims, const struct ScaleOptions scale,
+    const struct AnimationOptions opts);
+
+// Seeks to ts, specified in AV_TIME_BASE units, and writes the first RGBA
+// thumbnail frame at or after it to img
+int extract_frame(struct Buffer* img, AVFormatContext* avfc,
+    AVCodecContext* avcc, const int stream, const int64_t ts,
+    const struct Dims thumb_dims, const struct ScaleOptions scale);
@@ -1,8 +1,13 @@
 package thumbnailer
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/color"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestDimensionValidation(t *testing.T) {
@@ -144,3 +149,487 @@ func TestStackOverflow(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAnimatedThumbnail(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		file     string
+		animated bool
+	}{
+		{"no_sound.webm", true},
+		{"with_sound.mp4", true},
+		{"sample.jpg", false},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			opts := Options{
+				ThumbDims: Dims{150, 150},
+				Animated:  true,
+				Animation: AnimationOptions{
+					MaxFrames: 8,
+					FrameRate: 5,
+				},
+			}
+			_, thumb, err := Process(f, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			anim, ok := thumb.(*Animation)
+			if ok != c.animated {
+				t.Fatalf("unexpected thumbnail type: %T", thumb)
+			}
+			if !ok {
+				return
+			}
+
+			if len(anim.Frames) < 2 || len(anim.Frames) > 8 {
+				t.Fatalf("unexpected frame count: %d", len(anim.Frames))
+			}
+			if len(anim.Delays) != len(anim.Frames) {
+				t.Fatal("frame and delay count mismatch")
+			}
+			for i, f := range anim.Frames {
+				if f.Bounds() != anim.Bounds() {
+					t.Fatalf("frame %d bounds mismatch", i)
+				}
+				// Frame rate is capped at 5 per second
+				if anim.Delays[i] < 200*time.Millisecond {
+					t.Fatalf("frame %d delay too short: %s", i, anim.Delays[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAnimationGIF(t *testing.T) {
+	t.Parallel()
+
+	frame := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	anim := &Animation{
+		Frames: []*image.RGBA{frame, frame, frame, frame},
+		Delays: []time.Duration{
+			0,
+			5 * time.Millisecond,
+			101 * time.Millisecond,
+			time.Second,
+		},
+	}
+
+	g := anim.GIF()
+	for i, d := range [...]int{2, 2, 11, 100} {
+		if g.Delay[i] != d {
+			t.Fatalf("frame %d: expected delay %d, got %d", i, d, g.Delay[i])
+		}
+	}
+}
+
+func TestSeekTarget(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name         string
+		length, seek time.Duration
+		opts         Options
+	}{
+		{
+			name:   "no seeking",
+			length: time.Minute,
+		},
+		{
+			name:   "absolute",
+			length: time.Minute,
+			seek:   10 * time.Second,
+			opts: Options{
+				SeekTo: 10 * time.Second,
+			},
+		},
+		{
+			name:   "percentage",
+			length: time.Minute,
+			seek:   15 * time.Second,
+			opts: Options{
+				SeekPercent: 25,
+			},
+		},
+		{
+			name:   "absolute takes precedence",
+			length: time.Minute,
+			seek:   10 * time.Second,
+			opts: Options{
+				SeekTo:      10 * time.Second,
+				SeekPercent: 25,
+			},
+		},
+		{
+			name:   "past end",
+			length: time.Minute,
+			seek:   time.Minute,
+			opts: Options{
+				SeekTo: time.Hour,
+			},
+		},
+		{
+			name: "unknown length",
+			opts: Options{
+				SeekTo: 10 * time.Second,
+			},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			seek := seekTarget(c.length, c.opts)
+			if seek != c.seek {
+				t.Fatalf("unexpected seek target: %s : %s", c.seek, seek)
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	t.Parallel()
+
+	process := func(t *testing.T, opts Options) *image.RGBA {
+		t.Helper()
+
+		f := openSample(t, "start_black.webm")
+		defer f.Close()
+
+		_, thumb, err := Process(f, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, ok := thumb.(*image.RGBA)
+		if !ok {
+			t.Fatalf("unexpected thumbnail type: %T", thumb)
+		}
+		return img
+	}
+
+	unseeked := process(t, Options{})
+
+	cases := [...]struct {
+		opts Options
+		// Seeking changes the selected frame
+		seeked bool
+	}{
+		{Options{SeekTo: time.Second}, true},
+		{Options{SeekPercent: 50}, true},
+		// Clamped to the end of the media
+		{Options{SeekTo: time.Hour}, false},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(fmt.Sprintf("%s %.0f%%", c.opts.SeekTo, c.opts.SeekPercent),
+			func(t *testing.T) {
+				t.Parallel()
+
+				thumb := process(t, c.opts)
+				writeSample(
+					t,
+					fmt.Sprintf(
+						"start_black.webm_seek_%s_%.0f_thumb.png",
+						c.opts.SeekTo, c.opts.SeekPercent,
+					),
+					thumb,
+				)
+				if thumb.Bounds() != unseeked.Bounds() {
+					t.Fatalf("unexpected bounds: %v : %v", unseeked.Bounds(),
+						thumb.Bounds())
+				}
+				if c.seeked && bytes.Equal(thumb.Pix, unseeked.Pix) {
+					t.Fatal("thumbnail same as without seeking")
+				}
+			})
+	}
+}
+
+func TestProcessMulti(t *testing.T) {
+	t.Parallel()
+
+	sizes := []Dims{{150, 150}, {300, 300}, {1024, 1024}}
+
+	for _, sample := range [...]string{
+		"sample.jpg",
+		"no_sound.webm",
+		"with_cover.mp3",
+		"sample.zip",
+	} {
+		sample := sample
+		t.Run(sample, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, sample)
+			defer f.Close()
+
+			_, thumbs, err := ProcessMulti(f, Options{
+				ThumbSizes: sizes,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(thumbs) != len(sizes) {
+				t.Fatalf("unexpected thumbnail count: %d", len(thumbs))
+			}
+			for i, thumb := range thumbs {
+				m := thumb.Bounds().Max
+				if uint(m.X) > sizes[i].Width || uint(m.Y) > sizes[i].Height {
+					t.Fatalf(
+						"thumbnail exceeds bounds: %+v not inside  %+v",
+						m,
+						sizes[i],
+					)
+				}
+				writeSample(
+					t,
+					fmt.Sprintf(
+						"%s_multi_%dx%d_thumb.png",
+						sample, sizes[i].Width, sizes[i].Height,
+					),
+					thumb,
+				)
+			}
+		})
+	}
+}
+
+func TestThumbnailsNoDims(t *testing.T) {
+	t.Parallel()
+
+	f := openSample(t, "sample.jpg")
+	defer f.Close()
+
+	c, err := NewFFContext(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	thumbs, err := c.Thumbnails(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbs) != 0 {
+		t.Fatalf("unexpected thumbnails: %d", len(thumbs))
+	}
+}
+
+func TestScaleFilters(t *testing.T) {
+	t.Parallel()
+
+	filters := [...]struct {
+		name   string
+		filter ScaleFilter
+	}{
+		{"point_box", ScalePointBox},
+		{"bilinear", ScaleBilinear},
+		{"bicubic", ScaleBicubic},
+		{"area", ScaleArea},
+		{"lanczos", ScaleLanczos},
+		{"spline", ScaleSpline},
+	}
+
+	for i := range filters {
+		c := filters[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, "sample.png")
+			defer f.Close()
+
+			_, thumb, err := Process(f, Options{
+				ThumbDims:   Dims{150, 150},
+				ScaleFilter: c.filter,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := thumb.Bounds().Max
+			if m.X > 150 || m.Y > 150 {
+				t.Fatalf("thumbnail exceeds bounds: %+v", m)
+			}
+			writeSample(t, fmt.Sprintf("sample.png_%s_thumb.png", c.name),
+				thumb)
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		name, file string
+		fit        Fit
+		box, dims  Dims
+	}{
+		{
+			"contain", "non_square.png", FitContain,
+			Dims{100, 100}, Dims{100, 74},
+		},
+		{
+			"cover", "non_square.png", FitCover,
+			Dims{100, 100}, Dims{100, 100},
+		},
+		{
+			"pad", "non_square.png", FitPad,
+			Dims{100, 100}, Dims{100, 100},
+		},
+		{
+			"smart crop", "non_square.png", FitSmartCrop,
+			Dims{100, 100}, Dims{100, 100},
+		},
+		{
+			"cover rotated", "jannu_90.jpg", FitCover,
+			Dims{100, 100}, Dims{100, 100},
+		},
+		{
+			"pad rotated", "jannu_90.jpg", FitPad,
+			Dims{100, 100}, Dims{100, 100},
+		},
+		// Padding an unbounded dimension is capped
+		{
+			"pad unbounded", "non_square.png", FitPad,
+			Dims{math.MaxUint32, 100}, Dims{16384, 100},
+		},
+		// The 121x150 source is narrower than the box and is only cropped to
+		// its aspect ratio without upscaling
+		{
+			"cover small", "too small.png", FitCover,
+			Dims{200, 100}, Dims{121, 60},
+		},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			_, thumb, err := Process(f, Options{
+				ThumbDims: c.box,
+				Fit:       c.fit,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := thumb.Bounds().Max
+			if uint(m.X) != c.dims.Width || uint(m.Y) != c.dims.Height {
+				t.Fatalf("unexpected dimensions: %v : %v", c.dims, m)
+			}
+			writeSample(
+				t,
+				fmt.Sprintf("%s_fit_%d_thumb.png", c.file, c.fit),
+				thumb,
+			)
+		})
+	}
+}
+
+func TestImageFormats(t *testing.T) {
+	t.Parallel()
+
+	cases := [...]struct {
+		file, mime string
+	}{
+		{"sample.bmp", "image/bmp"},
+		{"sample.tiff", "image/tiff"},
+		{"sample.ico", "image/x-icon"},
+		{"sample.tga", "image/x-tga"},
+		{"sample.qoi", "image/qoi"},
+		{"sample.dds", "image/vnd-ms.dds"},
+		{"sample.pcx", "image/x-pcx"},
+		{"sample.sgi", "image/sgi"},
+		{"sample.exr", "image/x-exr"},
+		{"sample.hdr", "image/vnd.radiance"},
+	}
+
+	for i := range cases {
+		c := cases[i]
+		t.Run(c.file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, c.file)
+			defer f.Close()
+
+			src, thumb, err := Process(f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.Mime != c.mime {
+				t.Fatalf("expected MIME type %s, got %s", c.mime, src.Mime)
+			}
+			if src.Dims != (Dims{64, 48}) {
+				t.Fatalf("unexpected source dimensions: %v", src.Dims)
+			}
+			if src.HasVideo {
+				t.Fatal("image reported as video")
+			}
+
+			// Gradient from the top left to the bottom right corner
+			b := thumb.Bounds()
+			first := color.GrayModel.Convert(thumb.At(0, 0)).(color.Gray)
+			last := color.GrayModel.Convert(
+				thumb.At(b.Max.X-1, b.Max.Y-1),
+			).(color.Gray)
+			if first.Y >= last.Y {
+				t.Fatalf("unexpected gradient: %v -> %v", first, last)
+			}
+		})
+	}
+}
+
+func TestToneMapping(t *testing.T) {
+	t.Parallel()
+
+	for _, file := range [...]string{"sample.exr", "sample.hdr"} {
+		file := file
+		t.Run(file, func(t *testing.T) {
+			t.Parallel()
+
+			f := openSample(t, file)
+			defer f.Close()
+
+			_, thumb, err := Process(f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Linear values of up to 16 must neither leave the image nearly
+			// black nor wash out the highlights
+			gray := func(x, y int) int {
+				return int(color.GrayModel.Convert(thumb.At(x, y)).(color.Gray).Y)
+			}
+			var sum int
+			b := thumb.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					sum += gray(x, y)
+				}
+			}
+			if avg := sum / (b.Dx() * b.Dy()); avg < 32 || avg > 224 {
+				t.Fatalf("unexpected average brightness: %d", avg)
+			}
+			y := b.Max.Y - 1
+			mid, last := gray(b.Max.X/2, y), gray(b.Max.X-1, y)
+			if mid >= last || last == 0xff {
+				t.Fatalf("highlights not preserved: %d -> %d", mid, last)
+			}
+			writeSample(t, file+"_thumb.png", thumb)
+		})
+	}
+}
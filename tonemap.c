@@ -0,0 +1,155 @@
+#include "tonemap.h"
+#include <float.h>
+#include <math.h>
+
+// Value the log-average luminance of the image is mapped to. Middle grey.
+#define TONE_MAP_KEY 0.18
+
+// Prevents the logarithm of black pixels from being undefined
+#define TONE_MAP_DELTA 1e-6
+
+// Returns, if pixels of the format store linear light floating point values
+static int is_hdr_format(const int format)
+{
+    switch (format) {
+    case AV_PIX_FMT_GBRPF32:
+    case AV_PIX_FMT_GBRAPF32:
+#ifdef AV_PIX_FMT_GRAYF32
+    case AV_PIX_FMT_GRAYF32:
+#endif
+        return 1;
+    default:
+        return 0;
+    }
+}
+
+// Sample at x,y of a floating point plane. NaN and negative values are
+// clamped to 0 and infinity to the largest finite value.
+static float sample_value(
+    const AVFrame* frame, const int plane, const int x, const int y)
+{
+    const float v = ((const float*)(frame->data[plane]
+        + (ptrdiff_t)y * frame->linesize[plane]))[x];
+    if (!(v > 0)) {
+        return 0;
+    }
+    return v < FLT_MAX ? v : FLT_MAX;
+}
+
+// Read the linear RGBA values of the pixel at x,y
+static void read_pixel(
+    const AVFrame* frame, const int x, const int y, float px[4])
+{
+    px[3] = 1;
+    switch (frame->format) {
+#ifdef AV_PIX_FMT_GRAYF32
+    case AV_PIX_FMT_GRAYF32:
+        px[0] = px[1] = px[2] = sample_value(frame, 0, x, y);
+        return;
+#endif
+    case AV_PIX_FMT_GBRAPF32:
+        px[3] = sample_value(frame, 3, x, y);
+        if (px[3] > 1) {
+            px[3] = 1;
+        }
+        // Fallthrough
+    default:
+        // Planes are stored in GBR order
+        px[0] = sample_value(frame, 2, x, y);
+        px[1] = sample_value(frame, 0, x, y);
+        px[2] = sample_value(frame, 1, x, y);
+    }
+}
+
+// Relative luminance of linear Rec. 709 primaries
+static inline double luminance(const float px[4])
+{
+    return 0.2126 * px[0] + 0.7152 * px[1] + 0.0722 * px[2];
+}
+
+// Encode a linear value in the range [0, 1] with the sRGB transfer function
+static uint8_t srgb(double v)
+{
+    if (v > 1) {
+        v = 1;
+    }
+    if (v <= 0.0031308) {
+        v *= 12.92;
+    } else {
+        v = 1.055 * pow(v, 1 / 2.4) - 0.055;
+    }
+    return (uint8_t)lround(v * 255);
+}
+
+int tone_map(AVFrame* frame)
+{
+    if (!is_hdr_format(frame->format)) {
+        return 0;
+    }
+
+    int err = 0;
+    float px[4];
+    AVFrame* out = av_frame_alloc();
+    if (!out) {
+        return AVERROR(ENOMEM);
+    }
+    out->format = AV_PIX_FMT_RGBA;
+    out->width = frame->width;
+    out->height = frame->height;
+    err = av_frame_get_buffer(out, 0);
+    if (err < 0) {
+        goto end;
+    }
+    err = av_frame_copy_props(out, frame);
+    if (err < 0) {
+        goto end;
+    }
+    out->color_trc = AVCOL_TRC_IEC61966_2_1;
+
+    // Scale the log-average luminance of the image to the key value, as by
+    // the global operator of Reinhard et al.
+    double log_sum = 0;
+    for (int y = 0; y < frame->height; y++) {
+        for (int x = 0; x < frame->width; x++) {
+            read_pixel(frame, x, y, px);
+            log_sum += log(TONE_MAP_DELTA + luminance(px));
+        }
+    }
+    const double avg
+        = exp(log_sum / ((double)frame->width * (double)frame->height));
+    const double exposure = TONE_MAP_KEY / avg;
+
+    for (int y = 0; y < frame->height; y++) {
+        uint8_t* row = out->data[0] + (ptrdiff_t)y * out->linesize[0];
+        for (int x = 0; x < frame->width; x++) {
+            read_pixel(frame, x, y, px);
+
+            // Compress luminance into [0, 1) and scale all channels equally
+            // to preserve hue. Saturated colours are limited by their
+            // brightest channel instead of clipping.
+            const double l = luminance(px);
+            double scale = 0;
+            if (l > 0) {
+                const double scaled = l * exposure;
+                scale = scaled / (1 + scaled) / l;
+                const float max = fmaxf(px[0], fmaxf(px[1], px[2]));
+                if (max * scale > 1) {
+                    scale = 1 / max;
+                }
+            }
+
+            uint8_t* p = row + x * 4;
+            for (int i = 0; i < 3; i++) {
+                p[i] = srgb(px[i] * scale);
+            }
+            p[3] = (uint8_t)lround(px[3] * 255);
+        }
+    }
+
+    av_frame_unref(frame);
+    av_frame_move_ref(frame, out);
+
+end:
+    av_frame_free(&out);
+    return err;
+}
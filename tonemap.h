@@ -0,0 +1,7 @@
+#pragma once
+#include "ffmpeg.h"
+
+// Convert frames with linear light floating point samples, as decoded from
+// OpenEXR and Radiance HDR images, to 8 bit sRGB RGBA in place with a global
+// tone mapping operator. Frames in other pixel formats are left unchanged.
+int tone_map(AVFrame* frame);
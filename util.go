@@ -3,7 +3,14 @@ package thumbnailer
 // #include "thumbnailer.h"
 // #include "string.h"
 import "C"
-import "unsafe"
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"unsafe"
+)
 
 // Copy a C buffer into a Go buffer from the pool
 func copyCBuffer(src C.struct_Buffer) []byte {
@@ -11,3 +18,41 @@ func copyCBuffer(src C.struct_Buffer) []byte {
 	C.memcpy(unsafe.Pointer(&buf[0]), unsafe.Pointer(src.data), src.size)
 	return buf
 }
+
+// Copy a C RGBA buffer into a Go image
+func rgbaImage(src C.struct_Buffer) *image.RGBA {
+	return &image.RGBA{
+		Pix:    copyCBuffer(src),
+		Stride: 4 * int(src.width),
+		Rect: image.Rectangle{
+			Max: image.Point{
+				X: int(src.width),
+				Y: int(src.height),
+			},
+		},
+	}
+}
+
+// Reader that fails with the context's error, once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// Encode a decoded image to a format processable by FFmpeg. The image is only
+// encoded to be decoded again, so it is not compressed.
+func encodeIntermediate(img image.Image) ([]byte, error) {
+	var w bytes.Buffer
+	enc := png.Encoder{
+		CompressionLevel: png.NoCompression,
+	}
+	err := enc.Encode(&w, img)
+	return w.Bytes(), err
+}
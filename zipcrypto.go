@@ -0,0 +1,101 @@
+package thumbnailer
+
+import (
+	"archive/zip"
+	deflate "compress/flate"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Size of the encryption header preceding the data of encrypted zip entries
+const zipCryptoHeaderSize = 12
+
+// Open a zip entry, decrypting it with password, if it is encrypted. Returns
+// ErrEncrypted, if the entry can not be decrypted.
+func openZipFile(f *zip.File, ra io.ReaderAt, password string,
+) (io.ReadCloser, error) {
+	if f.Flags&0x1 == 0 {
+		return f.Open()
+	}
+	// Strong encryption and AES encrypted entries using method 99 are not
+	// supported
+	if password == "" ||
+		f.Flags&0x40 != 0 ||
+		f.Method != zip.Store && f.Method != zip.Deflate {
+		return nil, ErrEncrypted
+	}
+
+	off, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	d := newZipCrypto(password)
+	r := io.NewSectionReader(ra, off, int64(f.CompressedSize64))
+	var head [zipCryptoHeaderSize]byte
+	_, err = io.ReadFull(r, head[:])
+	if err != nil {
+		return nil, err
+	}
+	d.decrypt(head[:])
+
+	// The last byte of the header is used to verify the password. It is the
+	// high byte of the CRC32 or of the modification time for entries with a
+	// data descriptor.
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if head[zipCryptoHeaderSize-1] != check {
+		return nil, ErrEncrypted
+	}
+
+	dr := zipCryptoReader{d, r}
+	if f.Method == zip.Deflate {
+		return deflate.NewReader(dr), nil
+	}
+	return ioutil.NopCloser(dr), nil
+}
+
+// Keys of the traditional PKWARE zip encryption
+type zipCrypto [3]uint32
+
+func newZipCrypto(password string) *zipCrypto {
+	z := zipCrypto{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	return &z
+}
+
+func (z *zipCrypto) update(b byte) {
+	z[0] = crc32Update(z[0], b)
+	z[1] = (z[1]+z[0]&0xFF)*134775813 + 1
+	z[2] = crc32Update(z[2], byte(z[1]>>24))
+}
+
+// Decrypt buf in place
+func (z *zipCrypto) decrypt(buf []byte) {
+	for i, c := range buf {
+		k := z[2] | 2
+		buf[i] = c ^ byte(k*(k^1)>>8)
+		z.update(buf[i])
+	}
+}
+
+// Update a CRC32 with a single byte, as used for key derivation
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+// Decrypts the data of an encrypted zip entry following the encryption header
+type zipCryptoReader struct {
+	*zipCrypto
+	r io.Reader
+}
+
+func (z zipCryptoReader) Read(p []byte) (n int, err error) {
+	n, err = z.r.Read(p)
+	z.decrypt(p[:n])
+	return
+}